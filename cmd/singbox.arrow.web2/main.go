@@ -8,7 +8,10 @@ import (
 
 	"singbox.arrow.web2/internal/api"
 	"singbox.arrow.web2/internal/api/handlers"
+	"singbox.arrow.web2/internal/core/ruleset"
+	"singbox.arrow.web2/internal/core/scheduler"
 	"singbox.arrow.web2/internal/core/singbox"
+	"singbox.arrow.web2/internal/core/traffic"
 	"singbox.arrow.web2/internal/storage"
 )
 
@@ -33,6 +36,7 @@ func main() {
 
 	// Initialize handlers
 	handlers.InitSystemHandlers(dataDir)
+	ruleset.Init(dataDir)
 
 	// Recover from crash
 	manager := singbox.GetManager(dataDir)
@@ -40,6 +44,15 @@ func main() {
 		log.Printf("Failed to recover: %v", err)
 	}
 
+	// Start scheduled jobs
+	if err := scheduler.Init(dataDir); err != nil {
+		log.Fatalf("Failed to initialize scheduler: %v", err)
+	}
+	scheduler.Start()
+
+	// Collect traffic statistics from the running core
+	traffic.Start(manager)
+
 	// Get port from settings
 	port, err := storage.GetSetting("web_port")
 	if err != nil {
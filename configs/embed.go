@@ -0,0 +1,9 @@
+// Package configs holds the data files built into the binary
+package configs
+
+import _ "embed"
+
+// RulesetCenter is the default rule set center catalog
+//
+//go:embed ruleset-center.json
+var RulesetCenter []byte
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// auditIgnored are fields every save touches, they are left out of diffs
+var auditIgnored = map[string]bool{"created_at": true, "updated_at": true}
+
+// auditSecrets are config keys whose values never go into the operation log, at
+// any depth, e.g. users[].password of an inbound
+var auditSecrets = map[string]bool{
+	"password": true, "uuid": true, "private_key": true, "pre_shared_key": true, "psk": true,
+	"auth": true, "auth_str": true, "token": true, "secret": true, "key": true, "short_id": true,
+}
+
+// redactedValue replaces secrets in diffs. A field whose only change is a secret
+// shows it on both sides.
+const redactedValue = "REDACTED"
+
+// auditChange is one field of a diff
+type auditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// logOperation records an operation performed through the API, attributed to the
+// signed-in user and the client the request came from
+func logOperation(c *gin.Context, op storage.OperationLog) {
+	if op.Actor == "" {
+		op.Actor = c.GetString("username")
+	}
+	op.ClientIP = c.ClientIP()
+	op.UserAgent = c.Request.UserAgent()
+	op.CreatedAt = time.Now()
+	storage.DB.Create(&op)
+}
+
+// auditDiff returns the fields that differ between two snapshots of an entity as
+// JSON, keyed by snake_case field name. A nil before records a creation, a nil
+// after a deletion. Nothing changed gives an empty string.
+func auditDiff(before, after interface{}) string {
+	b, a := auditSnapshot(before), auditSnapshot(after)
+	changes := make(map[string]auditChange)
+	for key, value := range b {
+		if other, ok := a[key]; !ok || !reflect.DeepEqual(value, other) {
+			changes[key] = auditChange{Before: redact(key, value), After: redact(key, a[key])}
+		}
+	}
+	for key, value := range a {
+		if _, ok := b[key]; !ok {
+			changes[key] = auditChange{After: redact(key, value)}
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func auditSnapshot(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal(data, &fields) != nil {
+		return nil
+	}
+	snapshot := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		key = snakeCase(key)
+		if auditIgnored[key] {
+			continue
+		}
+		// Configs are stored as JSON text, expand them so secrets inside can
+		// be redacted
+		if text, ok := value.(string); ok && key == "config" {
+			var config interface{}
+			if json.Unmarshal([]byte(text), &config) == nil {
+				value = config
+			}
+		}
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// redact masks credentials in a snapshot value: secret config keys, and the
+// userinfo and query of URLs, which often carry subscription tokens
+func redact(key string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if auditSecrets[key] {
+		return redactedValue
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			redacted[k] = redact(k, item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redact("", item)
+		}
+		return redacted
+	case string:
+		if key == "url" || strings.HasSuffix(key, "_url") {
+			return redactURL(v)
+		}
+	}
+	return value
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		if raw == "" {
+			return raw
+		}
+		return redactedValue
+	}
+	if u.User != nil {
+		u.User = url.User(redactedValue)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for k := range query {
+			query.Set(k, redactedValue)
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// snakeCase turns the Go field names of storage models into the API's style,
+// e.g. SubscriptionID into subscription_id. Keys already in snake_case are kept.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])
+			if prevLower || nextLower {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
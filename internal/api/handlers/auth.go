@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -46,12 +45,14 @@ func Login(c *gin.Context) {
 	}
 
 	// Verify credentials
-	if req.Username != storedUsername {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
-		return
-	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(storedPasswordHash), []byte(req.Password)); err != nil {
+	if req.Username != storedUsername ||
+		bcrypt.CompareHashAndPassword([]byte(storedPasswordHash), []byte(req.Password)) != nil {
+		// Log operation
+		logOperation(c, storage.OperationLog{
+			Action: "login_failed",
+			Detail: "Failed login attempt: " + req.Username,
+			Actor:  req.Username,
+		})
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
@@ -64,10 +65,10 @@ func Login(c *gin.Context) {
 	}
 
 	// Log operation
-	storage.DB.Create(&storage.OperationLog{
-		Action:    "login",
-		Detail:    "User logged in: " + req.Username,
-		CreatedAt: time.Now(),
+	logOperation(c, storage.OperationLog{
+		Action: "login",
+		Detail: "User logged in: " + req.Username,
+		Actor:  req.Username,
 	})
 
 	c.JSON(http.StatusOK, LoginResponse{
@@ -80,10 +81,9 @@ func Logout(c *gin.Context) {
 	username := c.GetString("username")
 
 	// Log operation
-	storage.DB.Create(&storage.OperationLog{
-		Action:    "logout",
-		Detail:    "User logged out: " + username,
-		CreatedAt: time.Now(),
+	logOperation(c, storage.OperationLog{
+		Action: "logout",
+		Detail: "User logged out: " + username,
 	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
@@ -122,10 +122,11 @@ func ChangePassword(c *gin.Context) {
 	}
 
 	// Log operation
-	storage.DB.Create(&storage.OperationLog{
-		Action:    "change_password",
-		Detail:    "Password changed",
-		CreatedAt: time.Now(),
+	logOperation(c, storage.OperationLog{
+		Action:     "change_password",
+		Detail:     "Password changed",
+		TargetType: "user",
+		TargetName: c.GetString("username"),
 	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "password changed"})
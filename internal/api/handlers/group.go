@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"singbox.arrow.web2/internal/core/generator"
+	"singbox.arrow.web2/internal/storage"
+)
+
+type GroupRequest struct {
+	Name            string `json:"name" binding:"required"`
+	Tag             string `json:"tag"` // optional, derived from the name on create
+	Type            string `json:"type" binding:"required"`
+	OutboundIDs     []uint `json:"outbound_ids"`
+	GroupIDs        []uint `json:"group_ids"`
+	SubscriptionIDs []uint `json:"subscription_ids"`
+	NamePattern     string `json:"name_pattern"`
+	Default         string `json:"default"`
+	URL             string `json:"url"`
+	Interval        string `json:"interval"`
+	Tolerance       int    `json:"tolerance"`
+	Enabled         *bool  `json:"enabled"`
+}
+
+type GroupResponse struct {
+	ID              uint      `json:"id"`
+	Name            string    `json:"name"`
+	Tag             string    `json:"tag"`
+	Type            string    `json:"type"`
+	OutboundIDs     []uint    `json:"outbound_ids"`
+	GroupIDs        []uint    `json:"group_ids"`
+	SubscriptionIDs []uint    `json:"subscription_ids"`
+	NamePattern     string    `json:"name_pattern"`
+	Default         string    `json:"default"`
+	URL             string    `json:"url"`
+	Interval        string    `json:"interval"`
+	Tolerance       int       `json:"tolerance"`
+	Enabled         bool      `json:"enabled"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+
+	// Members are the tags the group resolves to in the generated config
+	Members []string `json:"members"`
+}
+
+func newGroupResponse(g storage.OutboundGroup, members map[uint][]string) GroupResponse {
+	decode := func(raw string) []uint {
+		ids, _ := generator.DecodeIDs(raw)
+		if ids == nil {
+			ids = []uint{}
+		}
+		return ids
+	}
+	resolved := members[g.ID]
+	if resolved == nil {
+		resolved = []string{}
+	}
+	return GroupResponse{
+		ID:              g.ID,
+		Name:            g.Name,
+		Tag:             g.Tag,
+		Type:            g.Type,
+		OutboundIDs:     decode(g.OutboundIDs),
+		GroupIDs:        decode(g.GroupIDs),
+		SubscriptionIDs: decode(g.SubscriptionIDs),
+		NamePattern:     g.NamePattern,
+		Default:         g.Default,
+		URL:             g.URL,
+		Interval:        g.Interval,
+		Tolerance:       g.Tolerance,
+		Enabled:         g.Enabled,
+		CreatedAt:       g.CreatedAt,
+		UpdatedAt:       g.UpdatedAt,
+		Members:         resolved,
+	}
+}
+
+func ListGroups(c *gin.Context) {
+	var groups []storage.OutboundGroup
+	if err := storage.DB.Order("id").Find(&groups).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list groups"})
+		return
+	}
+
+	members, err := generator.GroupMembers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]GroupResponse, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, newGroupResponse(g, members))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func CreateGroup(c *gin.Context) {
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var group storage.OutboundGroup
+	if !applyGroupRequest(c, &req, &group) {
+		return
+	}
+	group.Enabled = req.Enabled == nil || *req.Enabled
+
+	if err := storage.DB.Create(&group).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create group"})
+		return
+	}
+	if req.Enabled != nil && !*req.Enabled {
+		storage.DB.Model(&group).Update("enabled", false)
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "group_create",
+		Detail:     fmt.Sprintf("Group created: %s (%s)", group.Name, group.Type),
+		TargetType: "group",
+		TargetID:   group.ID,
+		TargetName: group.Name,
+		Diff:       auditDiff(nil, group),
+	})
+
+	respondGroup(c, group)
+}
+
+func UpdateGroup(c *gin.Context) {
+	group, ok := findGroup(c)
+	if !ok {
+		return
+	}
+	before := group
+
+	var req GroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	oldTag := group.Tag
+	if !applyGroupRequest(c, &req, &group) {
+		return
+	}
+	if req.Enabled != nil {
+		group.Enabled = *req.Enabled
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&group).Error; err != nil {
+			return err
+		}
+		if group.Tag != oldTag {
+			return renameOutboundTag(tx, oldTag, group.Tag)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update group"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "group_update",
+		Detail:     fmt.Sprintf("Group updated: %s (%s)", group.Name, group.Type),
+		TargetType: "group",
+		TargetID:   group.ID,
+		TargetName: group.Name,
+		Diff:       auditDiff(before, group),
+	})
+
+	respondGroup(c, group)
+}
+
+func DeleteGroup(c *gin.Context) {
+	group, ok := findGroup(c)
+	if !ok {
+		return
+	}
+
+	if err := storage.DB.Delete(&group).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete group"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "group_delete",
+		Detail:     "Group deleted: " + group.Name,
+		TargetType: "group",
+		TargetID:   group.ID,
+		TargetName: group.Name,
+		Diff:       auditDiff(group, nil),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "group deleted"})
+}
+
+func ToggleGroup(c *gin.Context) {
+	group, ok := findGroup(c)
+	if !ok {
+		return
+	}
+
+	group.Enabled = !group.Enabled
+	if err := storage.DB.Model(&group).Update("enabled", group.Enabled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to toggle group"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "group_toggle",
+		Detail:     fmt.Sprintf("Group %s enabled=%t", group.Name, group.Enabled),
+		TargetType: "group",
+		TargetID:   group.ID,
+		TargetName: group.Name,
+		Diff:       auditDiff(gin.H{"enabled": !group.Enabled}, gin.H{"enabled": group.Enabled}),
+	})
+
+	respondGroup(c, group)
+}
+
+func respondGroup(c *gin.Context, group storage.OutboundGroup) {
+	members, _ := generator.GroupMembers()
+	c.JSON(http.StatusOK, newGroupResponse(group, members))
+}
+
+func findGroup(c *gin.Context) (storage.OutboundGroup, bool) {
+	var group storage.OutboundGroup
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return group, false
+	}
+	if err := storage.DB.First(&group, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+		return group, false
+	}
+	return group, true
+}
+
+// applyGroupRequest copies the request onto the row and validates the result
+func applyGroupRequest(c *gin.Context, req *GroupRequest, group *storage.OutboundGroup) bool {
+	encode := func(ids []uint) string {
+		if len(ids) == 0 {
+			return ""
+		}
+		data, _ := json.Marshal(ids)
+		return string(data)
+	}
+
+	if req.Tag != "" && req.Tag != group.Tag {
+		taken, err := storage.GroupTagTaken(storage.DB, req.Tag, group.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check tag"})
+			return false
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("tag %q is already in use", req.Tag)})
+			return false
+		}
+		group.Tag = req.Tag
+	}
+
+	group.Name = req.Name
+	group.Type = req.Type
+	group.OutboundIDs = encode(req.OutboundIDs)
+	group.GroupIDs = encode(req.GroupIDs)
+	group.SubscriptionIDs = encode(req.SubscriptionIDs)
+	group.NamePattern = req.NamePattern
+	group.Default = req.Default
+	group.URL = req.URL
+	group.Interval = req.Interval
+	group.Tolerance = req.Tolerance
+
+	if err := generator.ValidateGroup(group); err != nil {
+		var verr *generator.ValidationError
+		if errors.As(err, &verr) {
+			respondFieldErrors(c, verr.Fields)
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"singbox.arrow.web2/internal/core/generator"
+	"singbox.arrow.web2/internal/storage"
+)
+
+type InboundRequest struct {
+	Name    string          `json:"name" binding:"required"`
+	Type    string          `json:"type" binding:"required"`
+	Config  json.RawMessage `json:"config" binding:"required"`
+	Enabled *bool           `json:"enabled"`
+}
+
+type InboundResponse struct {
+	ID        uint            `json:"id"`
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Config    json.RawMessage `json:"config"`
+	Enabled   bool            `json:"enabled"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func newInboundResponse(in storage.Inbound) InboundResponse {
+	config := json.RawMessage(in.Config)
+	if !json.Valid(config) {
+		config = json.RawMessage("{}")
+	}
+	return InboundResponse{
+		ID:        in.ID,
+		Name:      in.Name,
+		Type:      in.Type,
+		Config:    config,
+		Enabled:   in.Enabled,
+		CreatedAt: in.CreatedAt,
+		UpdatedAt: in.UpdatedAt,
+	}
+}
+
+func ListInbounds(c *gin.Context) {
+	var inbounds []storage.Inbound
+	if err := storage.DB.Order("id").Find(&inbounds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list inbounds"})
+		return
+	}
+
+	result := make([]InboundResponse, 0, len(inbounds))
+	for _, in := range inbounds {
+		result = append(result, newInboundResponse(in))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func CreateInbound(c *gin.Context) {
+	var req InboundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, ok := validateInboundRequest(c, &req, 0)
+	if !ok {
+		return
+	}
+
+	inbound := storage.Inbound{
+		Name:    req.Name,
+		Type:    req.Type,
+		Config:  config,
+		Enabled: req.Enabled == nil || *req.Enabled,
+	}
+	if err := storage.DB.Create(&inbound).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create inbound"})
+		return
+	}
+	// GORM skips zero values on create, so a disabled inbound needs an explicit update
+	if req.Enabled != nil && !*req.Enabled {
+		storage.DB.Model(&inbound).Update("enabled", false)
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "inbound_create",
+		Detail:     fmt.Sprintf("Inbound created: %s (%s)", inbound.Name, inbound.Type),
+		TargetType: "inbound",
+		TargetID:   inbound.ID,
+		TargetName: inbound.Name,
+		Diff:       auditDiff(nil, inbound),
+	})
+
+	c.JSON(http.StatusOK, newInboundResponse(inbound))
+}
+
+func UpdateInbound(c *gin.Context) {
+	inbound, ok := findInbound(c)
+	if !ok {
+		return
+	}
+	before := inbound
+
+	var req InboundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, ok := validateInboundRequest(c, &req, inbound.ID)
+	if !ok {
+		return
+	}
+
+	inbound.Name = req.Name
+	inbound.Type = req.Type
+	inbound.Config = config
+	if req.Enabled != nil {
+		inbound.Enabled = *req.Enabled
+	}
+	if err := storage.DB.Save(&inbound).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update inbound"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "inbound_update",
+		Detail:     fmt.Sprintf("Inbound updated: %s (%s)", inbound.Name, inbound.Type),
+		TargetType: "inbound",
+		TargetID:   inbound.ID,
+		TargetName: inbound.Name,
+		Diff:       auditDiff(before, inbound),
+	})
+
+	c.JSON(http.StatusOK, newInboundResponse(inbound))
+}
+
+func DeleteInbound(c *gin.Context) {
+	inbound, ok := findInbound(c)
+	if !ok {
+		return
+	}
+
+	if err := storage.DB.Delete(&inbound).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete inbound"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "inbound_delete",
+		Detail:     "Inbound deleted: " + inbound.Name,
+		TargetType: "inbound",
+		TargetID:   inbound.ID,
+		TargetName: inbound.Name,
+		Diff:       auditDiff(inbound, nil),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "inbound deleted"})
+}
+
+func ToggleInbound(c *gin.Context) {
+	inbound, ok := findInbound(c)
+	if !ok {
+		return
+	}
+
+	inbound.Enabled = !inbound.Enabled
+	if inbound.Enabled {
+		// Re-enabling must not bring back a port conflict or an invalid config
+		req := InboundRequest{Name: inbound.Name, Type: inbound.Type, Config: json.RawMessage(inbound.Config)}
+		if _, ok := validateInboundRequest(c, &req, inbound.ID); !ok {
+			return
+		}
+	}
+
+	if err := storage.DB.Model(&inbound).Update("enabled", inbound.Enabled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to toggle inbound"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "inbound_toggle",
+		Detail:     fmt.Sprintf("Inbound %s enabled=%t", inbound.Name, inbound.Enabled),
+		TargetType: "inbound",
+		TargetID:   inbound.ID,
+		TargetName: inbound.Name,
+		Diff:       auditDiff(gin.H{"enabled": !inbound.Enabled}, gin.H{"enabled": inbound.Enabled}),
+	})
+
+	c.JSON(http.StatusOK, newInboundResponse(inbound))
+}
+
+func findInbound(c *gin.Context) (storage.Inbound, bool) {
+	var inbound storage.Inbound
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return inbound, false
+	}
+	if err := storage.DB.First(&inbound, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "inbound not found"})
+		return inbound, false
+	}
+	return inbound, true
+}
+
+// validateInboundRequest checks the protocol config, tag and port uniqueness and
+// writes a field-level error response on failure. It returns the normalized config JSON.
+func validateInboundRequest(c *gin.Context, req *InboundRequest, selfID uint) (string, bool) {
+	var config map[string]interface{}
+	if err := json.Unmarshal(req.Config, &config); err != nil || config == nil {
+		respondFieldErrors(c, []generator.FieldError{{Field: "config", Message: "must be a JSON object"}})
+		return "", false
+	}
+
+	// type and tag are derived from the row itself
+	delete(config, "type")
+	delete(config, "tag")
+
+	var fields []generator.FieldError
+	if err := generator.ValidateInbound(req.Type, config); err != nil {
+		var verr *generator.ValidationError
+		if errors.As(err, &verr) {
+			fields = append(fields, verr.Fields...)
+		} else {
+			fields = append(fields, generator.FieldError{Field: "config", Message: err.Error()})
+		}
+	}
+
+	var others []storage.Inbound
+	storage.DB.Where("id <> ?", selfID).Find(&others)
+	port, _ := config["listen_port"].(float64)
+	for _, other := range others {
+		if other.Name == req.Name {
+			fields = append(fields, generator.FieldError{Field: "name", Message: "name is already used by another inbound"})
+		}
+		if !other.Enabled || port == 0 {
+			continue
+		}
+		var otherConfig map[string]interface{}
+		if json.Unmarshal([]byte(other.Config), &otherConfig) == nil && otherConfig["listen_port"] == port {
+			fields = append(fields, generator.FieldError{
+				Field:   "listen_port",
+				Message: fmt.Sprintf("port %d is already used by inbound %q", int(port), other.Name),
+			})
+		}
+	}
+
+	if len(fields) > 0 {
+		respondFieldErrors(c, fields)
+		return "", false
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode config"})
+		return "", false
+	}
+	return string(data), true
+}
+
+func respondFieldErrors(c *gin.Context, fields []generator.FieldError) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":  "validation failed",
+		"fields": fields,
+	})
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"singbox.arrow.web2/internal/core/corelog"
+	"singbox.arrow.web2/internal/storage"
+	"singbox.arrow.web2/internal/websocket"
+)
+
+// defaultReplay is how many recent events a new log stream starts with
+const defaultReplay = 100
+
+// StreamLogs streams parsed sing-box output over WebSocket. level (minimum level),
+// keyword, component, inbound and outbound filter the stream on the server, replay
+// sets how many recent events are sent first. The client may send the same fields
+// as JSON to change the filter.
+func StreamLogs(c *gin.Context) {
+	filter := websocket.Filter{
+		Level:     c.Query("level"),
+		Keyword:   c.Query("keyword"),
+		Component: c.Query("component"),
+		Inbound:   c.Query("inbound"),
+		Outbound:  c.Query("outbound"),
+	}
+	if filter.Level != "" && !corelog.ValidLevel(filter.Level) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid level"})
+		return
+	}
+	replay := defaultReplay
+	if v := c.Query("replay"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid replay"})
+			return
+		}
+		replay = n
+	}
+
+	conn, err := websocket.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written the error response
+		return
+	}
+	websocket.Serve(conn, singboxManager.LogHub(), filter, replay)
+}
+
+// maxCoreLogs limits the events returned by one request
+const maxCoreLogs = 1000
+
+// SearchCoreLogs pages through the sing-box log files, newest first. from and to
+// (RFC 3339) bound the time range, level is the minimum level and q a text to
+// look for. The next field of the response is passed as cursor for the next page.
+func SearchCoreLogs(c *gin.Context) {
+	q := corelog.Query{
+		Level:  c.Query("level"),
+		Text:   c.Query("q"),
+		Cursor: c.Query("cursor"),
+	}
+	if q.Level != "" && !corelog.ValidLevel(q.Level) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid level"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	if limit > maxCoreLogs {
+		limit = maxCoreLogs
+	}
+	q.Limit = limit
+	for name, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := c.Query(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ", expected RFC 3339"})
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	page, err := corelog.Search(singboxManager.LogFiles().Dir(), q)
+	if err != nil {
+		if errors.Is(err, corelog.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+const (
+	defaultOperationPageSize = 50
+	maxOperationPageSize     = 500
+	// maxOperationExport limits the rows of one CSV export
+	maxOperationExport = 100000
+)
+
+type OperationLogResponse struct {
+	ID         uint            `json:"id"`
+	Action     string          `json:"action"`
+	Detail     string          `json:"detail"`
+	Actor      string          `json:"actor"`
+	ClientIP   string          `json:"client_ip"`
+	UserAgent  string          `json:"user_agent"`
+	TargetType string          `json:"target_type"`
+	TargetID   uint            `json:"target_id,omitempty"`
+	TargetName string          `json:"target_name"`
+	Diff       json.RawMessage `json:"diff,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+func newOperationLogResponse(op storage.OperationLog) OperationLogResponse {
+	resp := OperationLogResponse{
+		ID:         op.ID,
+		Action:     op.Action,
+		Detail:     op.Detail,
+		Actor:      op.Actor,
+		ClientIP:   op.ClientIP,
+		UserAgent:  op.UserAgent,
+		TargetType: op.TargetType,
+		TargetID:   op.TargetID,
+		TargetName: op.TargetName,
+		CreatedAt:  op.CreatedAt,
+	}
+	if op.Diff != "" && json.Valid([]byte(op.Diff)) {
+		resp.Diff = json.RawMessage(op.Diff)
+	}
+	return resp
+}
+
+// ListOperationLogs returns the audit trail, newest first. action, actor and
+// target_type match exactly, target_id selects one entity, q searches the detail
+// and target name, from and to (RFC 3339) bound the time. page and page_size
+// paginate; format=csv exports every matching row instead.
+func ListOperationLogs(c *gin.Context) {
+	query := storage.DB.Model(&storage.OperationLog{})
+	for param, column := range map[string]string{"action": "action", "actor": "actor", "target_type": "target_type"} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(column+" = ?", v)
+		}
+	}
+	if v := c.Query("target_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid target_id"})
+			return
+		}
+		query = query.Where("target_id = ?", id)
+	}
+	if q := c.Query("q"); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("detail LIKE ? OR target_name LIKE ?", like, like)
+	}
+	for name, op := range map[string]string{"from": ">=", "to": "<="} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ", expected RFC 3339"})
+				return
+			}
+			query = query.Where("created_at "+op+" ?", t)
+		}
+	}
+
+	if c.Query("format") == "csv" {
+		var ops []storage.OperationLog
+		if err := query.Order("created_at DESC, id DESC").Limit(maxOperationExport).Find(&ops).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list operation logs"})
+			return
+		}
+		writeOperationsCSV(c, ops)
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultOperationPageSize)))
+	if err != nil || pageSize < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page_size"})
+		return
+	}
+	if pageSize > maxOperationPageSize {
+		pageSize = maxOperationPageSize
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count operation logs"})
+		return
+	}
+	var ops []storage.OperationLog
+	if err := query.Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&ops).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list operation logs"})
+		return
+	}
+
+	items := make([]OperationLogResponse, 0, len(ops))
+	for _, op := range ops {
+		items = append(items, newOperationLogResponse(op))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":     items,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func writeOperationsCSV(c *gin.Context, ops []storage.OperationLog) {
+	filename := "operations_" + time.Now().Format("20060102_150405") + ".csv"
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "time", "actor", "client_ip", "user_agent", "action", "target_type", "target_id", "target_name", "detail", "diff"})
+	for _, op := range ops {
+		targetID := ""
+		if op.TargetID != 0 {
+			targetID = strconv.FormatUint(uint64(op.TargetID), 10)
+		}
+		w.Write([]string{
+			strconv.FormatUint(uint64(op.ID), 10),
+			op.CreatedAt.Format(time.RFC3339),
+			csvSafe(op.Actor),
+			csvSafe(op.ClientIP),
+			csvSafe(op.UserAgent),
+			csvSafe(op.Action),
+			csvSafe(op.TargetType),
+			targetID,
+			csvSafe(op.TargetName),
+			csvSafe(op.Detail),
+			csvSafe(op.Diff),
+		})
+	}
+	w.Flush()
+}
+
+// csvSafe keeps spreadsheets from evaluating user-controlled cells as formulas
+func csvSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
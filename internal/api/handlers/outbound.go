@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"singbox.arrow.web2/internal/core/latency"
+	"singbox.arrow.web2/internal/core/parser"
+	"singbox.arrow.web2/internal/storage"
+)
+
+type OutboundRequest struct {
+	Name    string          `json:"name" binding:"required"`
+	Tag     string          `json:"tag"` // optional, derived from the name on create
+	Type    string          `json:"type" binding:"required"`
+	Server  string          `json:"server"`
+	Port    int             `json:"port"`
+	Config  json.RawMessage `json:"config" binding:"required"`
+	Enabled *bool           `json:"enabled"`
+}
+
+type ImportLinksRequest struct {
+	Links string `json:"links" binding:"required"` // share links or subscription content
+}
+
+type LatencyTestRequest struct {
+	IDs         []uint `json:"ids"`  // batch only, empty tests all enabled outbounds
+	Mode        string `json:"mode"` // tcp/url
+	URL         string `json:"url"`
+	TimeoutMs   int    `json:"timeout_ms" binding:"min=0"`
+	Concurrency int    `json:"concurrency" binding:"min=0"`
+}
+
+func (r *LatencyTestRequest) options() latency.Options {
+	return latency.Options{
+		Mode:    r.Mode,
+		URL:     r.URL,
+		Timeout: time.Duration(r.TimeoutMs) * time.Millisecond,
+		Workers: r.Concurrency,
+	}
+}
+
+type OutboundResponse struct {
+	ID             uint            `json:"id"`
+	SubscriptionID *uint           `json:"subscription_id"`
+	Name           string          `json:"name"`
+	Tag            string          `json:"tag"`
+	Type           string          `json:"type"`
+	Server         string          `json:"server"`
+	Port           int             `json:"port"`
+	Config         json.RawMessage `json:"config"`
+	Latency        *int            `json:"latency"`
+	Enabled        bool            `json:"enabled"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+func newOutboundResponse(out storage.Outbound) OutboundResponse {
+	config := json.RawMessage(out.Config)
+	if !json.Valid(config) {
+		config = json.RawMessage("{}")
+	}
+	return OutboundResponse{
+		ID:             out.ID,
+		SubscriptionID: out.SubscriptionID,
+		Name:           out.Name,
+		Tag:            out.Tag,
+		Type:           out.Type,
+		Server:         out.Server,
+		Port:           out.Port,
+		Config:         config,
+		Latency:        out.Latency,
+		Enabled:        out.Enabled,
+		CreatedAt:      out.CreatedAt,
+		UpdatedAt:      out.UpdatedAt,
+	}
+}
+
+func ListOutbounds(c *gin.Context) {
+	query := storage.DB.Order("id")
+	if subID := c.Query("subscription_id"); subID != "" {
+		if subID == "manual" {
+			query = query.Where("subscription_id IS NULL")
+		} else {
+			query = query.Where("subscription_id = ?", subID)
+		}
+	}
+
+	var outbounds []storage.Outbound
+	if err := query.Find(&outbounds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list outbounds"})
+		return
+	}
+
+	result := make([]OutboundResponse, 0, len(outbounds))
+	for _, out := range outbounds {
+		result = append(result, newOutboundResponse(out))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func CreateOutbound(c *gin.Context) {
+	var req OutboundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var outbound storage.Outbound
+	if !applyOutboundRequest(c, &req, &outbound) {
+		return
+	}
+	outbound.Enabled = req.Enabled == nil || *req.Enabled
+
+	if err := storage.DB.Create(&outbound).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create outbound"})
+		return
+	}
+	if req.Enabled != nil && !*req.Enabled {
+		storage.DB.Model(&outbound).Update("enabled", false)
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "outbound_create",
+		Detail:     fmt.Sprintf("Outbound created: %s (%s)", outbound.Name, outbound.Type),
+		TargetType: "outbound",
+		TargetID:   outbound.ID,
+		TargetName: outbound.Name,
+		Diff:       auditDiff(nil, outbound),
+	})
+
+	c.JSON(http.StatusOK, newOutboundResponse(outbound))
+}
+
+func UpdateOutbound(c *gin.Context) {
+	outbound, ok := findOutbound(c)
+	if !ok {
+		return
+	}
+	before := outbound
+
+	var req OutboundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	oldTag := outbound.Tag
+	if !applyOutboundRequest(c, &req, &outbound) {
+		return
+	}
+	if req.Enabled != nil {
+		outbound.Enabled = *req.Enabled
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&outbound).Error; err != nil {
+			return err
+		}
+		if outbound.Tag != oldTag {
+			return renameOutboundTag(tx, oldTag, outbound.Tag)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update outbound"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "outbound_update",
+		Detail:     fmt.Sprintf("Outbound updated: %s (%s)", outbound.Name, outbound.Type),
+		TargetType: "outbound",
+		TargetID:   outbound.ID,
+		TargetName: outbound.Name,
+		Diff:       auditDiff(before, outbound),
+	})
+
+	c.JSON(http.StatusOK, newOutboundResponse(outbound))
+}
+
+// renameOutboundTag keeps rules and group defaults pointing at an outbound or
+// group when its tag is renamed
+func renameOutboundTag(tx *gorm.DB, oldTag, newTag string) error {
+	if err := tx.Model(&storage.Rule{}).Where("outbound_tag = ?", oldTag).Update("outbound_tag", newTag).Error; err != nil {
+		return err
+	}
+	return tx.Model(&storage.OutboundGroup{}).Where(map[string]interface{}{"default": oldTag}).Update("default", newTag).Error
+}
+
+func DeleteOutbound(c *gin.Context) {
+	outbound, ok := findOutbound(c)
+	if !ok {
+		return
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("outbound_id = ?", outbound.ID).Delete(&storage.LatencyHistory{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&outbound).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete outbound"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "outbound_delete",
+		Detail:     "Outbound deleted: " + outbound.Name,
+		TargetType: "outbound",
+		TargetID:   outbound.ID,
+		TargetName: outbound.Name,
+		Diff:       auditDiff(outbound, nil),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "outbound deleted"})
+}
+
+func ToggleOutbound(c *gin.Context) {
+	outbound, ok := findOutbound(c)
+	if !ok {
+		return
+	}
+
+	outbound.Enabled = !outbound.Enabled
+	if err := storage.DB.Model(&outbound).Update("enabled", outbound.Enabled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to toggle outbound"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "outbound_toggle",
+		Detail:     fmt.Sprintf("Outbound %s enabled=%t", outbound.Name, outbound.Enabled),
+		TargetType: "outbound",
+		TargetID:   outbound.ID,
+		TargetName: outbound.Name,
+		Diff:       auditDiff(gin.H{"enabled": !outbound.Enabled}, gin.H{"enabled": outbound.Enabled}),
+	})
+
+	c.JSON(http.StatusOK, newOutboundResponse(outbound))
+}
+
+// ImportOutbounds parses share links or any supported subscription content
+// and saves the nodes as manual outbounds
+func ImportOutbounds(c *gin.Context) {
+	var req ImportLinksRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := parser.Parse([]byte(req.Links), parser.FormatAuto)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	skipped := result.Skipped
+	created := make([]OutboundResponse, 0, len(result.Nodes))
+	for _, node := range result.Nodes {
+		outbound, err := node.ToOutbound(nil)
+		if err != nil {
+			skipped = append(skipped, parser.SkippedProxy{Name: node.Name, Type: node.Type, Reason: err.Error()})
+			continue
+		}
+		if err := storage.DB.Create(outbound).Error; err != nil {
+			skipped = append(skipped, parser.SkippedProxy{Name: node.Name, Type: node.Type, Reason: "failed to save outbound"})
+			continue
+		}
+		created = append(created, newOutboundResponse(*outbound))
+	}
+
+	if len(created) > 0 {
+		// Log operation
+		logOperation(c, storage.OperationLog{
+			Action:     "outbound_import",
+			Detail:     fmt.Sprintf("Imported %d outbounds (%s)", len(created), result.Format),
+			TargetType: "outbound",
+		})
+	}
+
+	if skipped == nil {
+		skipped = []parser.SkippedProxy{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"format":  result.Format,
+		"created": created,
+		"skipped": skipped,
+	})
+}
+
+// TestOutbound measures the latency of a single outbound
+func TestOutbound(c *gin.Context) {
+	outbound, ok := findOutbound(c)
+	if !ok {
+		return
+	}
+
+	var req LatencyTestRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	results, err := latency.Test(singboxManager, []storage.Outbound{outbound}, req.options())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	latency.Record(results)
+
+	c.JSON(http.StatusOK, results[0])
+}
+
+// TestOutbounds measures the latency of the given outbounds, or all enabled ones, concurrently
+func TestOutbounds(c *gin.Context) {
+	var req LatencyTestRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	query := storage.DB.Order("id")
+	if len(req.IDs) > 0 {
+		query = query.Where("id IN ?", req.IDs)
+	} else {
+		query = query.Where("enabled = ?", true)
+	}
+	var outbounds []storage.Outbound
+	if err := query.Find(&outbounds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load outbounds"})
+		return
+	}
+
+	results, err := latency.Test(singboxManager, outbounds, req.options())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	latency.Record(results)
+
+	c.JSON(http.StatusOK, results)
+}
+
+// GetOutboundLatency returns the latency history of an outbound, newest first
+func GetOutboundLatency(c *gin.Context) {
+	outbound, ok := findOutbound(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	var history []storage.LatencyHistory
+	if err := storage.DB.Where("outbound_id = ?", outbound.ID).Order("created_at desc").Limit(limit).Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load latency history"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(history))
+	for _, h := range history {
+		result = append(result, gin.H{
+			"mode":       h.Mode,
+			"latency":    h.Latency,
+			"error":      h.Error,
+			"created_at": h.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"outbound": newOutboundResponse(outbound),
+		"history":  result,
+	})
+}
+
+func findOutbound(c *gin.Context) (storage.Outbound, bool) {
+	var outbound storage.Outbound
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return outbound, false
+	}
+	if err := storage.DB.First(&outbound, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "outbound not found"})
+		return outbound, false
+	}
+	return outbound, true
+}
+
+// applyOutboundRequest copies the request onto the row, taking server and port
+// from the config when they are not given explicitly
+func applyOutboundRequest(c *gin.Context, req *OutboundRequest, outbound *storage.Outbound) bool {
+	var config map[string]interface{}
+	if err := json.Unmarshal(req.Config, &config); err != nil || config == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "config must be a JSON object"})
+		return false
+	}
+	delete(config, "type")
+	delete(config, "tag")
+
+	if req.Server == "" {
+		req.Server, _ = config["server"].(string)
+	}
+	if req.Port == 0 {
+		if port, ok := config["server_port"].(float64); ok {
+			req.Port = int(port)
+		}
+	}
+	if req.Port < 0 || req.Port > 65535 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid port"})
+		return false
+	}
+	// Keep the config in sync with the columns so the generator sees the same endpoint
+	if req.Server != "" {
+		config["server"] = req.Server
+	}
+	if req.Port > 0 {
+		config["server_port"] = req.Port
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode config"})
+		return false
+	}
+
+	if req.Tag != "" && req.Tag != outbound.Tag {
+		taken, err := storage.OutboundTagTaken(storage.DB, req.Tag, outbound.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check tag"})
+			return false
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("tag %q is already in use", req.Tag)})
+			return false
+		}
+		outbound.Tag = req.Tag
+	}
+
+	outbound.Name = req.Name
+	outbound.Type = req.Type
+	outbound.Server = req.Server
+	outbound.Port = req.Port
+	outbound.Config = string(data)
+	return true
+}
@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"singbox.arrow.web2/internal/core/generator"
+	"singbox.arrow.web2/internal/core/router"
+	"singbox.arrow.web2/internal/storage"
+)
+
+type RuleRequest struct {
+	Priority    *int                  `json:"priority"` // appended after the last rule when omitted
+	Type        string                `json:"type" binding:"required"`
+	Value       string                `json:"value"`
+	Conditions  []generator.Condition `json:"conditions"`
+	Mode        string                `json:"mode"`
+	Rules       []generator.SubRule   `json:"rules"`
+	Invert      bool                  `json:"invert"`
+	Action      string                `json:"action"`
+	OutboundTag string                `json:"outbound_tag"`
+	Enabled     *bool                 `json:"enabled"`
+}
+
+type ReorderRulesRequest struct {
+	IDs []uint `json:"ids" binding:"required"` // every rule id, in the new order
+}
+
+type RuleResponse struct {
+	ID          uint                  `json:"id"`
+	Priority    int                   `json:"priority"`
+	Type        string                `json:"type"`
+	Value       string                `json:"value"`
+	Conditions  []generator.Condition `json:"conditions"`
+	Mode        string                `json:"mode"`
+	Rules       []generator.SubRule   `json:"rules"`
+	Invert      bool                  `json:"invert"`
+	Action      string                `json:"action"`
+	OutboundTag string                `json:"outbound_tag"`
+	Enabled     bool                  `json:"enabled"`
+	CreatedAt   time.Time             `json:"created_at"`
+	UpdatedAt   time.Time             `json:"updated_at"`
+
+	// OutboundMissing is set when outbound_tag does not exist in the generated config,
+	// EffectiveOutbound is where the rule routes to instead
+	OutboundMissing   bool   `json:"outbound_missing"`
+	EffectiveOutbound string `json:"effective_outbound"`
+}
+
+func newRuleResponse(rule storage.Rule, known map[string]bool) RuleResponse {
+	conditions, _ := generator.DecodeConditions(rule.Conditions)
+	if conditions == nil {
+		conditions = []generator.Condition{}
+	}
+	subRules, _ := generator.DecodeSubRules(rule.SubRules)
+	if subRules == nil {
+		subRules = []generator.SubRule{}
+	}
+
+	resp := RuleResponse{
+		ID:          rule.ID,
+		Priority:    rule.Priority,
+		Type:        rule.Type,
+		Value:       rule.Value,
+		Conditions:  conditions,
+		Mode:        rule.Mode,
+		Rules:       subRules,
+		Invert:      rule.Invert,
+		Action:      generator.RuleAction(&rule),
+		OutboundTag: rule.OutboundTag,
+		Enabled:     rule.Enabled,
+		CreatedAt:   rule.CreatedAt,
+		UpdatedAt:   rule.UpdatedAt,
+	}
+	if resp.Action == generator.ActionRoute {
+		resp.EffectiveOutbound, resp.OutboundMissing = generator.ResolveOutbound(rule.OutboundTag, known)
+	}
+	return resp
+}
+
+func ListRules(c *gin.Context) {
+	var rules []storage.Rule
+	if err := storage.DB.Order("priority, id").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list rules"})
+		return
+	}
+
+	known, err := generator.OutboundTags()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]RuleResponse, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, newRuleResponse(rule, known))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func CreateRule(c *gin.Context) {
+	var req RuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rule storage.Rule
+	if !applyRuleRequest(c, &req, &rule) {
+		return
+	}
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	if req.Priority == nil {
+		var last struct{ Max *int }
+		storage.DB.Model(&storage.Rule{}).Select("MAX(priority) AS max").Scan(&last)
+		rule.Priority = 1
+		if last.Max != nil {
+			rule.Priority = *last.Max + 1
+		}
+	}
+
+	if err := storage.DB.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create rule"})
+		return
+	}
+	if req.Enabled != nil && !*req.Enabled {
+		storage.DB.Model(&rule).Update("enabled", false)
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "rule_create",
+		Detail:     fmt.Sprintf("Rule created: %s -> %s", rule.Type, ruleTarget(&rule)),
+		TargetType: "rule",
+		TargetID:   rule.ID,
+		Diff:       auditDiff(nil, rule),
+	})
+
+	respondRule(c, rule)
+}
+
+func UpdateRule(c *gin.Context) {
+	rule, ok := findRule(c)
+	if !ok {
+		return
+	}
+	before := rule
+
+	var req RuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !applyRuleRequest(c, &req, &rule) {
+		return
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+
+	if err := storage.DB.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update rule"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "rule_update",
+		Detail:     fmt.Sprintf("Rule %d updated: %s -> %s", rule.ID, rule.Type, ruleTarget(&rule)),
+		TargetType: "rule",
+		TargetID:   rule.ID,
+		Diff:       auditDiff(before, rule),
+	})
+
+	respondRule(c, rule)
+}
+
+func DeleteRule(c *gin.Context) {
+	rule, ok := findRule(c)
+	if !ok {
+		return
+	}
+
+	if err := storage.DB.Delete(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete rule"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "rule_delete",
+		Detail:     fmt.Sprintf("Rule %d deleted: %s -> %s", rule.ID, rule.Type, ruleTarget(&rule)),
+		TargetType: "rule",
+		TargetID:   rule.ID,
+		Diff:       auditDiff(rule, nil),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "rule deleted"})
+}
+
+func ToggleRule(c *gin.Context) {
+	rule, ok := findRule(c)
+	if !ok {
+		return
+	}
+
+	rule.Enabled = !rule.Enabled
+	if err := storage.DB.Model(&rule).Update("enabled", rule.Enabled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to toggle rule"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "rule_toggle",
+		Detail:     fmt.Sprintf("Rule %d enabled=%t", rule.ID, rule.Enabled),
+		TargetType: "rule",
+		TargetID:   rule.ID,
+		Diff:       auditDiff(gin.H{"enabled": !rule.Enabled}, gin.H{"enabled": rule.Enabled}),
+	})
+
+	respondRule(c, rule)
+}
+
+// ReorderRules rewrites all priorities from the given order in a single transaction
+func ReorderRules(c *gin.Context) {
+	var req ReorderRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order []uint
+	storage.DB.Model(&storage.Rule{}).Order("priority, id").Pluck("id", &order)
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		if err := tx.Model(&storage.Rule{}).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		existing := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			existing[id] = true
+		}
+		if len(req.IDs) != len(ids) {
+			return reorderError("ids must list every rule exactly once")
+		}
+		seen := make(map[uint]bool, len(req.IDs))
+		for _, id := range req.IDs {
+			if !existing[id] || seen[id] {
+				return reorderError(fmt.Sprintf("unknown or duplicate rule id %d", id))
+			}
+			seen[id] = true
+		}
+
+		for i, id := range req.IDs {
+			if err := tx.Model(&storage.Rule{}).Where("id = ?", id).Update("priority", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var rerr reorderError
+		if errors.As(err, &rerr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reorder rules"})
+		}
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "rule_reorder",
+		Detail:     fmt.Sprintf("Reordered %d rules", len(req.IDs)),
+		TargetType: "rule",
+		Diff:       auditDiff(gin.H{"order": order}, gin.H{"order": req.IDs}),
+	})
+
+	ListRules(c)
+}
+
+// SimulateRoute evaluates the enabled rules against a hypothetical connection
+// and explains which rule and outbound it would end up at
+func SimulateRoute(c *gin.Context) {
+	var conn router.Connection
+	if err := c.ShouldBindJSON(&conn); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := router.Simulate(conn)
+	if err != nil {
+		var ierr *router.InputError
+		if errors.As(err, &ierr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+type reorderError string
+
+func (e reorderError) Error() string { return string(e) }
+
+func respondRule(c *gin.Context, rule storage.Rule) {
+	known, _ := generator.OutboundTags()
+	c.JSON(http.StatusOK, newRuleResponse(rule, known))
+}
+
+func findRule(c *gin.Context) (storage.Rule, bool) {
+	var rule storage.Rule
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return rule, false
+	}
+	if err := storage.DB.First(&rule, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return rule, false
+	}
+	return rule, true
+}
+
+func ruleTarget(rule *storage.Rule) string {
+	if action := generator.RuleAction(rule); action != generator.ActionRoute {
+		return action
+	}
+	return rule.OutboundTag
+}
+
+// applyRuleRequest copies the request onto the row and validates the result
+func applyRuleRequest(c *gin.Context, req *RuleRequest, rule *storage.Rule) bool {
+	if req.Action == "" {
+		req.Action = generator.ActionRoute
+	}
+	// "block" and "reject" targets predate rule actions
+	if req.Action == generator.ActionRoute && (req.OutboundTag == "block" || req.OutboundTag == "reject") {
+		req.Action = generator.ActionReject
+	}
+	if req.Action != generator.ActionRoute {
+		req.OutboundTag = ""
+	}
+
+	rule.Type = req.Type
+	rule.Value = req.Value
+	rule.Conditions = ""
+	rule.Mode = ""
+	rule.SubRules = ""
+	if req.Type == generator.RuleTypeLogical {
+		rule.Value = ""
+		rule.Mode = req.Mode
+		if len(req.Rules) > 0 {
+			data, _ := json.Marshal(req.Rules)
+			rule.SubRules = string(data)
+		}
+	} else if len(req.Conditions) > 0 {
+		data, _ := json.Marshal(req.Conditions)
+		rule.Conditions = string(data)
+	}
+	rule.Invert = req.Invert
+	rule.Action = req.Action
+	rule.OutboundTag = req.OutboundTag
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+
+	var fields []generator.FieldError
+	if err := generator.ValidateRule(rule); err != nil {
+		var verr *generator.ValidationError
+		if errors.As(err, &verr) {
+			fields = append(fields, verr.Fields...)
+		} else {
+			fields = append(fields, generator.FieldError{Field: "rule", Message: err.Error()})
+		}
+	}
+	if rule.Priority < 0 {
+		fields = append(fields, generator.FieldError{Field: "priority", Message: "must not be negative"})
+	}
+	if rule.OutboundTag != "" {
+		exists, err := storage.TagExists(storage.DB, rule.OutboundTag)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check outbound"})
+			return false
+		}
+		if !exists {
+			fields = append(fields, generator.FieldError{Field: "outbound_tag", Message: "no outbound or group with this tag"})
+		}
+	}
+
+	if len(fields) > 0 {
+		respondFieldErrors(c, fields)
+		return false
+	}
+	return true
+}
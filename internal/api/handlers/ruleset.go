@@ -0,0 +1,1010 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"singbox.arrow.web2/internal/core/generator"
+	"singbox.arrow.web2/internal/core/ruleset"
+	"singbox.arrow.web2/internal/core/ruleset/convert"
+	"singbox.arrow.web2/internal/core/ruleset/source"
+	"singbox.arrow.web2/internal/storage"
+)
+
+type RulesetRequest struct {
+	Name           string `json:"name" binding:"required"`
+	Type           string `json:"type" binding:"required"`
+	Format         string `json:"format" binding:"required"`
+	URL            string `json:"url"`
+	Path           string `json:"path"`
+	UpdateInterval int    `json:"update_interval" binding:"min=0"`
+	Exceptions     string `json:"exceptions"` // rule set exempt from this one
+	Enabled        *bool  `json:"enabled"`
+}
+
+type RulesetResponse struct {
+	ID             uint       `json:"id"`
+	Name           string     `json:"name"`
+	Type           string     `json:"type"`
+	Format         string     `json:"format"`
+	URL            string     `json:"url"`
+	Path           string     `json:"path"`
+	UpdateInterval int        `json:"update_interval"`
+	LastUpdate     *time.Time `json:"last_update"`
+	LocalPath      string     `json:"local_path"`
+	ETag           string     `json:"etag"`
+	LastModified   string     `json:"last_modified"`
+	LastError      string     `json:"last_error"`
+	Exceptions     string     `json:"exceptions"`
+	Enabled        bool       `json:"enabled"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// Downloaded is true when a remote rule set has a local copy the config can use
+	Downloaded bool `json:"downloaded"`
+}
+
+func newRulesetResponse(rs storage.Ruleset) RulesetResponse {
+	return RulesetResponse{
+		ID:             rs.ID,
+		Name:           rs.Name,
+		Type:           rs.Type,
+		Format:         rs.Format,
+		URL:            rs.URL,
+		Path:           rs.Path,
+		UpdateInterval: rs.UpdateInterval,
+		LastUpdate:     rs.LastUpdate,
+		LocalPath:      rs.LocalPath,
+		ETag:           rs.ETag,
+		LastModified:   rs.LastModified,
+		LastError:      rs.LastError,
+		Exceptions:     rs.Exceptions,
+		Enabled:        rs.Enabled,
+		CreatedAt:      rs.CreatedAt,
+		UpdatedAt:      rs.UpdatedAt,
+		Downloaded:     rs.Type == "remote" && ruleset.LocalFile(&rs) != "",
+	}
+}
+
+func ListRulesets(c *gin.Context) {
+	var rulesets []storage.Ruleset
+	if err := storage.DB.Order("id").Find(&rulesets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list rulesets"})
+		return
+	}
+
+	result := make([]RulesetResponse, 0, len(rulesets))
+	for _, rs := range rulesets {
+		result = append(result, newRulesetResponse(rs))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func CreateRuleset(c *gin.Context) {
+	var req RulesetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validateRulesetRequest(c, &req, 0) {
+		return
+	}
+
+	rs := storage.Ruleset{
+		Name:           req.Name,
+		Type:           req.Type,
+		Format:         req.Format,
+		URL:            req.URL,
+		Path:           req.Path,
+		UpdateInterval: req.UpdateInterval,
+		Exceptions:     req.Exceptions,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}
+	if err := storage.DB.Create(&rs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create ruleset"})
+		return
+	}
+	if req.Enabled != nil && !*req.Enabled {
+		storage.DB.Model(&rs).Update("enabled", false)
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_create",
+		Detail:     fmt.Sprintf("Ruleset created: %s (%s, %s)", rs.Name, rs.Type, rs.Format),
+		TargetType: "ruleset",
+		TargetID:   rs.ID,
+		TargetName: rs.Name,
+		Diff:       auditDiff(nil, rs),
+	})
+
+	c.JSON(http.StatusOK, newRulesetResponse(rs))
+}
+
+func UpdateRuleset(c *gin.Context) {
+	rs, ok := findRuleset(c)
+	if !ok {
+		return
+	}
+	before := rs
+
+	var req RulesetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validateRulesetRequest(c, &req, rs.ID) {
+		return
+	}
+
+	// A different source invalidates the downloaded copy and its validators
+	if req.Type != rs.Type || req.Format != rs.Format || req.URL != rs.URL {
+		ruleset.RemoveDownload(&rs)
+		rs.LocalPath = ""
+		rs.ETag = ""
+		rs.LastModified = ""
+		rs.LastError = ""
+	}
+
+	rs.Name = req.Name
+	rs.Type = req.Type
+	rs.Format = req.Format
+	rs.URL = req.URL
+	rs.Path = req.Path
+	rs.UpdateInterval = req.UpdateInterval
+	rs.Exceptions = req.Exceptions
+	if req.Enabled != nil {
+		rs.Enabled = *req.Enabled
+	}
+
+	// Rules and allow lists refer to a rule set by name, a rename carries over to them
+	var renamed []storage.Rule
+	if rs.Name != before.Name {
+		var rules []storage.Rule
+		if err := storage.DB.Order("id").Find(&rules).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load rules"})
+			return
+		}
+		for _, rule := range rules {
+			changed, err := generator.RenameRuleSet(&rule, before.Name, rs.Name)
+			if err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if changed {
+				renamed = append(renamed, rule)
+			}
+		}
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&rs).Error; err != nil {
+			return err
+		}
+		for i := range renamed {
+			if err := tx.Save(&renamed[i]).Error; err != nil {
+				return err
+			}
+		}
+		if rs.Name != before.Name {
+			return tx.Model(&storage.Ruleset{}).Where("exceptions = ?", before.Name).Update("exceptions", rs.Name).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update ruleset"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_update",
+		Detail:     fmt.Sprintf("Ruleset updated: %s (%s, %s)", rs.Name, rs.Type, rs.Format),
+		TargetType: "ruleset",
+		TargetID:   rs.ID,
+		TargetName: rs.Name,
+		Diff:       auditDiff(before, rs),
+	})
+
+	c.JSON(http.StatusOK, newRulesetResponse(rs))
+}
+
+func DeleteRuleset(c *gin.Context) {
+	rs, ok := findRuleset(c)
+	if !ok {
+		return
+	}
+
+	if err := storage.DB.Delete(&rs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete ruleset"})
+		return
+	}
+	ruleset.RemoveFiles(&rs)
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_delete",
+		Detail:     "Ruleset deleted: " + rs.Name,
+		TargetType: "ruleset",
+		TargetID:   rs.ID,
+		TargetName: rs.Name,
+		Diff:       auditDiff(rs, nil),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "ruleset deleted"})
+}
+
+func ToggleRuleset(c *gin.Context) {
+	rs, ok := findRuleset(c)
+	if !ok {
+		return
+	}
+
+	rs.Enabled = !rs.Enabled
+	if err := storage.DB.Model(&rs).Update("enabled", rs.Enabled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to toggle ruleset"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_toggle",
+		Detail:     fmt.Sprintf("Ruleset %s enabled=%t", rs.Name, rs.Enabled),
+		TargetType: "ruleset",
+		TargetID:   rs.ID,
+		TargetName: rs.Name,
+		Diff:       auditDiff(gin.H{"enabled": !rs.Enabled}, gin.H{"enabled": rs.Enabled}),
+	})
+
+	c.JSON(http.StatusOK, newRulesetResponse(rs))
+}
+
+// RefreshRuleset downloads a remote rule set now, the request is conditional so an
+// unchanged file is not transferred again
+func RefreshRuleset(c *gin.Context) {
+	rs, ok := findRuleset(c)
+	if !ok {
+		return
+	}
+	if rs.Type != "remote" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only remote rulesets can be downloaded"})
+		return
+	}
+
+	result, err := ruleset.Update(&rs)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_refresh",
+		Detail:     fmt.Sprintf("Ruleset refreshed: %s, changed=%t", rs.Name, result.Changed),
+		TargetType: "ruleset",
+		TargetID:   rs.ID,
+		TargetName: rs.Name,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"changed": result.Changed,
+		"size":    result.Size,
+		"ruleset": newRulesetResponse(rs),
+	})
+}
+
+// RefreshRulesets downloads every enabled remote rule set and reports each outcome
+func RefreshRulesets(c *gin.Context) {
+	var rulesets []storage.Ruleset
+	if err := storage.DB.Where("type = ? AND enabled = ?", "remote", true).Order("id").Find(&rulesets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list rulesets"})
+		return
+	}
+
+	results := make([]gin.H, 0, len(rulesets))
+	changed := 0
+	for i := range rulesets {
+		rs := &rulesets[i]
+		item := gin.H{"id": rs.ID, "name": rs.Name}
+		if result, err := ruleset.Update(rs); err != nil {
+			item["error"] = err.Error()
+		} else {
+			item["changed"] = result.Changed
+			if result.Changed {
+				changed++
+			}
+		}
+		results = append(results, item)
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_refresh",
+		Detail:     fmt.Sprintf("Rulesets refreshed: %d checked, %d changed", len(rulesets), changed),
+		TargetType: "ruleset",
+	})
+
+	c.JSON(http.StatusOK, gin.H{"changed": changed, "results": results})
+}
+
+type LocalRulesetRequest struct {
+	Name    string                `json:"name" binding:"required"`
+	Rules   []source.HeadlessRule `json:"rules"`
+	Enabled *bool                 `json:"enabled"`
+}
+
+type RulesetRulesRequest struct {
+	Rules []source.HeadlessRule `json:"rules"`
+}
+
+// CreateLocalRuleset creates a local source rule set from structured rules and
+// writes its file under the data directory
+func CreateLocalRuleset(c *gin.Context) {
+	var req LocalRulesetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ruleset.ValidateRules(req.Rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rs := storage.Ruleset{Name: req.Name, Type: "local", Format: "source"}
+	if !createLocalRuleset(c, &rs, req.Enabled, func() error {
+		return ruleset.SaveRules(&rs, req.Rules)
+	}) {
+		return
+	}
+
+	c.JSON(http.StatusOK, newRulesetResponse(rs))
+}
+
+// UploadRuleset creates a local rule set from an uploaded .json or .srs file
+func UploadRuleset(c *gin.Context) {
+	name := c.PostForm("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	data, format, ok := readRulesetUpload(c)
+	if !ok {
+		return
+	}
+	var enabled *bool
+	if v := c.PostForm("enabled"); v != "" {
+		b := v == "true"
+		enabled = &b
+	}
+
+	rs := storage.Ruleset{Name: name, Type: "local", Format: format}
+	if !createLocalRuleset(c, &rs, enabled, func() error {
+		return ruleset.SaveFile(&rs, data)
+	}) {
+		return
+	}
+
+	c.JSON(http.StatusOK, newRulesetResponse(rs))
+}
+
+// ReplaceRulesetFile replaces the file of a local rule set with an upload, the
+// format follows the uploaded file
+func ReplaceRulesetFile(c *gin.Context) {
+	rs, ok := findRuleset(c)
+	if !ok {
+		return
+	}
+	if rs.Type != "local" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only local rulesets accept uploads"})
+		return
+	}
+	data, format, ok := readRulesetUpload(c)
+	if !ok {
+		return
+	}
+
+	oldFormat := rs.Format
+	rs.Format = format
+	if err := ruleset.SaveFile(&rs, data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if format != oldFormat {
+		storage.DB.Model(&rs).Update("format", format)
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_upload",
+		Detail:     fmt.Sprintf("Ruleset file replaced: %s (%s, %d bytes)", rs.Name, rs.Format, len(data)),
+		TargetType: "ruleset",
+		TargetID:   rs.ID,
+		TargetName: rs.Name,
+	})
+
+	c.JSON(http.StatusOK, newRulesetResponse(rs))
+}
+
+// GetRulesetRules returns the decoded rules of a rule set in either format, for
+// remote rule sets the downloaded copy is read
+func GetRulesetRules(c *gin.Context) {
+	rs, ok := findRuleset(c)
+	if !ok {
+		return
+	}
+
+	rules, err := ruleset.LoadRuleset(&rs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ruleset": newRulesetResponse(rs),
+		"version": rules.Version,
+		"rules":   rules.Rules,
+	})
+}
+
+// UpdateRulesetRules rewrites the file of a local rule set from structured rules,
+// binary rule sets are compiled to .srs
+func UpdateRulesetRules(c *gin.Context) {
+	rs, ok := findRuleset(c)
+	if !ok {
+		return
+	}
+	if rs.Type != "local" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only local rulesets can be edited"})
+		return
+	}
+
+	var req RulesetRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ruleset.ValidateRules(req.Rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ruleset.SaveRules(&rs, req.Rules); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_edit",
+		Detail:     fmt.Sprintf("Ruleset rules edited: %s (%d rules)", rs.Name, len(req.Rules)),
+		TargetType: "ruleset",
+		TargetID:   rs.ID,
+		TargetName: rs.Name,
+	})
+
+	c.JSON(http.StatusOK, newRulesetResponse(rs))
+}
+
+// ExportRuleset returns the rules of a rule set as a file in the requested format,
+// which compiles a source rule set to .srs or decompiles a binary one
+func ExportRuleset(c *gin.Context) {
+	rs, ok := findRuleset(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", rs.Format)
+
+	rules, err := ruleset.LoadRuleset(&rs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := ruleset.Encode(format, rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := "application/json"
+	if format == "binary" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", rs.Name+ruleset.Ext(format)))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+type ConvertRequest struct {
+	Source     string   `json:"source" form:"source" binding:"required"` // geosite/geoip/clash/adguard
+	URL        string   `json:"url" form:"url"`                          // instead of an uploaded file
+	Categories []string `json:"categories" form:"categories"`            // geosite/geoip, empty lists the available ones
+	Behavior   string   `json:"behavior" form:"behavior"`                // clash: domain/ipcidr/classical
+	Name       string   `json:"name" form:"name"`                        // clash/adguard: rule set name, geosite/geoip: name prefix
+	Format     string   `json:"format" form:"format"`                    // output format, binary by default
+}
+
+type ConvertResult struct {
+	Name         string            `json:"name"`
+	RulesetID    uint              `json:"ruleset_id,omitempty"`
+	Created      bool              `json:"created"`
+	Converted    int               `json:"converted"`
+	SkippedCount int               `json:"skipped_count"`
+	Skipped      []convert.Skipped `json:"skipped"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// maxSkippedShown limits the skipped entries listed per converted rule set
+const maxSkippedShown = 100
+
+// ConvertRuleset converts a geosite.dat, geoip.dat, Clash rule provider or AdGuard /
+// hosts filter list, uploaded as "file" or fetched from url, into local rule sets
+func ConvertRuleset(c *gin.Context) {
+	var req ConvertRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Format == "" {
+		req.Format = "binary"
+	}
+	if req.Format != "source" && req.Format != "binary" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be source or binary"})
+		return
+	}
+	var categories []string
+	for _, item := range req.Categories {
+		for _, category := range strings.Split(item, ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				categories = append(categories, category)
+			}
+		}
+	}
+
+	data, ok := readConvertInput(c, req.URL)
+	if !ok {
+		return
+	}
+
+	var results []*convert.Result
+	var err error
+	switch req.Source {
+	case "geosite", "geoip":
+		if len(categories) == 0 {
+			list := convert.GeositeCategories
+			if req.Source == "geoip" {
+				list = convert.GeoIPCategories
+			}
+			codes, err := list(data)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"categories": codes})
+			return
+		}
+		prefix := req.Name
+		if prefix == "" {
+			prefix = req.Source + "-"
+		}
+		if req.Source == "geosite" {
+			results, err = convert.Geosite(data, categories, prefix)
+		} else {
+			results, err = convert.GeoIP(data, categories, prefix)
+		}
+	case "clash":
+		if req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		var result *convert.Result
+		if result, err = convert.Clash(data, req.Behavior, req.Name); err == nil {
+			results = []*convert.Result{result}
+		}
+	case "adguard", "hosts":
+		if req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		results, err = convert.AdGuard(data, req.Name)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source must be geosite, geoip, clash or adguard"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Allow sets come before the block sets that refer to them
+	saved := make(map[string]bool)
+	response := make([]ConvertResult, 0, len(results))
+	for _, result := range results {
+		item := ConvertResult{
+			Name:         result.Name,
+			Converted:    result.Converted,
+			SkippedCount: len(result.Skipped),
+			Skipped:      result.Skipped,
+		}
+		if len(item.Skipped) > maxSkippedShown {
+			item.Skipped = item.Skipped[:maxSkippedShown]
+		}
+		if item.Skipped == nil {
+			item.Skipped = []convert.Skipped{}
+		}
+
+		if len(result.RuleSet.Rules) == 0 {
+			item.Error = "nothing to convert"
+		} else if rs, created, err := ruleset.SaveLocal(result.Name, req.Format, result.RuleSet); err != nil {
+			item.Error = err.Error()
+		} else {
+			item.RulesetID = rs.ID
+			item.Created = created
+			saved[rs.Name] = true
+			if req.Source == "adguard" || req.Source == "hosts" {
+				// A list without exceptions drops the link left by an earlier import
+				exceptions := result.Exceptions
+				if !saved[exceptions] {
+					exceptions = ""
+				}
+				storage.DB.Model(rs).Update("exceptions", exceptions)
+			}
+
+			// Log operation
+			logOperation(c, storage.OperationLog{
+				Action:     "ruleset_convert",
+				Detail:     fmt.Sprintf("Ruleset %s converted from %s: %d entries, %d skipped", rs.Name, req.Source, result.Converted, len(result.Skipped)),
+				TargetType: "ruleset",
+				TargetID:   rs.ID,
+				TargetName: rs.Name,
+			})
+		}
+		response = append(response, item)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// readConvertInput returns the uploaded "file" or the content fetched from url
+func readConvertInput(c *gin.Context, url string) ([]byte, bool) {
+	if header, err := c.FormFile("file"); err == nil {
+		if header.Size > ruleset.MaxFileSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file is larger than %d MB", ruleset.MaxFileSize>>20)})
+			return nil, false
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+			return nil, false
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, ruleset.MaxFileSize))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+			return nil, false
+		}
+		return data, true
+	}
+
+	if url == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file or url is required"})
+		return nil, false
+	}
+	data, err := ruleset.Fetch(url)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return data, true
+}
+
+// LookupRulesets lists the enabled rule sets whose contents match the domain or
+// IP in q, with the items that matched
+func LookupRulesets(c *gin.Context) {
+	result, err := ruleset.Lookup(c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+type CenterCategoryResponse struct {
+	ID          string                `json:"id"`
+	Name        string                `json:"name"`
+	Description string                `json:"description,omitempty"`
+	Entries     []CenterEntryResponse `json:"entries"`
+}
+
+type CenterEntryResponse struct {
+	ruleset.Entry
+	Added     bool `json:"added"`
+	RulesetID uint `json:"ruleset_id,omitempty"`
+}
+
+type AddCenterRequest struct {
+	ID             string `json:"id" binding:"required"`
+	Name           string `json:"name"`            // defaults to the entry name
+	UpdateInterval *int   `json:"update_interval"` // hours, 24 when omitted
+	Enabled        *bool  `json:"enabled"`
+}
+
+// defaultCenterInterval is the update interval of rule sets added from the center, in hours
+const defaultCenterInterval = 24
+
+// GetRulesetCenter lists the catalog, optionally one category and entries matching q.
+// Entries whose URL or name is already a rule set are marked as added.
+func GetRulesetCenter(c *gin.Context) {
+	catalog, err := ruleset.LoadCatalog()
+	if catalog == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	filtered := catalog.Filter(c.Query("category"), c.Query("q"))
+
+	var rulesets []storage.Ruleset
+	storage.DB.Find(&rulesets)
+	byURL := make(map[string]uint, len(rulesets))
+	byName := make(map[string]uint, len(rulesets))
+	for _, rs := range rulesets {
+		if rs.URL != "" {
+			byURL[rs.URL] = rs.ID
+		}
+		byName[rs.Name] = rs.ID
+	}
+
+	categories := make([]CenterCategoryResponse, 0, len(filtered.Categories))
+	for _, category := range filtered.Categories {
+		item := CenterCategoryResponse{
+			ID:          category.ID,
+			Name:        category.Name,
+			Description: category.Description,
+			Entries:     make([]CenterEntryResponse, 0, len(category.Entries)),
+		}
+		for _, entry := range category.Entries {
+			id, added := byURL[entry.URL]
+			if !added {
+				id, added = byName[centerName(entry)]
+			}
+			item.Entries = append(item.Entries, CenterEntryResponse{Entry: entry, Added: added, RulesetID: id})
+		}
+		categories = append(categories, item)
+	}
+
+	response := gin.H{"categories": categories}
+	if err != nil {
+		response["warning"] = err.Error()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// AddCenterRuleset creates a remote rule set from a catalog entry and downloads it.
+// A failed download keeps the row, its last_error tells why.
+func AddCenterRuleset(c *gin.Context) {
+	var req AddCenterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UpdateInterval != nil && *req.UpdateInterval < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "update_interval must not be negative"})
+		return
+	}
+
+	catalog, _ := ruleset.LoadCatalog()
+	if catalog == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load catalog"})
+		return
+	}
+	entry, ok := catalog.Find(req.ID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "catalog entry not found"})
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = centerName(entry)
+	}
+	var count int64
+	storage.DB.Model(&storage.Ruleset{}).Where("name = ?", name).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("ruleset %q already exists", name)})
+		return
+	}
+
+	rs := storage.Ruleset{
+		Name:           name,
+		Type:           "remote",
+		Format:         entry.Format,
+		URL:            entry.URL,
+		UpdateInterval: defaultCenterInterval,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}
+	if req.UpdateInterval != nil {
+		rs.UpdateInterval = *req.UpdateInterval
+	}
+	if err := storage.DB.Create(&rs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create ruleset"})
+		return
+	}
+	if req.Enabled != nil && !*req.Enabled {
+		storage.DB.Model(&rs).Update("enabled", false)
+	}
+
+	response := gin.H{}
+	if _, err := ruleset.Update(&rs); err != nil {
+		response["error"] = err.Error()
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_create",
+		Detail:     fmt.Sprintf("Ruleset added from center: %s (%s)", rs.Name, entry.ID),
+		TargetType: "ruleset",
+		TargetID:   rs.ID,
+		TargetName: rs.Name,
+		Diff:       auditDiff(nil, rs),
+	})
+
+	response["ruleset"] = newRulesetResponse(rs)
+	c.JSON(http.StatusOK, response)
+}
+
+func centerName(entry ruleset.Entry) string {
+	if entry.Name != "" {
+		return entry.Name
+	}
+	return entry.ID
+}
+
+// createLocalRuleset inserts the row, then writes its file with save. The row is
+// removed again when the file cannot be written.
+func createLocalRuleset(c *gin.Context, rs *storage.Ruleset, enabled *bool, save func() error) bool {
+	var count int64
+	storage.DB.Model(&storage.Ruleset{}).Where("name = ?", rs.Name).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("ruleset %q already exists", rs.Name)})
+		return false
+	}
+
+	rs.Enabled = enabled == nil || *enabled
+	if err := storage.DB.Create(rs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create ruleset"})
+		return false
+	}
+	if err := save(); err != nil {
+		storage.DB.Delete(rs)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if enabled != nil && !*enabled {
+		storage.DB.Model(rs).Update("enabled", false)
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "ruleset_create",
+		Detail:     fmt.Sprintf("Ruleset created: %s (%s, %s)", rs.Name, rs.Type, rs.Format),
+		TargetType: "ruleset",
+		TargetID:   rs.ID,
+		TargetName: rs.Name,
+		Diff:       auditDiff(nil, rs),
+	})
+	return true
+}
+
+// readRulesetUpload reads the "file" form field. The format comes from the "format"
+// field or the file extension.
+func readRulesetUpload(c *gin.Context) ([]byte, string, bool) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return nil, "", false
+	}
+	if header.Size > ruleset.MaxFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file is larger than %d MB", ruleset.MaxFileSize>>20)})
+		return nil, "", false
+	}
+
+	format := c.PostForm("format")
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(header.Filename)) {
+		case ".srs":
+			format = "binary"
+		case ".json":
+			format = "source"
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format is required for files other than .json and .srs"})
+			return nil, "", false
+		}
+	}
+	if format != "source" && format != "binary" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be source or binary"})
+		return nil, "", false
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+		return nil, "", false
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, ruleset.MaxFileSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+		return nil, "", false
+	}
+	if err := ruleset.ValidateContent(format, data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	return data, format, true
+}
+
+func findRuleset(c *gin.Context) (storage.Ruleset, bool) {
+	var rs storage.Ruleset
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return rs, false
+	}
+	if err := storage.DB.First(&rs, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ruleset not found"})
+		return rs, false
+	}
+	return rs, true
+}
+
+// validateRulesetRequest checks the request, id is the row being updated or 0 on create
+func validateRulesetRequest(c *gin.Context, req *RulesetRequest, id uint) bool {
+	// The name is the rule_set tag rules refer to, it has to be unique
+	var count int64
+	storage.DB.Model(&storage.Ruleset{}).Where("name = ? AND id <> ?", req.Name, id).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("ruleset %q already exists", req.Name)})
+		return false
+	}
+
+	if req.Format != "source" && req.Format != "binary" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be source or binary"})
+		return false
+	}
+
+	if req.Exceptions != "" {
+		if req.Exceptions == req.Name {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "exceptions must name another ruleset"})
+			return false
+		}
+		storage.DB.Model(&storage.Ruleset{}).Where("name = ?", req.Exceptions).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("exceptions ruleset %q not found", req.Exceptions)})
+			return false
+		}
+	}
+
+	switch req.Type {
+	case "remote":
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an http(s) URL"})
+			return false
+		}
+		req.Path = ""
+	case "local":
+		if req.Path == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "path is required for local rulesets"})
+			return false
+		}
+		req.URL = ""
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be remote or local"})
+		return false
+	}
+	return true
+}
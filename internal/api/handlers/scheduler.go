@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"singbox.arrow.web2/internal/core/scheduler"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// maxJobRuns limits the runs returned by one request
+const maxJobRuns = 500
+
+type JobRunResponse struct {
+	ID         uint       `json:"id"`
+	TaskType   string     `json:"task_type"`
+	Trigger    string     `json:"trigger"`
+	Attempt    int        `json:"attempt"`
+	Status     string     `json:"status"`
+	Changed    bool       `json:"changed"`
+	Reloaded   bool       `json:"reloaded"`
+	Detail     string     `json:"detail"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	DurationMs int64      `json:"duration_ms"`
+}
+
+func newJobRunResponse(run storage.JobRun) JobRunResponse {
+	resp := JobRunResponse{
+		ID:         run.ID,
+		TaskType:   run.TaskType,
+		Trigger:    run.Trigger,
+		Attempt:    run.Attempt,
+		Status:     run.Status,
+		Changed:    run.Changed,
+		Reloaded:   run.Reloaded,
+		Detail:     run.Detail,
+		Error:      run.Error,
+		StartedAt:  run.StartedAt,
+		FinishedAt: run.FinishedAt,
+	}
+	if run.FinishedAt != nil {
+		resp.DurationMs = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+	}
+	return resp
+}
+
+func ListTasks(c *gin.Context) {
+	tasks, err := scheduler.Tasks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tasks)
+}
+
+func UpdateTask(c *gin.Context) {
+	var req scheduler.TaskUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, _ := scheduler.GetTask(c.Param("type"))
+	task, err := scheduler.UpdateTask(c.Param("type"), req)
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "task_update",
+		Detail:     fmt.Sprintf("Task %s updated: enabled=%t interval=%dm cron=%q", task.Type, task.Enabled, task.Interval, task.Cron),
+		TargetType: "task",
+		TargetName: task.Type,
+		Diff:       auditDiff(taskSettings(before), taskSettings(task)),
+	})
+
+	c.JSON(http.StatusOK, task)
+}
+
+// RunTask runs a task now and returns the recorded run
+func RunTask(c *gin.Context) {
+	run, err := scheduler.RunNow(c.Param("type"))
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "task_run",
+		Detail:     fmt.Sprintf("Task %s run by hand: %s", run.TaskType, run.Status),
+		TargetType: "task",
+		TargetName: run.TaskType,
+	})
+
+	c.JSON(http.StatusOK, newJobRunResponse(*run))
+}
+
+// ListJobRuns returns the run history, newest first, optionally for one task
+// type or status
+func ListJobRuns(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	if limit > maxJobRuns {
+		limit = maxJobRuns
+	}
+
+	query := storage.DB.Order("started_at DESC, id DESC").Limit(limit)
+	if taskType := c.Param("type"); taskType != "" {
+		if _, err := scheduler.GetTask(taskType); err != nil {
+			respondTaskError(c, err)
+			return
+		}
+		query = query.Where("task_type = ?", taskType)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var runs []storage.JobRun
+	if err := query.Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list job runs"})
+		return
+	}
+	result := make([]JobRunResponse, 0, len(runs))
+	for _, run := range runs {
+		result = append(result, newJobRunResponse(run))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// taskSettings is the part of a task an update changes, for the audit diff
+func taskSettings(task *scheduler.TaskInfo) gin.H {
+	if task == nil {
+		return nil
+	}
+	return gin.H{
+		"enabled":     task.Enabled,
+		"interval":    task.Interval,
+		"cron":        task.Cron,
+		"jitter":      task.Jitter,
+		"max_retries": task.MaxRetries,
+	}
+}
+
+func respondTaskError(c *gin.Context, err error) {
+	var validation *scheduler.ValidationError
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownTask):
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+	case errors.Is(err, scheduler.ErrRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.As(err, &validation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"singbox.arrow.web2/internal/core/parser"
+	"singbox.arrow.web2/internal/core/subscription"
+	"singbox.arrow.web2/internal/storage"
+)
+
+var subscriptionTypes = map[string]bool{
+	parser.FormatAuto:    true,
+	parser.FormatSingbox: true,
+	parser.FormatClash:   true,
+	parser.FormatV2Ray:   true,
+	parser.FormatBase64:  true,
+}
+
+type SubscriptionRequest struct {
+	Name           string `json:"name" binding:"required"`
+	URL            string `json:"url" binding:"required"`
+	Type           string `json:"type"`
+	UpdateInterval int    `json:"update_interval" binding:"min=0"`
+	Enabled        *bool  `json:"enabled"`
+
+	Rules *subscription.Rules `json:"rules"`
+}
+
+type SubscriptionResponse struct {
+	ID             uint       `json:"id"`
+	Name           string     `json:"name"`
+	URL            string     `json:"url"`
+	Type           string     `json:"type"`
+	UpdateInterval int        `json:"update_interval"`
+	LastUpdate     *time.Time `json:"last_update"`
+	Enabled        bool       `json:"enabled"`
+	NodeCount      int64      `json:"node_count"`
+	Upload         int64      `json:"upload"`
+	Download       int64      `json:"download"`
+	Total          int64      `json:"total"`
+	Expire         *time.Time `json:"expire"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	Usage subscription.UsageStatus `json:"usage"`
+	Rules subscription.Rules       `json:"rules"`
+}
+
+func newSubscriptionResponse(sub storage.Subscription) SubscriptionResponse {
+	var count int64
+	storage.DB.Model(&storage.Outbound{}).Where("subscription_id = ?", sub.ID).Count(&count)
+
+	return SubscriptionResponse{
+		ID:             sub.ID,
+		Name:           sub.Name,
+		URL:            sub.URL,
+		Type:           sub.Type,
+		UpdateInterval: sub.UpdateInterval,
+		LastUpdate:     sub.LastUpdate,
+		Enabled:        sub.Enabled,
+		NodeCount:      count,
+		Upload:         sub.Upload,
+		Download:       sub.Download,
+		Total:          sub.Total,
+		Expire:         sub.Expire,
+		CreatedAt:      sub.CreatedAt,
+		UpdatedAt:      sub.UpdatedAt,
+		Usage:          subscription.Status(&sub),
+		Rules:          subscription.RulesFromSubscription(&sub),
+	}
+}
+
+func ListSubscriptions(c *gin.Context) {
+	var subs []storage.Subscription
+	if err := storage.DB.Order("id").Find(&subs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list subscriptions"})
+		return
+	}
+
+	result := make([]SubscriptionResponse, 0, len(subs))
+	for _, sub := range subs {
+		result = append(result, newSubscriptionResponse(sub))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func CreateSubscription(c *gin.Context) {
+	var req SubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validateSubscriptionRequest(c, &req) {
+		return
+	}
+
+	sub := storage.Subscription{
+		Name:           req.Name,
+		URL:            req.URL,
+		Type:           req.Type,
+		UpdateInterval: req.UpdateInterval,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+		FilterInfo:     true,
+		Dedup:          true,
+	}
+	applySubscriptionRules(&sub, req.Rules)
+	if err := storage.DB.Create(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create subscription"})
+		return
+	}
+	// GORM skips false on create for columns with a default, write them explicitly
+	storage.DB.Model(&sub).Updates(map[string]interface{}{
+		"enabled":     req.Enabled == nil || *req.Enabled,
+		"filter_info": sub.FilterInfo,
+		"dedup":       sub.Dedup,
+	})
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "subscription_create",
+		Detail:     "Subscription created: " + sub.Name,
+		TargetType: "subscription",
+		TargetID:   sub.ID,
+		TargetName: sub.Name,
+		Diff:       auditDiff(nil, sub),
+	})
+
+	c.JSON(http.StatusOK, newSubscriptionResponse(sub))
+}
+
+func UpdateSubscription(c *gin.Context) {
+	sub, ok := findSubscription(c)
+	if !ok {
+		return
+	}
+	before := sub
+
+	var req SubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validateSubscriptionRequest(c, &req) {
+		return
+	}
+
+	sub.Name = req.Name
+	sub.URL = req.URL
+	sub.Type = req.Type
+	sub.UpdateInterval = req.UpdateInterval
+	if req.Enabled != nil {
+		sub.Enabled = *req.Enabled
+	}
+	applySubscriptionRules(&sub, req.Rules)
+	if err := storage.DB.Save(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update subscription"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "subscription_update",
+		Detail:     "Subscription updated: " + sub.Name,
+		TargetType: "subscription",
+		TargetID:   sub.ID,
+		TargetName: sub.Name,
+		Diff:       auditDiff(before, sub),
+	})
+
+	c.JSON(http.StatusOK, newSubscriptionResponse(sub))
+}
+
+func DeleteSubscription(c *gin.Context) {
+	sub, ok := findSubscription(c)
+	if !ok {
+		return
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("outbound_id IN (?)", tx.Model(&storage.Outbound{}).Select("id").Where("subscription_id = ?", sub.ID)).Delete(&storage.LatencyHistory{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("subscription_id = ?", sub.ID).Delete(&storage.Outbound{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("subscription_id = ?", sub.ID).Delete(&storage.SubscriptionUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&sub).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete subscription"})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "subscription_delete",
+		Detail:     "Subscription deleted: " + sub.Name,
+		TargetType: "subscription",
+		TargetID:   sub.ID,
+		TargetName: sub.Name,
+		Diff:       auditDiff(sub, nil),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "subscription deleted"})
+}
+
+func RefreshSubscription(c *gin.Context) {
+	sub, ok := findSubscription(c)
+	if !ok {
+		return
+	}
+
+	summary, err := subscription.Refresh(&sub)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "subscription_refresh",
+		Detail:     summary.Describe(sub.Name),
+		TargetType: "subscription",
+		TargetID:   sub.ID,
+		TargetName: sub.Name,
+	})
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// GetSubscriptionUsage returns the quota history recorded on each refresh, newest first
+func GetSubscriptionUsage(c *gin.Context) {
+	sub, ok := findSubscription(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	var history []storage.SubscriptionUsage
+	if err := storage.DB.Where("subscription_id = ?", sub.ID).Order("created_at desc").Limit(limit).Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage history"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(history))
+	for _, h := range history {
+		result = append(result, gin.H{
+			"upload":     h.Upload,
+			"download":   h.Download,
+			"total":      h.Total,
+			"expire":     h.Expire,
+			"created_at": h.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscription": newSubscriptionResponse(sub),
+		"history":      result,
+	})
+}
+
+// PreviewSubscription fetches the subscription and shows the node list before and
+// after processing. Rules in the body override the saved ones so edits can be tried first.
+func PreviewSubscription(c *gin.Context) {
+	sub, ok := findSubscription(c)
+	if !ok {
+		return
+	}
+
+	rules := subscription.RulesFromSubscription(&sub)
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&rules); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := rules.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preview, err := subscription.Preview(&sub, rules)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
+
+func applySubscriptionRules(sub *storage.Subscription, rules *subscription.Rules) {
+	if rules == nil {
+		return
+	}
+	renameRules := ""
+	if len(rules.RenameRules) > 0 {
+		data, _ := json.Marshal(rules.RenameRules)
+		renameRules = string(data)
+	}
+	sub.FilterInfo = rules.FilterInfo
+	sub.IncludePattern = rules.IncludePattern
+	sub.ExcludePattern = rules.ExcludePattern
+	sub.RenameRules = renameRules
+	sub.RegionEmoji = rules.RegionEmoji
+	sub.SortBy = rules.SortBy
+	sub.Dedup = rules.Dedup
+}
+
+func findSubscription(c *gin.Context) (storage.Subscription, bool) {
+	var sub storage.Subscription
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return sub, false
+	}
+	if err := storage.DB.First(&sub, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return sub, false
+	}
+	return sub, true
+}
+
+func validateSubscriptionRequest(c *gin.Context, req *SubscriptionRequest) bool {
+	if req.Type == "" {
+		req.Type = parser.FormatAuto
+	}
+	if !subscriptionTypes[req.Type] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported subscription type %q", req.Type)})
+		return false
+	}
+
+	if req.Rules != nil {
+		if err := req.Rules.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an http(s) URL"})
+		return false
+	}
+	return true
+}
@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"os"
-	"time"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"singbox.arrow.web2/internal/core/singbox"
@@ -57,6 +60,13 @@ func StartSingbox(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action: "singbox_start",
+		Detail: fmt.Sprintf("sing-box started with PID %d", singboxManager.GetPid()),
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "sing-box started"})
 }
 
@@ -65,6 +75,13 @@ func StopSingbox(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action: "singbox_stop",
+		Detail: "sing-box stopped",
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "sing-box stopped"})
 }
 
@@ -73,6 +90,13 @@ func RestartSingbox(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action: "singbox_restart",
+		Detail: fmt.Sprintf("sing-box restarted with PID %d", singboxManager.GetPid()),
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "sing-box restarted"})
 }
 
@@ -106,10 +130,9 @@ func UpgradeSingbox(c *gin.Context) {
 	}
 
 	// Log operation
-	storage.DB.Create(&storage.OperationLog{
-		Action:    "singbox_upgrade",
-		Detail:    "Upgraded to " + version,
-		CreatedAt: time.Now(),
+	logOperation(c, storage.OperationLog{
+		Action: "singbox_upgrade",
+		Detail: "Upgraded to " + version,
 	})
 
 	c.JSON(http.StatusOK, gin.H{
@@ -128,3 +151,152 @@ func GetGeneratedConfig(c *gin.Context) {
 
 	c.Data(http.StatusOK, "application/json", data)
 }
+
+func ApplyConfig(c *gin.Context) {
+	result, err := singboxManager.Reload()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action: "config_apply",
+		Detail: "Config applied, hash " + result.Hash,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "config applied",
+		"hash":    result.Hash,
+		"changed": result.Changed,
+	})
+}
+
+// editableSettings lists the settings that may be changed through the API,
+// with an optional validator for each value
+var editableSettings = map[string]func(string) bool{
+	"singbox_path":                     isSingboxBinary,
+	"download_proxy_enabled":           isBoolString,
+	"download_proxy_url":               nil,
+	"log_level":                        isLogLevel,
+	"dns_server":                       nil,
+	"route_final":                      nil,
+	"subscription_alert_quota_percent": isPercent,
+	"subscription_alert_expire_days":   isNonNegativeInt,
+	"log_retention_days":               isNonNegativeInt,
+	"backup_keep":                      isNonNegativeInt,
+	"core_log_max_size_mb":             isNonNegativeInt,
+	"core_log_max_files":               isNonNegativeInt,
+	"core_log_max_age_days":            isNonNegativeInt,
+	"clash_api_port":                   isPortOrZero,
+}
+
+func GetSettings(c *gin.Context) {
+	result := make(map[string]string, len(editableSettings))
+	for key := range editableSettings {
+		value, _ := storage.GetSetting(key)
+		result[key] = value
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func UpdateSettings(c *gin.Context) {
+	var req map[string]string
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for key, value := range req {
+		validate, ok := editableSettings[key]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "setting cannot be changed: " + key})
+			return
+		}
+		if validate != nil && !validate(value) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid value for " + key})
+			return
+		}
+	}
+
+	before := make(map[string]string, len(req))
+	after := make(map[string]string, len(req))
+	for key, value := range req {
+		before[key], _ = storage.GetSetting(key)
+		after[key] = value
+	}
+
+	for key, value := range req {
+		if err := storage.SetSetting(key, value); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save " + key})
+			return
+		}
+	}
+	singboxManager.LogFiles().SetLimits(singbox.LogFileLimits())
+
+	// Log operation
+	logOperation(c, storage.OperationLog{
+		Action:     "settings_update",
+		Detail:     fmt.Sprintf("Updated %d settings", len(req)),
+		TargetType: "setting",
+		Diff:       auditDiff(before, after),
+	})
+
+	GetSettings(c)
+}
+
+// isSingboxBinary accepts an executable file under the data directory, or empty
+// for the default path. Any other path would let API callers run arbitrary programs.
+func isSingboxBinary(v string) bool {
+	if v == "" {
+		return true
+	}
+	if !filepath.IsAbs(v) {
+		return false
+	}
+	path, err := filepath.EvalSymlinks(v)
+	if err != nil {
+		return false
+	}
+	dir, err := filepath.Abs(dataDir)
+	if err != nil {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+func isBoolString(v string) bool {
+	return v == "true" || v == "false"
+}
+
+func isLogLevel(v string) bool {
+	switch v {
+	case "trace", "debug", "info", "warn", "error", "fatal", "panic":
+		return true
+	}
+	return false
+}
+
+func isPercent(v string) bool {
+	n, err := strconv.Atoi(v)
+	return err == nil && n >= 0 && n <= 100
+}
+
+// isPortOrZero accepts a TCP port, 0 turns the feature off
+func isPortOrZero(v string) bool {
+	n, err := strconv.Atoi(v)
+	return err == nil && n >= 0 && n <= 65535
+}
+
+func isNonNegativeInt(v string) bool {
+	n, err := strconv.Atoi(v)
+	return err == nil && n >= 0
+}
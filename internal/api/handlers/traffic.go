@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"singbox.arrow.web2/internal/core/traffic"
+	"singbox.arrow.web2/internal/storage"
+)
+
+const dateLayout = "2006-01-02"
+
+type TrafficStatResponse struct {
+	// Period is the day, the Monday starting the week, or the month as YYYY-MM.
+	// It is empty in totals.
+	Period     string `json:"period,omitempty"`
+	TargetType string `json:"target_type"`
+	TargetName string `json:"target_name"`
+	Upload     int64  `json:"upload"`
+	Download   int64  `json:"download"`
+	Total      int64  `json:"total"`
+}
+
+type trafficKey struct {
+	period, targetType, name string
+}
+
+// GetTrafficStats returns the bytes carried per outbound, inbound and rule,
+// summed by day, week or month. Items are newest first and within a period by
+// total, totals sums the whole range per target.
+func GetTrafficStats(c *gin.Context) {
+	period := c.DefaultQuery("period", "day")
+	targetType := c.Query("type")
+	switch targetType {
+	case "", traffic.TargetOutbound, traffic.TargetInbound, traffic.TargetRule:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid type, expected outbound, inbound or rule"})
+		return
+	}
+
+	to := time.Now()
+	var from time.Time
+	switch period {
+	case "day":
+		from = to.AddDate(0, 0, -29)
+	case "week":
+		from = weekStart(to).AddDate(0, 0, -7*11)
+	case "month":
+		from = time.Date(to.Year(), to.Month()-11, 1, 0, 0, 0, 0, to.Location())
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid period, expected day, week or month"})
+		return
+	}
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := c.Query(name); v != "" {
+			parsed, err := time.ParseInLocation(dateLayout, v, time.Local)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ", expected YYYY-MM-DD"})
+				return
+			}
+			*t = parsed
+		}
+	}
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from is after to"})
+		return
+	}
+
+	query := storage.DB.Where("date >= ? AND date <= ?", from.Format(dateLayout), to.Format(dateLayout))
+	if targetType != "" {
+		query = query.Where("target_type = ?", targetType)
+	}
+	if name := c.Query("name"); name != "" {
+		query = query.Where("target_name = ?", name)
+	}
+	var stats []storage.TrafficStat
+	if err := query.Find(&stats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make(map[trafficKey]*TrafficStatResponse)
+	totals := make(map[trafficKey]*TrafficStatResponse)
+	for _, s := range stats {
+		day, err := time.ParseInLocation(dateLayout, s.Date, time.Local)
+		if err != nil {
+			continue
+		}
+		key := trafficKey{periodKey(period, day), s.TargetType, s.TargetName}
+		addTraffic(items, key, s)
+		key.period = ""
+		addTraffic(totals, key, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"period": period,
+		"from":   from.Format(dateLayout),
+		"to":     to.Format(dateLayout),
+		"items":  sortTraffic(items),
+		"totals": sortTraffic(totals),
+	})
+}
+
+// periodKey returns the period a day falls in
+func periodKey(period string, day time.Time) string {
+	switch period {
+	case "week":
+		return weekStart(day).Format(dateLayout)
+	case "month":
+		return day.Format("2006-01")
+	}
+	return day.Format(dateLayout)
+}
+
+// weekStart returns the Monday of the week t falls in
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
+
+func addTraffic(sums map[trafficKey]*TrafficStatResponse, key trafficKey, s storage.TrafficStat) {
+	sum, ok := sums[key]
+	if !ok {
+		sum = &TrafficStatResponse{Period: key.period, TargetType: key.targetType, TargetName: key.name}
+		sums[key] = sum
+	}
+	sum.Upload += s.Upload
+	sum.Download += s.Download
+	sum.Total += s.Upload + s.Download
+}
+
+func sortTraffic(sums map[trafficKey]*TrafficStatResponse) []TrafficStatResponse {
+	list := make([]TrafficStatResponse, 0, len(sums))
+	for _, sum := range sums {
+		list = append(list, *sum)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Period != list[j].Period {
+			return list[i].Period > list[j].Period
+		}
+		if list[i].Total != list[j].Total {
+			return list[i].Total > list[j].Total
+		}
+		if list[i].TargetType != list[j].TargetType {
+			return list[i].TargetType < list[j].TargetType
+		}
+		return list[i].TargetName < list[j].TargetName
+	})
+	return list
+}
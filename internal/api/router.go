@@ -7,7 +7,8 @@ import (
 )
 
 func SetupRouter() *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	r.Use(middleware.Logger(), gin.Recovery())
 
 	// CORS middleware
 	r.Use(func(c *gin.Context) {
@@ -53,6 +54,108 @@ func SetupRouter() *gin.Engine {
 				system.GET("/version", handlers.GetSystemVersion)
 				system.POST("/upgrade", handlers.UpgradeSingbox)
 				system.GET("/config", handlers.GetGeneratedConfig)
+				system.POST("/apply", handlers.ApplyConfig)
+				system.GET("/settings", handlers.GetSettings)
+				system.PUT("/settings", handlers.UpdateSettings)
+			}
+
+			// Inbound routes
+			inbounds := protected.Group("/inbounds")
+			{
+				inbounds.GET("", handlers.ListInbounds)
+				inbounds.POST("", handlers.CreateInbound)
+				inbounds.PUT("/:id", handlers.UpdateInbound)
+				inbounds.DELETE("/:id", handlers.DeleteInbound)
+				inbounds.PATCH("/:id/toggle", handlers.ToggleInbound)
+			}
+
+			// Outbound routes
+			outbounds := protected.Group("/outbounds")
+			{
+				outbounds.GET("", handlers.ListOutbounds)
+				outbounds.POST("", handlers.CreateOutbound)
+				outbounds.POST("/import", handlers.ImportOutbounds)
+				outbounds.POST("/test", handlers.TestOutbounds)
+				outbounds.PUT("/:id", handlers.UpdateOutbound)
+				outbounds.DELETE("/:id", handlers.DeleteOutbound)
+				outbounds.PATCH("/:id/toggle", handlers.ToggleOutbound)
+				outbounds.POST("/:id/test", handlers.TestOutbound)
+				outbounds.GET("/:id/latency", handlers.GetOutboundLatency)
+			}
+
+			// Outbound group routes
+			groups := protected.Group("/groups")
+			{
+				groups.GET("", handlers.ListGroups)
+				groups.POST("", handlers.CreateGroup)
+				groups.PUT("/:id", handlers.UpdateGroup)
+				groups.DELETE("/:id", handlers.DeleteGroup)
+				groups.PATCH("/:id/toggle", handlers.ToggleGroup)
+			}
+
+			// Subscription routes
+			subscriptions := protected.Group("/subscriptions")
+			{
+				subscriptions.GET("", handlers.ListSubscriptions)
+				subscriptions.POST("", handlers.CreateSubscription)
+				subscriptions.PUT("/:id", handlers.UpdateSubscription)
+				subscriptions.DELETE("/:id", handlers.DeleteSubscription)
+				subscriptions.POST("/:id/refresh", handlers.RefreshSubscription)
+				subscriptions.GET("/:id/usage", handlers.GetSubscriptionUsage)
+				subscriptions.POST("/:id/preview", handlers.PreviewSubscription)
+			}
+
+			// Ruleset routes
+			rulesets := protected.Group("/rulesets")
+			{
+				rulesets.GET("", handlers.ListRulesets)
+				rulesets.POST("", handlers.CreateRuleset)
+				rulesets.POST("/refresh", handlers.RefreshRulesets)
+				rulesets.POST("/local", handlers.CreateLocalRuleset)
+				rulesets.POST("/upload", handlers.UploadRuleset)
+				rulesets.POST("/convert", handlers.ConvertRuleset)
+				rulesets.GET("/lookup", handlers.LookupRulesets)
+				rulesets.GET("/center", handlers.GetRulesetCenter)
+				rulesets.POST("/center", handlers.AddCenterRuleset)
+				rulesets.PUT("/:id", handlers.UpdateRuleset)
+				rulesets.DELETE("/:id", handlers.DeleteRuleset)
+				rulesets.PATCH("/:id/toggle", handlers.ToggleRuleset)
+				rulesets.POST("/:id/refresh", handlers.RefreshRuleset)
+				rulesets.POST("/:id/upload", handlers.ReplaceRulesetFile)
+				rulesets.GET("/:id/rules", handlers.GetRulesetRules)
+				rulesets.PUT("/:id/rules", handlers.UpdateRulesetRules)
+				rulesets.GET("/:id/export", handlers.ExportRuleset)
+			}
+
+			// Rule routes
+			rules := protected.Group("/rules")
+			{
+				rules.GET("", handlers.ListRules)
+				rules.POST("", handlers.CreateRule)
+				rules.PUT("/reorder", handlers.ReorderRules)
+				rules.POST("/simulate", handlers.SimulateRoute)
+				rules.PUT("/:id", handlers.UpdateRule)
+				rules.DELETE("/:id", handlers.DeleteRule)
+				rules.PATCH("/:id/toggle", handlers.ToggleRule)
+			}
+
+			// Log routes
+			logs := protected.Group("/logs")
+			{
+				logs.GET("/realtime", handlers.StreamLogs)
+				logs.GET("/core", handlers.SearchCoreLogs)
+				logs.GET("/operations", handlers.ListOperationLogs)
+				logs.GET("/traffic", handlers.GetTrafficStats)
+			}
+
+			// Scheduler routes
+			scheduler := protected.Group("/scheduler")
+			{
+				scheduler.GET("/tasks", handlers.ListTasks)
+				scheduler.PUT("/tasks/:type", handlers.UpdateTask)
+				scheduler.POST("/tasks/:type/run", handlers.RunTask)
+				scheduler.GET("/tasks/:type/runs", handlers.ListJobRuns)
+				scheduler.GET("/runs", handlers.ListJobRuns)
 			}
 		}
 	}
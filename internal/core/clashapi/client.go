@@ -0,0 +1,110 @@
+// Package clashapi talks to the Clash-compatible controller of the running
+// sing-box core.
+package clashapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"singbox.arrow.web2/internal/core/singbox"
+)
+
+// Client is the Clash-compatible controller of the running core
+type Client struct {
+	base   string
+	secret string
+	tags   map[string]bool
+}
+
+// Running returns the controller of the running core, or nil when the core is
+// stopped or its config does not enable experimental.clash_api
+func Running(manager *singbox.Manager) *Client {
+	if manager.GetStatus() != singbox.StatusRunning {
+		return nil
+	}
+
+	data, err := os.ReadFile(manager.GetConfigPath())
+	if err != nil {
+		return nil
+	}
+	var cfg struct {
+		Outbounds []struct {
+			Tag string `json:"tag"`
+		} `json:"outbounds"`
+		Experimental struct {
+			ClashAPI struct {
+				ExternalController string `json:"external_controller"`
+				Secret             string `json:"secret"`
+			} `json:"clash_api"`
+		} `json:"experimental"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil || cfg.Experimental.ClashAPI.ExternalController == "" {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(cfg.Experimental.ClashAPI.ExternalController)
+	if err != nil {
+		return nil
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	api := &Client{
+		base:   "http://" + net.JoinHostPort(host, port),
+		secret: cfg.Experimental.ClashAPI.Secret,
+		tags:   make(map[string]bool, len(cfg.Outbounds)),
+	}
+	for _, out := range cfg.Outbounds {
+		api.tags[out.Tag] = true
+	}
+	return api
+}
+
+// HasTag reports whether the running config has an outbound with the tag
+func (a *Client) HasTag(tag string) bool {
+	return tag != "" && a.tags[tag]
+}
+
+// Delay asks the core to run a URL test through the outbound with the given tag
+func (a *Client) Delay(tag, testURL string, timeout time.Duration) (time.Duration, error) {
+	query := url.Values{}
+	query.Set("url", testURL)
+	query.Set("timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+	resp, err := a.get("/proxies/"+url.PathEscape(tag)+"/delay?"+query.Encode(), timeout+2*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Delay   int    `json:"delay"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if body.Message == "" {
+			body.Message = resp.Status
+		}
+		return 0, fmt.Errorf("clash api: %s", body.Message)
+	}
+	return time.Duration(body.Delay) * time.Millisecond, nil
+}
+
+func (a *Client) get(path string, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, a.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if a.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+a.secret)
+	}
+	client := &http.Client{Timeout: timeout}
+	return client.Do(req)
+}
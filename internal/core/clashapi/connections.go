@@ -0,0 +1,84 @@
+package clashapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds the calls that only read state
+const requestTimeout = 5 * time.Second
+
+// Connection is one open connection with its byte counters since it was opened
+type Connection struct {
+	ID       string `json:"id"`
+	Upload   int64  `json:"upload"`
+	Download int64  `json:"download"`
+	Metadata struct {
+		Network string `json:"network"`
+		// Type is the inbound as type/tag, e.g. mixed/mixed-in
+		Type            string `json:"type"`
+		Host            string `json:"host"`
+		DestinationIP   string `json:"destinationIP"`
+		DestinationPort string `json:"destinationPort"`
+	} `json:"metadata"`
+	// Chains lists the outbounds the connection went through, the one that
+	// carried it first and the selecting groups after it
+	Chains      []string `json:"chains"`
+	Rule        string   `json:"rule"`
+	RulePayload string   `json:"rulePayload"`
+}
+
+// Inbound returns the tag of the inbound the connection came in on
+func (c *Connection) Inbound() string {
+	if _, tag, ok := strings.Cut(c.Metadata.Type, "/"); ok {
+		return tag
+	}
+	return c.Metadata.Type
+}
+
+// Outbound returns the outbound that carried the connection
+func (c *Connection) Outbound() string {
+	if len(c.Chains) == 0 {
+		return ""
+	}
+	return c.Chains[0]
+}
+
+// MatchedRule returns the rule that routed the connection without its action,
+// e.g. rule_set=geosite-cn, or final when no rule matched
+func (c *Connection) MatchedRule() string {
+	rule, _, _ := strings.Cut(c.Rule, " => ")
+	rule = strings.TrimSpace(rule)
+	if c.RulePayload != "" && !strings.Contains(rule, c.RulePayload) {
+		rule += "(" + c.RulePayload + ")"
+	}
+	return rule
+}
+
+// Snapshot is the state of all open connections
+type Snapshot struct {
+	UploadTotal   int64        `json:"uploadTotal"`
+	DownloadTotal int64        `json:"downloadTotal"`
+	Connections   []Connection `json:"connections"`
+}
+
+// Connections lists the open connections
+func (a *Client) Connections() (*Snapshot, error) {
+	resp, err := a.get("/connections", requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("clash api: %s", resp.Status)
+	}
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("clash api: invalid connections response: %w", err)
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,236 @@
+// Package corelog parses the log output of the sing-box core into structured events.
+package corelog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeLayout is the timestamp sing-box writes in front of each line when
+// log.timestamp is enabled
+const timeLayout = "-0700 2006-01-02 15:04:05"
+
+// contextLimit bounds how many connections the parser remembers
+const contextLimit = 4096
+
+// Event is one parsed log line. Fields that the line does not carry are taken
+// from earlier lines of the same connection when possible.
+type Event struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	// Component is the part before the first colon: inbound, outbound, router,
+	// dns, connection, ...
+	Component string `json:"component,omitempty"`
+	// Protocol is the inbound or outbound type, e.g. mixed or vless
+	Protocol    string `json:"protocol,omitempty"`
+	ConnID      string `json:"conn_id,omitempty"`
+	Inbound     string `json:"inbound,omitempty"`
+	Outbound    string `json:"outbound,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	// Rule is the matched rule as sing-box prints it, e.g. rule_set=geosite-cn.
+	// RuleIndex is its position in route.rules, -1 when no rule matched.
+	Rule      string `json:"rule,omitempty"`
+	RuleIndex int    `json:"rule_index"`
+	// Action is set for rule actions other than routing, e.g. reject or sniff
+	Action  string `json:"action,omitempty"`
+	Message string `json:"message"`
+	Raw     string `json:"raw"`
+}
+
+// levels orders sing-box log levels by severity
+var levels = map[string]int{
+	"trace": 0, "debug": 1, "info": 2, "warn": 3, "error": 4, "fatal": 5, "panic": 6,
+}
+
+// ValidLevel reports whether level is a known log level
+func ValidLevel(level string) bool {
+	_, ok := levels[level]
+	return ok
+}
+
+// Severity returns the rank of a level, unknown levels rank as info
+func Severity(level string) int {
+	if n, ok := levels[level]; ok {
+		return n
+	}
+	return levels["info"]
+}
+
+// ruleActions are the rule actions that do not name an outbound
+var ruleActions = map[string]bool{
+	"reject": true, "sniff": true, "hijack-dns": true, "resolve": true, "route-options": true, "bypass": true,
+}
+
+var (
+	ansiPattern      = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	contextPattern   = regexp.MustCompile(`^\[(\d+)(?: [^\]]*)?\] `)
+	componentPattern = regexp.MustCompile(`^([a-z][a-z0-9-]*)(?:/([a-z0-9-]+))?(?:\[([^\]]*)\])?: `)
+	matchPattern     = regexp.MustCompile(`^match\[(\d+)\] (.*?) => (.+)$`)
+	usingPattern     = regexp.MustCompile(` using (inbound|outbound)/[a-z0-9-]+\[([^\]]*)\]`)
+	toPattern        = regexp.MustCompile(`\bconnection to (\S+?):?(?: |$)`)
+	fromPattern      = regexp.MustCompile(`\bconnection from (\S+?):?(?: |$)`)
+)
+
+// Parser turns lines into events and remembers the inbound, destination and
+// routing of recent connections. It is safe for concurrent use.
+type Parser struct {
+	mu    sync.Mutex
+	conns map[string]*Event
+	order []string // connection IDs, oldest first
+}
+
+func NewParser() *Parser {
+	return &Parser{conns: make(map[string]*Event)}
+}
+
+// Parse parses one line. Lines that do not follow the sing-box format still
+// produce an event with the whole line as message.
+func (p *Parser) Parse(line string) Event {
+	event := Parse(line)
+	if event.ConnID != "" {
+		p.enrich(&event)
+	}
+	return event
+}
+
+// enrich fills the connection fields the line lacks from earlier lines and
+// records the ones it has
+func (p *Parser) enrich(event *Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	known, ok := p.conns[event.ConnID]
+	if !ok {
+		known = &Event{RuleIndex: -1}
+		p.conns[event.ConnID] = known
+		p.order = append(p.order, event.ConnID)
+		if len(p.order) > contextLimit {
+			delete(p.conns, p.order[0])
+			p.order = p.order[1:]
+		}
+	}
+	merge(&known.Inbound, &event.Inbound)
+	merge(&known.Outbound, &event.Outbound)
+	merge(&known.Source, &event.Source)
+	merge(&known.Destination, &event.Destination)
+	merge(&known.Rule, &event.Rule)
+	if event.RuleIndex >= 0 {
+		known.RuleIndex = event.RuleIndex
+	} else {
+		event.RuleIndex = known.RuleIndex
+	}
+}
+
+// merge records a value the line has, or fills in the remembered one
+func merge(known, value *string) {
+	if *value != "" {
+		*known = *value
+	} else {
+		*value = *known
+	}
+}
+
+// Parse parses one line without connection context
+func Parse(line string) Event {
+	line = ansiPattern.ReplaceAllString(line, "")
+	event := Event{Level: "info", RuleIndex: -1, Raw: line}
+	rest := line
+
+	if len(rest) > len(timeLayout) {
+		if t, err := time.Parse(timeLayout, rest[:len(timeLayout)]); err == nil {
+			event.Time = t
+			rest = strings.TrimLeft(rest[len(timeLayout):], " ")
+		}
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if field, after, _ := strings.Cut(rest, " "); field != "" {
+		level := strings.ToLower(field)
+		if level == "warning" {
+			level = "warn"
+		}
+		if ValidLevel(level) && field == strings.ToUpper(field) {
+			event.Level = level
+			rest = after
+		} else if field == "panic:" {
+			// A Go runtime panic, written without the sing-box prefix
+			event.Level = "panic"
+			event.Message = rest
+			return event
+		}
+	}
+
+	if m := contextPattern.FindStringSubmatch(rest); m != nil {
+		event.ConnID = m[1]
+		rest = rest[len(m[0]):]
+	}
+
+	if m := componentPattern.FindStringSubmatch(rest); m != nil {
+		event.Component = m[1]
+		event.Protocol = m[2]
+		switch m[1] {
+		case "inbound":
+			event.Inbound = m[3]
+		case "outbound":
+			event.Outbound = m[3]
+		}
+		rest = rest[len(m[0]):]
+	}
+	event.Message = rest
+
+	parseMessage(&event)
+	return event
+}
+
+// parseMessage extracts the connection details from the message text
+func parseMessage(event *Event) {
+	msg := event.Message
+
+	if m := matchPattern.FindStringSubmatch(msg); m != nil {
+		event.RuleIndex, _ = strconv.Atoi(m[1])
+		event.Rule = m[2]
+		// DNS rules route to DNS servers, not outbounds
+		if event.Component == "router" {
+			target := m[3]
+			if inner, ok := strings.CutPrefix(target, "route("); ok {
+				event.Outbound = strings.TrimSuffix(inner, ")")
+			} else if name, _, _ := strings.Cut(target, "("); ruleActions[name] {
+				event.Action = name
+			} else {
+				// sing-box before 1.11 prints the outbound tag alone
+				event.Outbound = target
+			}
+		}
+		return
+	}
+
+	if m := usingPattern.FindStringSubmatch(msg); m != nil {
+		if m[1] == "inbound" {
+			event.Inbound = m[2]
+		} else {
+			event.Outbound = m[2]
+		}
+	}
+	if m := toPattern.FindStringSubmatch(msg); m != nil {
+		event.Destination = m[1]
+	}
+	if m := fromPattern.FindStringSubmatch(msg); m != nil {
+		event.Source = m[1]
+	}
+
+	if event.Component == "dns" && event.Destination == "" {
+		fields := strings.Fields(msg)
+		switch {
+		case len(fields) >= 3 && fields[0] == "lookup" && fields[1] == "domain":
+			event.Destination = strings.TrimSuffix(fields[2], ".")
+		case len(fields) >= 2 && (fields[0] == "exchange" || fields[0] == "exchanged" || fields[0] == "cached"):
+			event.Destination = strings.TrimSuffix(fields[1], ".")
+		}
+	}
+}
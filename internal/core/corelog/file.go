@@ -0,0 +1,282 @@
+package corelog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LogDir holds the core log files, relative to the data directory
+	LogDir = "logs"
+
+	activeFile    = "singbox.log"
+	rotatedPrefix = "singbox-"
+	stampLayout   = "20060102-150405.000"
+
+	// retryInterval is how long the writer waits before reopening a file that
+	// could not be opened, so a full disk does not flood the log
+	retryInterval = time.Minute
+)
+
+// Limits controls rotation and retention. Zero disables a limit.
+type Limits struct {
+	MaxSize  int64 // bytes, the active file is rotated before it grows past this
+	MaxFiles int   // rotated files kept
+	MaxAge   time.Duration
+}
+
+// FileWriter appends events to logs/singbox.log. The file is rotated when it
+// would exceed MaxSize and at midnight, rotated files are compressed and
+// removed once they exceed MaxFiles or MaxAge.
+type FileWriter struct {
+	mu      sync.Mutex
+	dir     string
+	limits  Limits
+	file    *os.File
+	size    int64
+	day     string // date the active file was started
+	retryAt time.Time
+}
+
+func NewFileWriter(dir string, limits Limits) *FileWriter {
+	return &FileWriter{dir: dir, limits: limits}
+}
+
+// Dir returns the directory the files are written to
+func (w *FileWriter) Dir() string {
+	return w.dir
+}
+
+// SetLimits changes the limits, they apply from the next write
+func (w *FileWriter) SetLimits(limits Limits) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.limits = limits
+}
+
+// Write appends the raw line of event. Lines sing-box wrote without a timestamp
+// get the time they were read, so every line in the files can be searched by time.
+func (w *FileWriter) Write(event Event) error {
+	line := event.Raw
+	if !hasTimestamp(line) {
+		line = event.Time.Format(timeLayout) + " " + line
+	}
+	line += "\n"
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if w.file != nil {
+		full := w.limits.MaxSize > 0 && w.size > 0 && w.size+int64(len(line)) > w.limits.MaxSize
+		if full || now.Format("20060102") != w.day {
+			w.rotate(now)
+		}
+	}
+	if w.file == nil {
+		if now.Before(w.retryAt) {
+			return nil
+		}
+		if err := w.open(); err != nil {
+			w.retryAt = now.Add(retryInterval)
+			return err
+		}
+	}
+
+	n, err := w.file.WriteString(line)
+	w.size += int64(n)
+	return err
+}
+
+// Close closes the active file, the next write reopens it
+func (w *FileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *FileWriter) open() error {
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(w.dir, activeFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	// A file left over from an earlier day is rotated on the first write
+	w.day = info.ModTime().Format("20060102")
+	if info.Size() == 0 {
+		w.day = time.Now().Format("20060102")
+	}
+	return nil
+}
+
+// rotate renames the active file and compresses it in the background
+func (w *FileWriter) rotate(now time.Time) {
+	w.file.Close()
+	w.file = nil
+
+	rotated := filepath.Join(w.dir, rotatedPrefix+now.Format(stampLayout)+".log")
+	if err := os.Rename(filepath.Join(w.dir, activeFile), rotated); err != nil {
+		log.Printf("Failed to rotate sing-box log: %v", err)
+		return
+	}
+	limits := w.limits
+	go func() {
+		if err := compress(rotated); err != nil {
+			log.Printf("Failed to compress sing-box log %s: %v", filepath.Base(rotated), err)
+		}
+		prune(w.dir, limits, time.Now())
+	}()
+}
+
+// Prune removes the rotated files beyond the retention limits and returns how
+// many were removed
+func (w *FileWriter) Prune() int {
+	w.mu.Lock()
+	limits := w.limits
+	w.mu.Unlock()
+	return prune(w.dir, limits, time.Now())
+}
+
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// logFile is a file in the log directory. Rotated files carry the time they
+// were rotated, which is after their last line.
+type logFile struct {
+	name    string
+	rotated time.Time // zero for the active file
+}
+
+// listFiles returns the log files, newest first. A rotated file that is being
+// compressed is listed once.
+func listFiles(dir string) ([]logFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		names[e.Name()] = true
+	}
+
+	var files []logFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			continue
+		}
+		if name == activeFile {
+			files = append(files, logFile{name: name})
+			continue
+		}
+		stamp, ok := strings.CutPrefix(name, rotatedPrefix)
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(stamp, ".log") && names[name+".gz"] {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(strings.TrimSuffix(stamp, ".gz"), ".log")
+		if !ok {
+			continue
+		}
+		rotated, err := time.ParseInLocation(stampLayout, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		files = append(files, logFile{name: name, rotated: rotated})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].rotated.IsZero() != files[j].rotated.IsZero() {
+			return files[i].rotated.IsZero()
+		}
+		return files[i].rotated.After(files[j].rotated)
+	})
+	return files, nil
+}
+
+func prune(dir string, limits Limits, now time.Time) int {
+	files, err := listFiles(dir)
+	if err != nil {
+		return 0
+	}
+	removed, kept := 0, 0
+	for _, f := range files {
+		if f.rotated.IsZero() {
+			continue
+		}
+		expired := limits.MaxAge > 0 && now.Sub(f.rotated) > limits.MaxAge
+		if expired || (limits.MaxFiles > 0 && kept >= limits.MaxFiles) {
+			if os.Remove(filepath.Join(dir, f.name)) == nil {
+				removed++
+			}
+			continue
+		}
+		kept++
+	}
+	return removed
+}
+
+func hasTimestamp(line string) bool {
+	if len(line) < len(timeLayout) {
+		return false
+	}
+	_, err := time.Parse(timeLayout, line[:len(timeLayout)])
+	return err == nil
+}
@@ -0,0 +1,190 @@
+package corelog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned for a cursor Search did not produce
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Query selects events from the log files. Zero fields match everything.
+type Query struct {
+	From  time.Time
+	To    time.Time
+	Level string // minimum level
+	Text  string // case-insensitive, matched against the raw line
+	Limit int
+	// Cursor continues a previous search where its page ended
+	Cursor string
+}
+
+// Page is one page of events, newest first. Next is the cursor of the following
+// page, empty when there is none.
+type Page struct {
+	Events []Event `json:"events"`
+	Next   string  `json:"next,omitempty"`
+}
+
+// Search pages through the log files in dir, newest first. Events only carry
+// the connection fields of their own line. A cursor into the active file may
+// skip or repeat lines if the file was rotated in between.
+func Search(dir string, q Query) (*Page, error) {
+	startFile, startLine, err := parseCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log files: %w", err)
+	}
+	text := strings.ToLower(q.Text)
+
+	page := &Page{Events: []Event{}}
+	started := startFile == ""
+	for _, f := range files {
+		if !started {
+			// Rotated files are compressed after a while, the cursor names either
+			if strings.TrimSuffix(f.name, ".gz") != strings.TrimSuffix(startFile, ".gz") {
+				// Files newer than the cursor were already paged through
+				continue
+			}
+			started = true
+		} else {
+			startLine = -1
+		}
+		// A file rotated before the range starts, and every older one, only
+		// holds earlier lines
+		if !q.From.IsZero() && !f.rotated.IsZero() && f.rotated.Before(q.From) {
+			break
+		}
+
+		// One match beyond the page tells whether there is a next page
+		keep := 0
+		if q.Limit > 0 {
+			keep = q.Limit - len(page.Events) + 1
+		}
+		var matches []match
+		reachedFrom := false
+		err := scanLines(filepath.Join(dir, f.name), func(i int, line string) bool {
+			if startLine >= 0 && i >= startLine {
+				return false
+			}
+			if text != "" && !strings.Contains(strings.ToLower(line), text) {
+				return true
+			}
+			event := Parse(line)
+			if !q.To.IsZero() && event.Time.After(q.To) {
+				return true
+			}
+			if !q.From.IsZero() && event.Time.Before(q.From) {
+				// Lines are in order, older files hold nothing newer
+				reachedFrom = true
+				return true
+			}
+			if q.Level != "" && Severity(event.Level) < Severity(q.Level) {
+				return true
+			}
+			matches = append(matches, match{line: i, event: event})
+			if keep > 0 && len(matches) > keep {
+				matches = matches[1:]
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.name, err)
+		}
+
+		for i := len(matches) - 1; i >= 0; i-- {
+			if q.Limit > 0 && len(page.Events) == q.Limit {
+				page.Next = f.name + ":" + strconv.Itoa(matches[i].line+1)
+				return page, nil
+			}
+			page.Events = append(page.Events, matches[i].event)
+		}
+		if reachedFrom {
+			return page, nil
+		}
+	}
+	return page, nil
+}
+
+// match is an event found by Search and the index of its line in the file
+type match struct {
+	line  int
+	event Event
+}
+
+func parseCursor(cursor string) (string, int, error) {
+	if cursor == "" {
+		return "", -1, nil
+	}
+	name, line, ok := strings.Cut(cursor, ":")
+	n, err := strconv.Atoi(line)
+	if !ok || err != nil || n < 0 || name == "" || strings.ContainsAny(name, `/\`) {
+		return "", 0, ErrInvalidCursor
+	}
+	return name, n, nil
+}
+
+// maxLineLength bounds the part of a line kept in memory, longer lines are cut
+const maxLineLength = 64 << 10
+
+// scanLines calls fn with the index and text of each line of a log file in
+// order, decompressing rotated ones, until fn returns false. Only one line is
+// held in memory at a time.
+func scanLines(path string, fn func(i int, line string) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Compressed or pruned meanwhile
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	buf := bufio.NewReaderSize(reader, 32<<10)
+	var line []byte
+	for i := 0; ; i++ {
+		line = line[:0]
+		var err error
+		for {
+			var chunk []byte
+			chunk, err = buf.ReadSlice('\n')
+			if room := maxLineLength - len(line); room > 0 {
+				line = append(line, chunk[:min(len(chunk), room)]...)
+			}
+			if err != bufio.ErrBufferFull {
+				break
+			}
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if !fn(i, strings.TrimSuffix(string(line), "\n")) || err == io.EOF {
+			return nil
+		}
+	}
+}
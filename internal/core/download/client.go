@@ -0,0 +1,29 @@
+package download
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"singbox.arrow.web2/internal/storage"
+)
+
+// NewClient returns an HTTP client that goes through the download proxy when it is enabled.
+// A zero timeout means no timeout.
+func NewClient(timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+
+	proxyEnabled, _ := storage.GetSetting("download_proxy_enabled")
+	proxyURL, _ := storage.GetSetting("download_proxy_url")
+
+	if proxyEnabled == "true" && proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err == nil {
+			client.Transport = &http.Transport{
+				Proxy: http.ProxyURL(proxy),
+			}
+		}
+	}
+
+	return client
+}
@@ -0,0 +1,81 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"singbox.arrow.web2/internal/storage"
+)
+
+const configHashKey = "last_config_hash"
+
+type Result struct {
+	Hash    string `json:"hash"`
+	Changed bool   `json:"changed"`
+}
+
+// Apply regenerates the config and atomically writes it to configPath.
+// Changed is false when the new config is identical to the last one written.
+func Apply(configPath string) (*Result, error) {
+	data, err := Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	lastHash, _ := storage.GetRuntimeState(configHashKey)
+	if lastHash == hash {
+		if _, err := os.Stat(configPath); err == nil {
+			return &Result{Hash: hash, Changed: false}, nil
+		}
+	}
+
+	if err := writeFileAtomic(configPath, data); err != nil {
+		return nil, fmt.Errorf("failed to write config: %w", err)
+	}
+
+	if err := storage.SetRuntimeState(configHashKey, hash); err != nil {
+		return nil, fmt.Errorf("failed to save config hash: %w", err)
+	}
+
+	return &Result{Hash: hash, Changed: true}, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
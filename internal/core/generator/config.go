@@ -0,0 +1,466 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"singbox.arrow.web2/internal/core/ruleset"
+	"singbox.arrow.web2/internal/storage"
+)
+
+type Config struct {
+	Log          *LogConfig               `json:"log,omitempty"`
+	DNS          *DNSConfig               `json:"dns,omitempty"`
+	Inbounds     []map[string]interface{} `json:"inbounds"`
+	Outbounds    []map[string]interface{} `json:"outbounds"`
+	Route        *RouteConfig             `json:"route,omitempty"`
+	Experimental *ExperimentalConfig      `json:"experimental,omitempty"`
+}
+
+type LogConfig struct {
+	Level     string `json:"level,omitempty"`
+	Timestamp bool   `json:"timestamp"`
+}
+
+type DNSConfig struct {
+	Servers []map[string]interface{} `json:"servers"`
+	Final   string                   `json:"final,omitempty"`
+}
+
+type RouteConfig struct {
+	Rules                 []map[string]interface{} `json:"rules,omitempty"`
+	RuleSet               []map[string]interface{} `json:"rule_set,omitempty"`
+	Final                 string                   `json:"final,omitempty"`
+	DefaultDomainResolver string                   `json:"default_domain_resolver,omitempty"`
+}
+
+// ExperimentalConfig carries the Clash API, which traffic statistics and latency
+// tests of the running core go through
+type ExperimentalConfig struct {
+	ClashAPI *ClashAPIConfig `json:"clash_api,omitempty"`
+}
+
+type ClashAPIConfig struct {
+	ExternalController string `json:"external_controller"`
+	Secret             string `json:"secret,omitempty"`
+}
+
+const (
+	dnsServerTag = "dns-default"
+	directTag    = "direct"
+)
+
+// Build reads all enabled rows from the database and assembles a sing-box config
+func Build() (*Config, error) {
+	cfg := &Config{
+		Log: &LogConfig{
+			Level:     settingOr("log_level", "info"),
+			Timestamp: true,
+		},
+		DNS: &DNSConfig{
+			Servers: []map[string]interface{}{parseDNSServer(settingOr("dns_server", "local"))},
+			Final:   dnsServerTag,
+		},
+		Inbounds:  []map[string]interface{}{},
+		Outbounds: []map[string]interface{}{},
+		Route: &RouteConfig{
+			DefaultDomainResolver: dnsServerTag,
+		},
+	}
+
+	if err := buildInbounds(cfg); err != nil {
+		return nil, err
+	}
+	if err := buildOutbounds(cfg); err != nil {
+		return nil, err
+	}
+	if _, err := buildGroups(cfg); err != nil {
+		return nil, err
+	}
+	if err := buildRuleSets(cfg); err != nil {
+		return nil, err
+	}
+	if err := buildRules(cfg); err != nil {
+		return nil, err
+	}
+
+	cfg.Route.Final = FinalOutbound(outboundTagSet(cfg))
+	cfg.Experimental = buildExperimental()
+	return cfg, nil
+}
+
+// buildExperimental enables the Clash API on loopback, clash_api_port 0 leaves it off
+func buildExperimental() *ExperimentalConfig {
+	port, err := strconv.Atoi(settingOr("clash_api_port", "9090"))
+	if err != nil || port <= 0 || port > 65535 {
+		return nil
+	}
+	return &ExperimentalConfig{
+		ClashAPI: &ClashAPIConfig{
+			ExternalController: "127.0.0.1:" + strconv.Itoa(port),
+			Secret:             settingOr("clash_api_secret", ""),
+		},
+	}
+}
+
+// Generate builds the config and renders it as indented JSON
+func Generate() ([]byte, error) {
+	cfg, err := Build()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(cfg, "", "  ")
+}
+
+func buildInbounds(cfg *Config) error {
+	var inbounds []storage.Inbound
+	if err := storage.DB.Where("enabled = ?", true).Order("id").Find(&inbounds).Error; err != nil {
+		return fmt.Errorf("failed to load inbounds: %w", err)
+	}
+
+	tags := make(map[string]bool)
+	for _, in := range inbounds {
+		entry, err := decodeObject(in.Config)
+		if err != nil {
+			log.Printf("Generator: skipping inbound %q: %v", in.Name, err)
+			continue
+		}
+		if err := ValidateInbound(in.Type, entry); err != nil {
+			log.Printf("Generator: skipping inbound %q: %v", in.Name, err)
+			continue
+		}
+		entry["type"] = in.Type
+		entry["tag"] = uniqueTag(tags, in.Name)
+		cfg.Inbounds = append(cfg.Inbounds, entry)
+	}
+	return nil
+}
+
+func buildOutbounds(cfg *Config) error {
+	outbounds, err := enabledOutbounds()
+	if err != nil {
+		return err
+	}
+
+	tags := map[string]bool{directTag: true}
+	cfg.Outbounds = append(cfg.Outbounds, map[string]interface{}{
+		"type": "direct",
+		"tag":  directTag,
+	})
+
+	for _, out := range outbounds {
+		entry, err := OutboundEntry(&out)
+		if err != nil {
+			log.Printf("Generator: skipping outbound %q: %v", out.Name, err)
+			continue
+		}
+		entry["tag"] = uniqueTag(tags, entry["tag"].(string))
+		cfg.Outbounds = append(cfg.Outbounds, entry)
+	}
+	return nil
+}
+
+// enabledOutbounds loads the enabled outbounds, leaving out the nodes of disabled
+// subscriptions
+func enabledOutbounds() ([]storage.Outbound, error) {
+	var outbounds []storage.Outbound
+	subscriptions := storage.DB.Model(&storage.Subscription{}).Select("id").Where("enabled = ?", true)
+	if err := storage.DB.Where("enabled = ?", true).
+		Where("subscription_id IS NULL OR subscription_id IN (?)", subscriptions).
+		Order("id").Find(&outbounds).Error; err != nil {
+		return nil, fmt.Errorf("failed to load outbounds: %w", err)
+	}
+	return outbounds, nil
+}
+
+// OutboundEntry renders a single outbound row as a sing-box outbound object
+func OutboundEntry(out *storage.Outbound) (map[string]interface{}, error) {
+	entry, err := decodeObject(out.Config)
+	if err != nil {
+		return nil, err
+	}
+	tag := out.Tag
+	if tag == "" {
+		tag = out.Name
+	}
+	entry["type"] = out.Type
+	entry["tag"] = tag
+	if _, ok := entry["server"]; !ok && out.Server != "" {
+		entry["server"] = out.Server
+	}
+	if _, ok := entry["server_port"]; !ok && out.Port > 0 {
+		entry["server_port"] = out.Port
+	}
+	return entry, nil
+}
+
+func buildRuleSets(cfg *Config) error {
+	var rulesets []storage.Ruleset
+	if err := storage.DB.Where("enabled = ?", true).Order("id").Find(&rulesets).Error; err != nil {
+		return fmt.Errorf("failed to load rulesets: %w", err)
+	}
+
+	tags := make(map[string]bool)
+	for _, rs := range rulesets {
+		entry, err := ruleSetEntry(&rs)
+		if err != nil {
+			log.Printf("Generator: skipping ruleset %q: %v", rs.Name, err)
+			continue
+		}
+		if entry["type"] == "remote" {
+			log.Printf("Generator: remote ruleset %q is not downloaded yet, sing-box will fetch it", rs.Name)
+		}
+		entry["tag"] = uniqueTag(tags, rs.Name)
+		cfg.Route.RuleSet = append(cfg.Route.RuleSet, entry)
+	}
+	return nil
+}
+
+// ruleSetEntry renders a rule set row as a route.rule_set object without its tag
+func ruleSetEntry(rs *storage.Ruleset) (map[string]interface{}, error) {
+	entry := map[string]interface{}{"format": rs.Format}
+	switch rs.Type {
+	case "remote":
+		if rs.URL == "" {
+			return nil, fmt.Errorf("remote ruleset without URL")
+		}
+		// The panel keeps a downloaded copy so sing-box starts without network access
+		if path := ruleset.LocalFile(rs); path != "" {
+			entry["type"] = "local"
+			entry["path"] = path
+			break
+		}
+		entry["type"] = "remote"
+		entry["url"] = rs.URL
+		if rs.UpdateInterval > 0 {
+			entry["update_interval"] = fmt.Sprintf("%dh", rs.UpdateInterval)
+		}
+	case "local":
+		if rs.Path == "" {
+			return nil, fmt.Errorf("local ruleset without path")
+		}
+		entry["type"] = "local"
+		entry["path"] = rs.Path
+	default:
+		return nil, fmt.Errorf("unknown type %q", rs.Type)
+	}
+	return entry, nil
+}
+
+// RuleSetTags returns the tags of the rule sets that would be present in the
+// generated config
+func RuleSetTags() (map[string]bool, error) {
+	var rulesets []storage.Ruleset
+	if err := storage.DB.Where("enabled = ?", true).Order("id").Find(&rulesets).Error; err != nil {
+		return nil, fmt.Errorf("failed to load rulesets: %w", err)
+	}
+	tags := make(map[string]bool, len(rulesets))
+	for _, rs := range rulesets {
+		if _, err := ruleSetEntry(&rs); err == nil {
+			uniqueTag(tags, rs.Name)
+		}
+	}
+	return tags, nil
+}
+
+func ruleSetTagSet(cfg *Config) map[string]bool {
+	known := make(map[string]bool, len(cfg.Route.RuleSet))
+	for _, rs := range cfg.Route.RuleSet {
+		if tag, ok := rs["tag"].(string); ok {
+			known[tag] = true
+		}
+	}
+	return known
+}
+
+// ruleSetExceptions maps the name of a rule set in sets to the name of its allow
+// set, when that is in sets as well
+func ruleSetExceptions(sets map[string]bool) (map[string]string, error) {
+	var rulesets []storage.Ruleset
+	if err := storage.DB.Where("enabled = ?", true).Find(&rulesets).Error; err != nil {
+		return nil, fmt.Errorf("failed to load rulesets: %w", err)
+	}
+	exceptions := make(map[string]string)
+	for _, rs := range rulesets {
+		if sets[rs.Name] && rs.Exceptions != "" && rs.Exceptions != rs.Name && sets[rs.Exceptions] {
+			exceptions[rs.Name] = rs.Exceptions
+		}
+	}
+	return exceptions, nil
+}
+
+func buildRules(cfg *Config) error {
+	var rules []storage.Rule
+	if err := storage.DB.Where("enabled = ?", true).Order("priority, id").Find(&rules).Error; err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	sets := ruleSetTagSet(cfg)
+	exceptions, err := ruleSetExceptions(sets)
+	if err != nil {
+		return err
+	}
+
+	known := outboundTagSet(cfg)
+	for _, r := range rules {
+		entry, err := buildRule(r, sets, exceptions)
+		if err != nil {
+			log.Printf("Generator: skipping rule %d: %v", r.ID, err)
+			continue
+		}
+		if entry["action"] == "route" {
+			target, missing := ResolveOutbound(r.OutboundTag, known)
+			if missing {
+				log.Printf("Generator: rule %d targets missing outbound %q, using %s", r.ID, r.OutboundTag, target)
+			}
+			entry["outbound"] = target
+		}
+		cfg.Route.Rules = append(cfg.Route.Rules, entry)
+	}
+	return nil
+}
+
+// OutboundTags returns the tags of all outbounds and groups that would be present in the generated config
+func OutboundTags() (map[string]bool, error) {
+	cfg := &Config{}
+	if err := buildOutbounds(cfg); err != nil {
+		return nil, err
+	}
+	if _, err := buildGroups(cfg); err != nil {
+		return nil, err
+	}
+	return outboundTagSet(cfg), nil
+}
+
+// ResolveOutbound returns the outbound a rule should route to. When tag is not in
+// known the rule falls back to route_final, or direct if that is missing as well.
+func ResolveOutbound(tag string, known map[string]bool) (string, bool) {
+	if known[tag] {
+		return tag, false
+	}
+	fallback := settingOr("route_final", directTag)
+	if !known[fallback] {
+		fallback = directTag
+	}
+	return fallback, true
+}
+
+// FinalOutbound returns the route_final setting, or direct when it names a missing outbound
+func FinalOutbound(known map[string]bool) string {
+	final := settingOr("route_final", directTag)
+	if !known[final] {
+		log.Printf("Generator: route final %q does not exist, using %s", final, directTag)
+		return directTag
+	}
+	return final
+}
+
+func outboundTagSet(cfg *Config) map[string]bool {
+	known := make(map[string]bool, len(cfg.Outbounds))
+	for _, out := range cfg.Outbounds {
+		if tag, ok := out["tag"].(string); ok {
+			known[tag] = true
+		}
+	}
+	return known
+}
+
+// parseDNSServer converts a server address such as "https://1.1.1.1/dns-query",
+// "tls://8.8.8.8", "223.5.5.5" or "local" into a sing-box DNS server object
+func parseDNSServer(address string) map[string]interface{} {
+	server := map[string]interface{}{"tag": dnsServerTag}
+	if address == "" || address == "local" {
+		server["type"] = "local"
+		return server
+	}
+
+	if !strings.Contains(address, "://") {
+		address = "udp://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil || u.Hostname() == "" {
+		server["type"] = "local"
+		return server
+	}
+
+	server["type"] = u.Scheme
+	server["server"] = u.Hostname()
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		server["server_port"] = port
+	}
+	if (u.Scheme == "https" || u.Scheme == "h3") && u.Path != "" && u.Path != "/dns-query" {
+		server["path"] = u.Path
+	}
+	return server
+}
+
+func decodeObject(raw string) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	if strings.TrimSpace(raw) == "" {
+		return obj, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+		return nil, fmt.Errorf("invalid config JSON: %w", err)
+	}
+	if obj == nil {
+		obj = make(map[string]interface{})
+	}
+	return obj, nil
+}
+
+func uniqueTag(used map[string]bool, name string) string {
+	tag := name
+	for i := 2; used[tag]; i++ {
+		tag = fmt.Sprintf("%s-%d", name, i)
+	}
+	used[tag] = true
+	return tag
+}
+
+func splitValues(value string) []string {
+	var values []string
+	for _, v := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func prefixValues(prefix string, values []string) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		if strings.HasPrefix(v, prefix) {
+			result[i] = v
+		} else {
+			result[i] = prefix + v
+		}
+	}
+	return result
+}
+
+func parsePorts(values []string) ([]int, error) {
+	ports := make([]int, 0, len(values))
+	for _, v := range values {
+		p, err := strconv.Atoi(v)
+		if err != nil || p <= 0 || p > 65535 {
+			return nil, fmt.Errorf("invalid port %q", v)
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+func settingOr(key, fallback string) string {
+	value, err := storage.GetSetting(key)
+	if err != nil || value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,290 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"time"
+
+	"singbox.arrow.web2/internal/storage"
+)
+
+const (
+	defaultTestURL      = "https://www.gstatic.com/generate_204"
+	defaultTestInterval = "3m"
+	defaultTolerance    = 50
+	// sing-box has no fallback type. A urltest with a tolerance this high keeps the
+	// selected node until it fails, which is the behaviour users expect from fallback.
+	fallbackTolerance = 10000
+)
+
+var groupTypes = map[string]bool{
+	"selector": true,
+	"urltest":  true,
+	"fallback": true,
+}
+
+// ValidateGroup checks a group row before it is saved
+func ValidateGroup(g *storage.OutboundGroup) error {
+	var fields []FieldError
+	add := func(field, msg string) {
+		fields = append(fields, FieldError{Field: field, Message: msg})
+	}
+
+	if !groupTypes[g.Type] {
+		add("type", fmt.Sprintf("unsupported group type %q", g.Type))
+	}
+
+	outboundIDs, err := DecodeIDs(g.OutboundIDs)
+	if err != nil {
+		add("outbound_ids", err.Error())
+	}
+	groupIDs, err := DecodeIDs(g.GroupIDs)
+	if err != nil {
+		add("group_ids", err.Error())
+	}
+	for _, id := range groupIDs {
+		if g.ID != 0 && id == g.ID {
+			add("group_ids", "a group cannot contain itself")
+		}
+	}
+	subscriptionIDs, err := DecodeIDs(g.SubscriptionIDs)
+	if err != nil {
+		add("subscription_ids", err.Error())
+	}
+	if g.NamePattern != "" {
+		if _, err := regexp.Compile(g.NamePattern); err != nil {
+			add("name_pattern", err.Error())
+		}
+	}
+	if len(outboundIDs) == 0 && len(groupIDs) == 0 && len(subscriptionIDs) == 0 && g.NamePattern == "" {
+		add("members", "choose members explicitly, by subscription or by name pattern")
+	}
+
+	if g.Type == "selector" {
+		if g.URL != "" || g.Interval != "" || g.Tolerance != 0 {
+			add("type", "url, interval and tolerance only apply to urltest and fallback")
+		}
+	} else {
+		if g.Default != "" {
+			add("default", "only selector groups have a default member")
+		}
+		if g.URL != "" {
+			if u, err := url.Parse(g.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("url", "must be an http(s) URL")
+			}
+		}
+		if g.Interval != "" {
+			if d, err := time.ParseDuration(g.Interval); err != nil || d < 10*time.Second {
+				add("interval", "must be a duration of at least 10s, e.g. 3m")
+			}
+		}
+		if g.Tolerance < 0 {
+			add("tolerance", "must not be negative")
+		}
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return checkGroupCycle(g, groupIDs)
+}
+
+// checkGroupCycle rejects nesting that would make g reachable from its own members
+func checkGroupCycle(g *storage.OutboundGroup, groupIDs []uint) error {
+	if g.ID == 0 || len(groupIDs) == 0 {
+		return nil
+	}
+
+	var groups []storage.OutboundGroup
+	if err := storage.DB.Find(&groups).Error; err != nil {
+		return fmt.Errorf("failed to load groups: %w", err)
+	}
+	children := make(map[uint][]uint, len(groups))
+	for _, other := range groups {
+		children[other.ID], _ = DecodeIDs(other.GroupIDs)
+	}
+	children[g.ID] = groupIDs
+
+	visited := make(map[uint]bool)
+	var reaches func(id uint) bool
+	reaches = func(id uint) bool {
+		if id == g.ID {
+			return true
+		}
+		if visited[id] {
+			return false
+		}
+		visited[id] = true
+		for _, child := range children[id] {
+			if reaches(child) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, child := range groupIDs {
+		if reaches(child) {
+			return &ValidationError{Fields: []FieldError{{Field: "group_ids", Message: "nested groups form a cycle"}}}
+		}
+	}
+	return nil
+}
+
+// DecodeIDs parses a JSON id list as stored on OutboundGroup, empty means none
+func DecodeIDs(raw string) ([]uint, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var ids []uint
+	if err := json.Unmarshal([]byte(raw), &ids); err != nil {
+		return nil, fmt.Errorf("must be a list of ids")
+	}
+	return ids, nil
+}
+
+// GroupMembers returns the member tags each enabled group resolves to in the
+// generated config. Groups that end up empty are absent.
+func GroupMembers() (map[uint][]string, error) {
+	cfg := &Config{}
+	if err := buildOutbounds(cfg); err != nil {
+		return nil, err
+	}
+	return buildGroups(cfg)
+}
+
+// buildGroups appends the enabled groups to cfg.Outbounds after their members,
+// so nested groups come after the groups they contain
+func buildGroups(cfg *Config) (map[uint][]string, error) {
+	var groups []storage.OutboundGroup
+	if err := storage.DB.Where("enabled = ?", true).Order("id").Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed to load groups: %w", err)
+	}
+	outbounds, err := enabledOutbounds()
+	if err != nil {
+		return nil, err
+	}
+
+	known := outboundTagSet(cfg)
+	outboundTags := make(map[uint]string, len(outbounds))
+	for _, out := range outbounds {
+		tag := out.Tag
+		if tag == "" {
+			tag = out.Name
+		}
+		if known[tag] {
+			outboundTags[out.ID] = tag
+		}
+	}
+
+	byID := make(map[uint]*storage.OutboundGroup, len(groups))
+	for i := range groups {
+		byID[groups[i].ID] = &groups[i]
+	}
+
+	members := make(map[uint][]string)
+	groupTags := make(map[uint]string)
+	state := make(map[uint]int) // 1 resolving, 2 done
+	var resolve func(g *storage.OutboundGroup)
+	resolve = func(g *storage.OutboundGroup) {
+		if state[g.ID] != 0 {
+			return
+		}
+		state[g.ID] = 1
+
+		var tags []string
+		seen := make(map[string]bool)
+		add := func(tag string) {
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+
+		groupIDs, _ := DecodeIDs(g.GroupIDs)
+		for _, id := range groupIDs {
+			if child, ok := byID[id]; ok {
+				resolve(child)
+				add(groupTags[id])
+			}
+		}
+		outboundIDs, _ := DecodeIDs(g.OutboundIDs)
+		for _, id := range outboundIDs {
+			add(outboundTags[id])
+		}
+		subscriptionIDs, _ := DecodeIDs(g.SubscriptionIDs)
+		subscriptions := make(map[uint]bool, len(subscriptionIDs))
+		for _, id := range subscriptionIDs {
+			subscriptions[id] = true
+		}
+		var pattern *regexp.Regexp
+		if g.NamePattern != "" {
+			pattern, _ = regexp.Compile(g.NamePattern)
+		}
+		for _, out := range outbounds {
+			if out.SubscriptionID != nil && subscriptions[*out.SubscriptionID] {
+				add(outboundTags[out.ID])
+			} else if pattern != nil && pattern.MatchString(out.Name) {
+				add(outboundTags[out.ID])
+			}
+		}
+
+		state[g.ID] = 2
+		if len(tags) == 0 {
+			log.Printf("Generator: skipping group %q without members", g.Name)
+			return
+		}
+
+		tag := g.Tag
+		if tag == "" {
+			tag = g.Name
+		}
+		tag = uniqueTag(known, tag)
+		groupTags[g.ID] = tag
+		members[g.ID] = tags
+		cfg.Outbounds = append(cfg.Outbounds, groupEntry(g, tag, tags))
+	}
+
+	for i := range groups {
+		resolve(&groups[i])
+	}
+	return members, nil
+}
+
+func groupEntry(g *storage.OutboundGroup, tag string, members []string) map[string]interface{} {
+	entry := map[string]interface{}{
+		"tag":       tag,
+		"outbounds": members,
+	}
+
+	if g.Type == "selector" {
+		entry["type"] = "selector"
+		for _, m := range members {
+			if m == g.Default {
+				entry["default"] = g.Default
+			}
+		}
+		return entry
+	}
+
+	entry["type"] = "urltest"
+	entry["url"] = g.URL
+	if g.URL == "" {
+		entry["url"] = defaultTestURL
+	}
+	entry["interval"] = g.Interval
+	if g.Interval == "" {
+		entry["interval"] = defaultTestInterval
+	}
+	tolerance := g.Tolerance
+	if tolerance == 0 {
+		tolerance = defaultTolerance
+		if g.Type == "fallback" {
+			tolerance = fallbackTolerance
+		}
+	}
+	entry["tolerance"] = tolerance
+	return entry
+}
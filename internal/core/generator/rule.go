@@ -0,0 +1,465 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"singbox.arrow.web2/internal/storage"
+)
+
+// Condition is a single match field of a rule, Value may hold several values
+// separated by commas or newlines
+type Condition struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// SubRule is one branch of a logical rule, its conditions are combined like a default rule
+type SubRule struct {
+	Conditions []Condition `json:"conditions"`
+	Invert     bool        `json:"invert"`
+}
+
+const (
+	ActionRoute     = "route"
+	ActionReject    = "reject"
+	ActionHijackDNS = "hijack-dns"
+	ActionSniff     = "sniff"
+
+	RuleTypeLogical = "logical"
+)
+
+var ruleActions = map[string]bool{
+	ActionRoute:     true,
+	ActionReject:    true,
+	ActionHijackDNS: true,
+	ActionSniff:     true,
+}
+
+var portRangePattern = regexp.MustCompile(`^\d*:\d*$`)
+
+// RuleAction returns the sing-box action of a rule. Rules saved before actions
+// existed route to "block" or "reject" to drop traffic.
+func RuleAction(r *storage.Rule) string {
+	if r.Action == "" || r.Action == ActionRoute {
+		if r.OutboundTag == "block" || r.OutboundTag == "reject" {
+			return ActionReject
+		}
+		return ActionRoute
+	}
+	return r.Action
+}
+
+// DecodeConditions parses the extra conditions stored on a rule
+func DecodeConditions(raw string) ([]Condition, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var conds []Condition
+	if err := json.Unmarshal([]byte(raw), &conds); err != nil {
+		return nil, fmt.Errorf("invalid conditions JSON: %w", err)
+	}
+	return conds, nil
+}
+
+// DecodeSubRules parses the branches of a logical rule
+func DecodeSubRules(raw string) ([]SubRule, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var subs []SubRule
+	if err := json.Unmarshal([]byte(raw), &subs); err != nil {
+		return nil, fmt.Errorf("invalid sub rules JSON: %w", err)
+	}
+	return subs, nil
+}
+
+// ValidateRule checks that a rule can be rendered into a sing-box route rule
+func ValidateRule(r *storage.Rule) error {
+	var fields []FieldError
+	action := RuleAction(r)
+	if !ruleActions[action] {
+		fields = append(fields, FieldError{Field: "action", Message: fmt.Sprintf("unsupported action %q", action)})
+	}
+	if action == ActionRoute && r.OutboundTag == "" {
+		fields = append(fields, FieldError{Field: "outbound_tag", Message: "required for route rules"})
+	}
+
+	if _, err := matchEntry(r); err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			fields = append(fields, verr.Fields...)
+		} else {
+			fields = append(fields, FieldError{Field: "value", Message: err.Error()})
+		}
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// RenderRule returns the route rule the generator emits for r, before the
+// outbound is checked against the existing tags
+func RenderRule(r storage.Rule) (map[string]interface{}, error) {
+	sets, err := RuleSetTags()
+	if err != nil {
+		return nil, err
+	}
+	exceptions, err := ruleSetExceptions(sets)
+	if err != nil {
+		return nil, err
+	}
+	return buildRule(r, sets, exceptions)
+}
+
+// buildRule renders r. sets holds the rule set tags of the config, sing-box
+// refuses to start when a rule refers to any other. exceptions maps a rule set
+// tag to the tag of its allow set.
+func buildRule(r storage.Rule, sets map[string]bool, exceptions map[string]string) (map[string]interface{}, error) {
+	entry, err := matchEntry(&r)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range ruleSetRefs(entry) {
+		if !sets[tag] {
+			return nil, fmt.Errorf("rule set %q is missing, disabled or cannot be loaded", tag)
+		}
+	}
+	entry = applyExceptions(entry, exceptions)
+
+	action := RuleAction(&r)
+	if !ruleActions[action] {
+		return nil, fmt.Errorf("unsupported action %q", action)
+	}
+	entry["action"] = action
+	if action == ActionRoute {
+		entry["outbound"] = r.OutboundTag
+	}
+	return entry, nil
+}
+
+// matchEntry renders the match part of a rule: a default rule built from Type/Value
+// and the extra conditions, or a logical rule combining its sub rules
+func matchEntry(r *storage.Rule) (map[string]interface{}, error) {
+	var entry map[string]interface{}
+
+	if r.Type == RuleTypeLogical {
+		if r.Mode != "and" && r.Mode != "or" {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "mode", Message: `must be "and" or "or"`}}}
+		}
+		subs, err := DecodeSubRules(r.SubRules)
+		if err != nil {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "rules", Message: err.Error()}}}
+		}
+		if len(subs) == 0 {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "rules", Message: "a logical rule needs at least one sub rule"}}}
+		}
+
+		rules := make([]map[string]interface{}, 0, len(subs))
+		for i, sub := range subs {
+			prefix := fmt.Sprintf("rules[%d].conditions", i)
+			if len(sub.Conditions) == 0 {
+				return nil, &ValidationError{Fields: []FieldError{{Field: prefix, Message: "at least one condition is required"}}}
+			}
+			subEntry, err := conditionsEntry(sub.Conditions, func(j int) string {
+				return fmt.Sprintf("%s[%d]", prefix, j)
+			})
+			if err != nil {
+				return nil, err
+			}
+			if sub.Invert {
+				subEntry["invert"] = true
+			}
+			rules = append(rules, subEntry)
+		}
+		entry = map[string]interface{}{
+			"type":  RuleTypeLogical,
+			"mode":  r.Mode,
+			"rules": rules,
+		}
+	} else {
+		extra, err := DecodeConditions(r.Conditions)
+		if err != nil {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "conditions", Message: err.Error()}}}
+		}
+		// Type/Value is the primary condition, the stored conditions are ANDed with it
+		conds := append([]Condition{{Type: r.Type, Value: r.Value}}, extra...)
+		entry, err = conditionsEntry(conds, func(i int) string {
+			if i == 0 {
+				return "value"
+			}
+			return fmt.Sprintf("conditions[%d]", i-1)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Invert {
+		entry["invert"] = true
+	}
+	return entry, nil
+}
+
+// applyExceptions keeps the allow sets of the rule sets a rule refers to from
+// matching it, so an exception wins over the block list it belongs to. Only the
+// default rules naming a rule set get the allow sets as an inverted AND branch:
+// in a logical rule the other branches still match on their own, and an inverted
+// rule never matches its rule set in the first place.
+func applyExceptions(entry map[string]interface{}, exceptions map[string]string) map[string]interface{} {
+	if len(exceptions) == 0 || entry["invert"] == true {
+		return entry
+	}
+	if entry["type"] == RuleTypeLogical {
+		subs, _ := entry["rules"].([]map[string]interface{})
+		for i, sub := range subs {
+			subs[i] = applyExceptions(sub, exceptions)
+		}
+		return entry
+	}
+
+	var allow []string
+	seen := make(map[string]bool)
+	sets, _ := entry["rule_set"].([]string)
+	for _, set := range sets {
+		if tag := exceptions[set]; tag != "" && !seen[tag] {
+			seen[tag] = true
+			allow = append(allow, tag)
+		}
+	}
+	if len(allow) == 0 {
+		return entry
+	}
+	return map[string]interface{}{
+		"type": RuleTypeLogical,
+		"mode": "and",
+		"rules": []map[string]interface{}{
+			{"rule_set": allow, "invert": true},
+			entry,
+		},
+	}
+}
+
+// ruleSetRefs returns the rule set tags a rule and its sub rules refer to
+func ruleSetRefs(entry map[string]interface{}) []string {
+	sets, _ := entry["rule_set"].([]string)
+	refs := append([]string(nil), sets...)
+	subs, _ := entry["rules"].([]map[string]interface{})
+	for _, sub := range subs {
+		refs = append(refs, ruleSetRefs(sub)...)
+	}
+	return refs
+}
+
+// conditionsEntry combines conditions into one default rule, field names each
+// condition in error messages
+func conditionsEntry(conds []Condition, field func(i int) string) (map[string]interface{}, error) {
+	entry := make(map[string]interface{})
+	var fields []FieldError
+	for i, cond := range conds {
+		if err := applyCondition(entry, cond); err != nil {
+			fields = append(fields, FieldError{Field: field(i), Message: err.Error()})
+		}
+	}
+	if len(fields) > 0 {
+		return nil, &ValidationError{Fields: fields}
+	}
+	return entry, nil
+}
+
+// applyCondition adds one condition to a default rule, values of a field that
+// appears more than once are merged
+func applyCondition(entry map[string]interface{}, cond Condition) error {
+	values := splitValues(cond.Value)
+	if len(values) == 0 {
+		return fmt.Errorf("empty value")
+	}
+
+	switch cond.Type {
+	case "domain", "domain_suffix", "domain_keyword", "ip_cidr", "source_ip_cidr", "inbound", "protocol":
+		mergeField(entry, cond.Type, values)
+	case "domain_regex":
+		for _, v := range values {
+			if _, err := regexp.Compile(v); err != nil {
+				return fmt.Errorf("invalid regex %q", v)
+			}
+		}
+		mergeField(entry, "domain_regex", values)
+	case "ip":
+		mergeField(entry, "ip_cidr", values)
+	case "port", "source_port":
+		ports, err := parsePorts(values)
+		if err != nil {
+			return err
+		}
+		mergeField(entry, cond.Type, ports)
+	case "port_range", "source_port_range":
+		for _, v := range values {
+			if !portRangePattern.MatchString(v) || v == ":" {
+				return fmt.Errorf("invalid port range %q, expected start:end", v)
+			}
+		}
+		mergeField(entry, cond.Type, values)
+	case "network":
+		for _, v := range values {
+			if v != "tcp" && v != "udp" {
+				return fmt.Errorf("network must be tcp or udp")
+			}
+		}
+		mergeField(entry, "network", values)
+	case "process", "process_name":
+		mergeField(entry, "process_name", values)
+	case "ruleset", "rule_set":
+		mergeField(entry, "rule_set", values)
+	case "geosite":
+		mergeField(entry, "rule_set", prefixValues("geosite-", values))
+	case "geoip":
+		var sets []string
+		for _, v := range values {
+			if v == "private" {
+				entry["ip_is_private"] = true
+			} else {
+				sets = append(sets, v)
+			}
+		}
+		if len(sets) > 0 {
+			mergeField(entry, "rule_set", prefixValues("geoip-", sets))
+		}
+	case RuleTypeLogical:
+		return fmt.Errorf("logical rules cannot be used as a condition")
+	default:
+		return fmt.Errorf("unsupported rule type %q", cond.Type)
+	}
+	return nil
+}
+
+func mergeField(entry map[string]interface{}, key string, value interface{}) {
+	switch v := value.(type) {
+	case []string:
+		if existing, ok := entry[key].([]string); ok {
+			v = append(existing, v...)
+		}
+		entry[key] = v
+	case []int:
+		if existing, ok := entry[key].([]int); ok {
+			v = append(existing, v...)
+		}
+		entry[key] = v
+	default:
+		entry[key] = value
+	}
+}
+
+// RenameRuleSet points the conditions of r that refer to the rule set oldName at
+// newName and reports whether r changed. geosite and geoip conditions name their
+// set without its prefix, newName has to keep the prefix for them.
+func RenameRuleSet(r *storage.Rule, oldName, newName string) (bool, error) {
+	rename := func(cond *Condition) (bool, error) {
+		var prefix string
+		switch cond.Type {
+		case "ruleset", "rule_set":
+		case "geosite":
+			prefix = "geosite-"
+		case "geoip":
+			prefix = "geoip-"
+		default:
+			return false, nil
+		}
+		changed := false
+		var err error
+		cond.Value = replaceValues(cond.Value, func(v string) string {
+			if cond.Type == "geoip" && v == "private" {
+				return v
+			}
+			if prefixValues(prefix, []string{v})[0] != oldName {
+				return v
+			}
+			renamed := newName
+			if prefix != "" && !strings.HasPrefix(v, prefix) {
+				if !strings.HasPrefix(newName, prefix) {
+					err = fmt.Errorf("rule %d refers to it as %s %q, the new name has to start with %q", r.ID, cond.Type, v, prefix)
+					return v
+				}
+				if short := strings.TrimPrefix(newName, prefix); short != "private" {
+					renamed = short
+				}
+			}
+			changed = true
+			return renamed
+		})
+		return changed, err
+	}
+
+	primary := Condition{Type: r.Type, Value: r.Value}
+	changed, err := rename(&primary)
+	if err != nil {
+		return false, err
+	}
+	r.Value = primary.Value
+
+	conds, err := DecodeConditions(r.Conditions)
+	if err != nil {
+		return false, err
+	}
+	condsChanged := false
+	for i := range conds {
+		ok, err := rename(&conds[i])
+		if err != nil {
+			return false, err
+		}
+		condsChanged = condsChanged || ok
+	}
+	if condsChanged {
+		data, _ := json.Marshal(conds)
+		r.Conditions = string(data)
+	}
+
+	subs, err := DecodeSubRules(r.SubRules)
+	if err != nil {
+		return false, err
+	}
+	subsChanged := false
+	for i := range subs {
+		for j := range subs[i].Conditions {
+			ok, err := rename(&subs[i].Conditions[j])
+			if err != nil {
+				return false, err
+			}
+			subsChanged = subsChanged || ok
+		}
+	}
+	if subsChanged {
+		data, _ := json.Marshal(subs)
+		r.SubRules = string(data)
+	}
+	return changed || condsChanged || subsChanged, nil
+}
+
+// replaceValues applies fn to each value of a condition, keeping the separators
+// and spacing around the values
+func replaceValues(value string, fn func(v string) string) string {
+	var b strings.Builder
+	start := 0
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) && value[i] != ',' && value[i] != '\n' {
+			continue
+		}
+		part := value[start:i]
+		if v := strings.TrimSpace(part); v != "" {
+			if replaced := fn(v); replaced != v {
+				part = strings.Replace(part, v, replaced, 1)
+			}
+		}
+		b.WriteString(part)
+		if i < len(value) {
+			b.WriteByte(value[i])
+		}
+		start = i + 1
+	}
+	return b.String()
+}
@@ -0,0 +1,282 @@
+package generator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError carries every field-level problem found in a config
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var shadowsocksMethods = map[string]int{
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+	"aes-128-gcm":                   0,
+	"aes-192-gcm":                   0,
+	"aes-256-gcm":                   0,
+	"chacha20-ietf-poly1305":        0,
+	"xchacha20-ietf-poly1305":       0,
+	"none":                          0,
+}
+
+var inboundTypes = map[string]bool{
+	"mixed":       true,
+	"socks":       true,
+	"http":        true,
+	"shadowsocks": true,
+	"vmess":       true,
+	"vless":       true,
+	"trojan":      true,
+	"hysteria2":   true,
+	"tuic":        true,
+	"tun":         true,
+	"tproxy":      true,
+	"redirect":    true,
+	"direct":      true,
+}
+
+type validator struct {
+	cfg    map[string]interface{}
+	errors []FieldError
+}
+
+func (v *validator) fail(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateInbound checks an inbound config against the rules of its protocol.
+// It returns nil when the config is valid.
+func ValidateInbound(inboundType string, cfg map[string]interface{}) error {
+	v := &validator{cfg: cfg}
+
+	if !inboundTypes[inboundType] {
+		v.fail("type", "unsupported inbound type %q", inboundType)
+		return &ValidationError{Fields: v.errors}
+	}
+
+	if inboundType != "tun" {
+		v.requirePort("listen_port")
+		v.optionalListen()
+	}
+
+	switch inboundType {
+	case "mixed", "socks", "http":
+		v.validateUsers(false, func(prefix string, user map[string]interface{}) {
+			if str(user, "username") == "" {
+				v.fail(prefix+".username", "username is required")
+			}
+			if str(user, "password") == "" {
+				v.fail(prefix+".password", "password is required")
+			}
+		})
+	case "shadowsocks":
+		v.validateShadowsocks()
+	case "vmess":
+		v.validateUsers(true, func(prefix string, user map[string]interface{}) {
+			v.requireUUID(prefix+".uuid", str(user, "uuid"))
+			if alterID, ok := user["alterId"]; ok {
+				if n, ok := toInt(alterID); !ok || n < 0 {
+					v.fail(prefix+".alterId", "must be a non-negative integer")
+				}
+			}
+		})
+	case "vless":
+		v.validateUsers(true, func(prefix string, user map[string]interface{}) {
+			v.requireUUID(prefix+".uuid", str(user, "uuid"))
+			if flow := str(user, "flow"); flow != "" && flow != "xtls-rprx-vision" {
+				v.fail(prefix+".flow", "unsupported flow %q", flow)
+			}
+		})
+	case "trojan":
+		v.validateUsers(true, func(prefix string, user map[string]interface{}) {
+			if str(user, "password") == "" {
+				v.fail(prefix+".password", "password is required")
+			}
+		})
+	case "hysteria2":
+		v.validateUsers(true, func(prefix string, user map[string]interface{}) {
+			if str(user, "password") == "" {
+				v.fail(prefix+".password", "password is required")
+			}
+		})
+		v.requireTLS()
+	case "tuic":
+		v.validateUsers(true, func(prefix string, user map[string]interface{}) {
+			v.requireUUID(prefix+".uuid", str(user, "uuid"))
+			if str(user, "password") == "" {
+				v.fail(prefix+".password", "password is required")
+			}
+		})
+		v.requireTLS()
+	case "tun":
+		addresses, ok := cfg["address"].([]interface{})
+		if !ok || len(addresses) == 0 {
+			v.fail("address", "at least one address is required")
+		}
+		for i, a := range addresses {
+			s, _ := a.(string)
+			if _, _, err := net.ParseCIDR(s); err != nil {
+				v.fail(fmt.Sprintf("address[%d]", i), "invalid CIDR %q", s)
+			}
+		}
+	}
+
+	if len(v.errors) > 0 {
+		return &ValidationError{Fields: v.errors}
+	}
+	return nil
+}
+
+func (v *validator) requirePort(field string) {
+	raw, ok := v.cfg[field]
+	if !ok {
+		v.fail(field, "port is required")
+		return
+	}
+	port, ok := toInt(raw)
+	if !ok || port < 1 || port > 65535 {
+		v.fail(field, "must be between 1 and 65535")
+	}
+}
+
+func (v *validator) optionalListen() {
+	raw, ok := v.cfg["listen"]
+	if !ok {
+		return
+	}
+	listen, _ := raw.(string)
+	if listen != "::" && net.ParseIP(listen) == nil {
+		v.fail("listen", "invalid listen address %q", listen)
+	}
+}
+
+func (v *validator) validateShadowsocks() {
+	method := str(v.cfg, "method")
+	keyLen, ok := shadowsocksMethods[method]
+	if !ok {
+		v.fail("method", "unsupported method %q", method)
+		return
+	}
+	if method == "none" {
+		return
+	}
+
+	checkPassword := func(field, password string) {
+		if password == "" {
+			v.fail(field, "password is required")
+			return
+		}
+		if keyLen > 0 {
+			key, err := base64.StdEncoding.DecodeString(password)
+			if err != nil || len(key) != keyLen {
+				v.fail(field, "must be a base64 encoded %d-byte key for %s", keyLen, method)
+			}
+		}
+	}
+
+	checkPassword("password", str(v.cfg, "password"))
+
+	users, _ := v.cfg["users"].([]interface{})
+	for i, u := range users {
+		user, _ := u.(map[string]interface{})
+		checkPassword(fmt.Sprintf("users[%d].password", i), str(user, "password"))
+	}
+}
+
+// validateUsers runs check on every entry of the users array
+func (v *validator) validateUsers(required bool, check func(prefix string, user map[string]interface{})) {
+	raw, ok := v.cfg["users"]
+	if !ok {
+		if required {
+			v.fail("users", "at least one user is required")
+		}
+		return
+	}
+
+	users, ok := raw.([]interface{})
+	if !ok {
+		v.fail("users", "must be an array")
+		return
+	}
+	if required && len(users) == 0 {
+		v.fail("users", "at least one user is required")
+		return
+	}
+
+	for i, u := range users {
+		prefix := fmt.Sprintf("users[%d]", i)
+		user, ok := u.(map[string]interface{})
+		if !ok {
+			v.fail(prefix, "must be an object")
+			continue
+		}
+		check(prefix, user)
+	}
+}
+
+func (v *validator) requireUUID(field, value string) {
+	if !uuidPattern.MatchString(value) {
+		v.fail(field, "invalid UUID %q", value)
+	}
+}
+
+func (v *validator) requireTLS() {
+	tls, ok := v.cfg["tls"].(map[string]interface{})
+	if !ok || tls["enabled"] != true {
+		v.fail("tls.enabled", "TLS is required for this protocol")
+		return
+	}
+
+	if _, ok := tls["acme"]; ok {
+		return
+	}
+	if _, ok := tls["reality"]; ok {
+		return
+	}
+	hasCert := str(tls, "certificate_path") != "" || tls["certificate"] != nil
+	hasKey := str(tls, "key_path") != "" || tls["key"] != nil
+	if !hasCert {
+		v.fail("tls.certificate_path", "certificate is required")
+	}
+	if !hasKey {
+		v.fail("tls.key_path", "key is required")
+	}
+}
+
+func str(obj map[string]interface{}, key string) string {
+	s, _ := obj[key].(string)
+	return s
+}
+
+func toInt(v interface{}) (int, bool) {
+	f, ok := v.(float64)
+	if !ok || f != float64(int(f)) {
+		return 0, false
+	}
+	return int(f), true
+}
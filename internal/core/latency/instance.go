@@ -0,0 +1,278 @@
+package latency
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"singbox.arrow.web2/internal/core/generator"
+	"singbox.arrow.web2/internal/core/singbox"
+	"singbox.arrow.web2/internal/storage"
+)
+
+const instanceStartTimeout = 10 * time.Second
+
+// instance is a temporary sing-box process with one mixed inbound on loopback per node
+type instance struct {
+	cmd    *exec.Cmd
+	dir    string
+	ports  []int
+	done   chan struct{}
+	stderr *lockedBuffer
+}
+
+// testThroughInstance runs URL tests for outbounds[idx] through a temporary sing-box.
+// If the combined config fails to start, each node gets its own instance so one
+// broken node does not fail the whole batch.
+func testThroughInstance(manager *singbox.Manager, outbounds []storage.Outbound, idx []int, results []Result, opts Options) {
+	binary := manager.GetBinaryPath()
+	if _, err := os.Stat(binary); err != nil {
+		for _, i := range idx {
+			results[i].Via = "sing-box"
+			results[i].Error = "sing-box binary not found, please download first"
+		}
+		return
+	}
+
+	var nodes []int
+	var entries []map[string]interface{}
+	for _, i := range idx {
+		results[i].Via = "sing-box"
+		entry, err := generator.OutboundEntry(&outbounds[i])
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		// The node is tested on its own, chained outbounds are not part of the config
+		delete(entry, "detour")
+		nodes = append(nodes, i)
+		entries = append(entries, entry)
+	}
+	if len(nodes) == 0 {
+		return
+	}
+
+	inst, err := startInstance(binary, manager.GetDataDir(), entries)
+	if err != nil {
+		if len(nodes) == 1 {
+			results[nodes[0]].Error = err.Error()
+			return
+		}
+		run(len(nodes), opts.Workers, func(n int) {
+			i := nodes[n]
+			single, err := startInstance(binary, manager.GetDataDir(), entries[n:n+1])
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			defer single.close()
+			d, err := httpDelay(single.ports[0], opts.URL, opts.Timeout)
+			finish(&results[i], d, err)
+		})
+		return
+	}
+	defer inst.close()
+
+	run(len(nodes), opts.Workers, func(n int) {
+		d, err := httpDelay(inst.ports[n], opts.URL, opts.Timeout)
+		finish(&results[nodes[n]], d, err)
+	})
+}
+
+func startInstance(binary, dataDir string, entries []map[string]interface{}) (*instance, error) {
+	ports, err := freePorts(len(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	inbounds := make([]map[string]interface{}, len(entries))
+	outbounds := make([]map[string]interface{}, 0, len(entries)+1)
+	rules := make([]map[string]interface{}, len(entries))
+	for i, entry := range entries {
+		inTag, outTag := fmt.Sprintf("in-%d", i), fmt.Sprintf("node-%d", i)
+		inbounds[i] = map[string]interface{}{
+			"type":        "mixed",
+			"tag":         inTag,
+			"listen":      "127.0.0.1",
+			"listen_port": ports[i],
+		}
+		node := make(map[string]interface{}, len(entry))
+		for k, v := range entry {
+			node[k] = v
+		}
+		node["tag"] = outTag
+		outbounds = append(outbounds, node)
+		rules[i] = map[string]interface{}{
+			"inbound":  []string{inTag},
+			"action":   "route",
+			"outbound": outTag,
+		}
+	}
+	outbounds = append(outbounds, map[string]interface{}{"type": "direct", "tag": "direct"})
+
+	config := map[string]interface{}{
+		"log": map[string]interface{}{"level": "error"},
+		"dns": map[string]interface{}{
+			"servers": []map[string]interface{}{{"type": "local", "tag": "local"}},
+		},
+		"inbounds":  inbounds,
+		"outbounds": outbounds,
+		"route": map[string]interface{}{
+			"rules":                   rules,
+			"final":                   "direct",
+			"default_domain_resolver": "local",
+		},
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(dataDir, "latency-")
+	if err != nil {
+		return nil, err
+	}
+	configPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	inst := &instance{
+		cmd:    exec.Command(binary, "run", "-c", configPath),
+		dir:    dir,
+		ports:  ports,
+		done:   make(chan struct{}),
+		stderr: &lockedBuffer{},
+	}
+	inst.cmd.Dir = dir
+	inst.cmd.Stdout = inst.stderr
+	inst.cmd.Stderr = inst.stderr
+	if err := inst.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start sing-box: %w", err)
+	}
+	go func() {
+		inst.cmd.Wait()
+		close(inst.done)
+	}()
+
+	if err := inst.waitReady(); err != nil {
+		inst.close()
+		return nil, err
+	}
+	return inst, nil
+}
+
+// waitReady waits until every inbound accepts connections or the process exits
+func (inst *instance) waitReady() error {
+	deadline := time.Now().Add(instanceStartTimeout)
+	for _, port := range inst.ports {
+		for {
+			select {
+			case <-inst.done:
+				msg := strings.TrimSpace(inst.stderr.String())
+				if msg == "" {
+					msg = "process exited"
+				}
+				return fmt.Errorf("sing-box failed to start: %s", msg)
+			default:
+			}
+
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), 200*time.Millisecond)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("sing-box did not start listening within %s", instanceStartTimeout)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+func (inst *instance) close() {
+	if inst.cmd.Process != nil {
+		inst.cmd.Process.Kill()
+	}
+	<-inst.done
+	os.RemoveAll(inst.dir)
+}
+
+// httpDelay requests testURL through the mixed inbound on port and returns the time to the response headers
+func httpDelay(port int, testURL string, timeout time.Duration) (time.Duration, error) {
+	proxy := &url.URL{Scheme: "http", Host: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyURL(proxy),
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Get(testURL)
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	resp.Body.Close()
+	return elapsed, nil
+}
+
+// freePorts reserves n loopback ports by binding and releasing them
+func freePorts(n int) ([]int, error) {
+	listeners := make([]net.Listener, 0, n)
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	ports := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate port: %w", err)
+		}
+		listeners = append(listeners, l)
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// lockedBuffer collects process output written from exec's copy goroutine
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.buf.Len() > 64*1024 {
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,169 @@
+package latency
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"singbox.arrow.web2/internal/core/clashapi"
+	"singbox.arrow.web2/internal/core/singbox"
+	"singbox.arrow.web2/internal/storage"
+)
+
+const (
+	ModeTCP = "tcp" // TCP connect time to server:port
+	ModeURL = "url" // HTTP delay through the node
+
+	DefaultURL      = "https://www.gstatic.com/generate_204"
+	DefaultTimeout  = 5 * time.Second
+	DefaultWorkers  = 8
+	MaxWorkers      = 64
+	historyRetained = 30 * 24 * time.Hour
+)
+
+type Options struct {
+	Mode    string
+	URL     string
+	Timeout time.Duration
+	Workers int
+}
+
+type Result struct {
+	OutboundID uint   `json:"outbound_id"`
+	Name       string `json:"name"`
+	Mode       string `json:"mode"`
+	Via        string `json:"via"`     // tcp, clash_api or sing-box
+	Latency    *int   `json:"latency"` // ms, null when the test failed
+	Error      string `json:"error,omitempty"`
+}
+
+func (o *Options) normalize() error {
+	if o.Mode == "" {
+		o.Mode = ModeTCP
+	}
+	if o.Mode != ModeTCP && o.Mode != ModeURL {
+		return fmt.Errorf("unsupported test mode %q", o.Mode)
+	}
+	if o.URL == "" {
+		o.URL = DefaultURL
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.Workers <= 0 {
+		o.Workers = DefaultWorkers
+	}
+	if o.Workers > MaxWorkers {
+		o.Workers = MaxWorkers
+	}
+	return nil
+}
+
+// Test measures every outbound and returns the results in input order.
+// URL tests go through the running core's Clash API when it is available,
+// otherwise through a throwaway sing-box instance listening on loopback.
+func Test(manager *singbox.Manager, outbounds []storage.Outbound, opts Options) ([]Result, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(outbounds))
+	for i, out := range outbounds {
+		results[i] = Result{OutboundID: out.ID, Name: out.Name, Mode: opts.Mode}
+	}
+	if len(outbounds) == 0 {
+		return results, nil
+	}
+
+	if opts.Mode == ModeTCP {
+		run(len(outbounds), opts.Workers, func(i int) {
+			results[i].Via = "tcp"
+			d, err := probeTCP(&outbounds[i], opts.Timeout)
+			finish(&results[i], d, err)
+		})
+		return results, nil
+	}
+
+	// Nodes the running core knows go through its Clash API, the rest through a temporary instance
+	var pending []int
+	api := clashapi.Running(manager)
+	for i := range outbounds {
+		if api != nil && api.HasTag(outbounds[i].Tag) {
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if api != nil && len(pending) < len(outbounds) {
+		inPending := make(map[int]bool, len(pending))
+		for _, i := range pending {
+			inPending[i] = true
+		}
+		run(len(outbounds), opts.Workers, func(i int) {
+			if inPending[i] {
+				return
+			}
+			results[i].Via = "clash_api"
+			d, err := api.Delay(outbounds[i].Tag, opts.URL, opts.Timeout)
+			finish(&results[i], d, err)
+		})
+	}
+	if len(pending) > 0 {
+		testThroughInstance(manager, outbounds, pending, results, opts)
+	}
+	return results, nil
+}
+
+// Record stores the results on the outbounds and in the latency history
+func Record(results []Result) {
+	now := time.Now()
+	for _, r := range results {
+		latency := -1
+		if r.Latency != nil {
+			latency = *r.Latency
+		}
+		storage.DB.Model(&storage.Outbound{}).Where("id = ?", r.OutboundID).Update("latency", latency)
+		storage.DB.Create(&storage.LatencyHistory{
+			OutboundID: r.OutboundID,
+			Mode:       r.Mode,
+			Latency:    r.Latency,
+			Error:      r.Error,
+			CreatedAt:  now,
+		})
+	}
+	storage.DB.Where("created_at < ?", now.Add(-historyRetained)).Delete(&storage.LatencyHistory{})
+}
+
+// run calls fn for 0..n-1 on at most workers goroutines
+func run(n, workers int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func finish(r *Result, d time.Duration, err error) {
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	ms := int(d.Milliseconds())
+	if ms == 0 {
+		ms = 1
+	}
+	r.Latency = &ms
+}
@@ -0,0 +1,26 @@
+package latency
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"singbox.arrow.web2/internal/storage"
+)
+
+// probeTCP measures how long it takes to open a TCP connection to the node
+func probeTCP(out *storage.Outbound, timeout time.Duration) (time.Duration, error) {
+	if out.Server == "" || out.Port <= 0 {
+		return 0, fmt.Errorf("outbound has no server address")
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(out.Server, strconv.Itoa(out.Port)), timeout)
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	conn.Close()
+	return elapsed, nil
+}
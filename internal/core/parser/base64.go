@@ -0,0 +1,23 @@
+package parser
+
+import (
+	"strings"
+)
+
+// ParseBase64 reads a list of share links, either plain or base64 encoded as a whole
+func ParseBase64(content []byte) ([]*Node, []SkippedProxy) {
+	text := strings.TrimSpace(string(content))
+	if !strings.Contains(text, "://") {
+		if decoded, err := decodeBase64(strings.Join(strings.Fields(text), "")); err == nil {
+			text = string(decoded)
+		}
+	}
+
+	nodes, failed := ParseLinks(text)
+	skipped := make([]SkippedProxy, 0, len(failed))
+	for _, f := range failed {
+		scheme, _, _ := strings.Cut(f.Link, "://")
+		skipped = append(skipped, SkippedProxy{Name: f.Link, Type: scheme, Reason: f.Reason})
+	}
+	return nodes, skipped
+}
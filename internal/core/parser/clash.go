@@ -0,0 +1,505 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// SkippedProxy records a Clash proxy that could not be mapped to sing-box
+type SkippedProxy struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type clashConfig struct {
+	Proxies []map[string]interface{} `yaml:"proxies"`
+}
+
+var clashProxiesPattern = regexp.MustCompile(`(?m)^proxies\s*:`)
+
+// IsClash reports whether content looks like a Clash/Mihomo YAML config
+func IsClash(content []byte) bool {
+	return clashProxiesPattern.Match(content)
+}
+
+// ParseClash reads the proxies list of a Clash/Mihomo YAML config.
+// Proxies that cannot be represented in sing-box are returned as skipped.
+func ParseClash(content []byte) ([]*Node, []SkippedProxy, error) {
+	var cfg clashConfig
+	if err := yaml.Unmarshal(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")), &cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid clash config: %w", err)
+	}
+
+	var nodes []*Node
+	var skipped []SkippedProxy
+	for _, proxy := range cfg.Proxies {
+		p := clashProxy(proxy)
+		node, err := p.toNode()
+		if err != nil {
+			skipped = append(skipped, SkippedProxy{Name: p.str("name"), Type: p.str("type"), Reason: err.Error()})
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, skipped, nil
+}
+
+type clashProxy map[string]interface{}
+
+func (p clashProxy) toNode() (*Node, error) {
+	name := p.str("name")
+	server := p.str("server")
+	port := p.int("port")
+	if server == "" {
+		return nil, fmt.Errorf("missing server")
+	}
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port")
+	}
+
+	node := &Node{Name: name, Server: server, Port: port, Options: map[string]interface{}{}}
+	var err error
+	switch proxyType := p.str("type"); proxyType {
+	case "ss":
+		err = p.shadowsocks(node)
+	case "vmess":
+		err = p.vmess(node)
+	case "vless":
+		err = p.vless(node)
+	case "trojan":
+		err = p.trojan(node)
+	case "hysteria2":
+		err = p.hysteria2(node)
+	case "hysteria":
+		err = p.hysteria(node)
+	case "tuic":
+		err = p.tuic(node)
+	case "socks5":
+		err = p.socks(node)
+	case "http":
+		err = p.http(node)
+	case "anytls":
+		err = p.anytls(node)
+	case "ssr":
+		err = fmt.Errorf("shadowsocksr is not supported by sing-box")
+	case "snell":
+		err = fmt.Errorf("snell is not supported by sing-box")
+	case "wireguard":
+		err = fmt.Errorf("wireguard is a sing-box endpoint, not an outbound")
+	default:
+		err = fmt.Errorf("unsupported proxy type %q", proxyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if node.Name == "" {
+		node.Name = fmt.Sprintf("%s:%d", server, port)
+	}
+	return node, nil
+}
+
+func (p clashProxy) shadowsocks(node *Node) error {
+	node.Type = "shadowsocks"
+	method := p.str("cipher")
+	if method == "" {
+		return fmt.Errorf("missing cipher")
+	}
+	node.Options["method"] = method
+	node.Options["password"] = p.str("password")
+	p.udpNetwork(node)
+
+	switch plugin := p.str("plugin"); plugin {
+	case "":
+	case "obfs":
+		opts := p.mapValue("plugin-opts")
+		parts := []string{"obfs=" + opts.str("mode")}
+		if host := opts.str("host"); host != "" {
+			parts = append(parts, "obfs-host="+host)
+		}
+		node.Options["plugin"] = "obfs-local"
+		node.Options["plugin_opts"] = strings.Join(parts, ";")
+	case "v2ray-plugin":
+		opts := p.mapValue("plugin-opts")
+		if mode := opts.str("mode"); mode != "" && mode != "websocket" {
+			return fmt.Errorf("v2ray-plugin mode %q is not supported", mode)
+		}
+		parts := []string{"mode=websocket"}
+		if opts.bool("tls") {
+			parts = append(parts, "tls")
+		}
+		if host := opts.str("host"); host != "" {
+			parts = append(parts, "host="+host)
+		}
+		if path := opts.str("path"); path != "" {
+			parts = append(parts, "path="+path)
+		}
+		if opts.bool("mux") {
+			parts = append(parts, "mux=1")
+		}
+		node.Options["plugin"] = "v2ray-plugin"
+		node.Options["plugin_opts"] = strings.Join(parts, ";")
+	default:
+		return fmt.Errorf("shadowsocks plugin %q is not supported", plugin)
+	}
+	return nil
+}
+
+func (p clashProxy) vmess(node *Node) error {
+	node.Type = "vmess"
+	uuid := p.str("uuid")
+	if uuid == "" {
+		return fmt.Errorf("missing uuid")
+	}
+	security := p.str("cipher")
+	if security == "" {
+		security = "auto"
+	}
+	node.Options["uuid"] = uuid
+	node.Options["security"] = security
+	node.Options["alter_id"] = p.int("alterId")
+	p.udpNetwork(node)
+	if p.bool("tls") {
+		node.Options["tls"] = p.tls(node.Server, "tls")
+	}
+	return p.transport(node)
+}
+
+func (p clashProxy) vless(node *Node) error {
+	node.Type = "vless"
+	uuid := p.str("uuid")
+	if uuid == "" {
+		return fmt.Errorf("missing uuid")
+	}
+	node.Options["uuid"] = uuid
+	if flow := p.str("flow"); flow != "" {
+		node.Options["flow"] = flow
+	}
+	if encoding := p.str("packet-encoding"); encoding != "" {
+		node.Options["packet_encoding"] = encoding
+	}
+	p.udpNetwork(node)
+
+	if _, ok := p["reality-opts"]; ok {
+		node.Options["tls"] = p.tls(node.Server, "reality")
+	} else if p.bool("tls") {
+		node.Options["tls"] = p.tls(node.Server, "tls")
+	}
+	return p.transport(node)
+}
+
+func (p clashProxy) trojan(node *Node) error {
+	node.Type = "trojan"
+	password := p.str("password")
+	if password == "" {
+		return fmt.Errorf("missing password")
+	}
+	node.Options["password"] = password
+	p.udpNetwork(node)
+
+	security := "tls"
+	if _, ok := p["reality-opts"]; ok {
+		security = "reality"
+	}
+	node.Options["tls"] = p.tls(node.Server, security)
+	return p.transport(node)
+}
+
+func (p clashProxy) hysteria2(node *Node) error {
+	node.Type = "hysteria2"
+	password := firstNonEmpty(p.str("password"), p.str("auth"))
+	if password == "" {
+		return fmt.Errorf("missing password")
+	}
+	node.Options["password"] = password
+	if obfs := p.str("obfs"); obfs != "" {
+		node.Options["obfs"] = map[string]interface{}{
+			"type":     obfs,
+			"password": p.str("obfs-password"),
+		}
+	}
+	if ports := portRanges(p.str("ports")); ports != nil {
+		node.Options["server_ports"] = ports
+	}
+	p.bandwidth(node)
+
+	tls := p.tls(node.Server, "tls")
+	if tls["alpn"] == nil {
+		tls["alpn"] = []string{"h3"}
+	}
+	node.Options["tls"] = tls
+	return nil
+}
+
+func (p clashProxy) hysteria(node *Node) error {
+	node.Type = "hysteria"
+	if protocol := p.str("protocol"); protocol != "" && protocol != "udp" {
+		return fmt.Errorf("hysteria protocol %q is not supported", protocol)
+	}
+	if auth := p.str("auth-str"); auth != "" {
+		node.Options["auth_str"] = auth
+	}
+	if obfs := p.str("obfs"); obfs != "" {
+		node.Options["obfs"] = obfs
+	}
+	p.bandwidth(node)
+	node.Options["tls"] = p.tls(node.Server, "tls")
+	return nil
+}
+
+func (p clashProxy) tuic(node *Node) error {
+	node.Type = "tuic"
+	uuid := p.str("uuid")
+	if uuid == "" {
+		if p.str("token") != "" {
+			return fmt.Errorf("tuic v4 token authentication is not supported")
+		}
+		return fmt.Errorf("missing uuid")
+	}
+	node.Options["uuid"] = uuid
+	node.Options["password"] = p.str("password")
+	if cc := p.str("congestion-controller"); cc != "" {
+		node.Options["congestion_control"] = cc
+	}
+	if mode := p.str("udp-relay-mode"); mode != "" {
+		node.Options["udp_relay_mode"] = mode
+	}
+	if p.bool("reduce-rtt") {
+		node.Options["zero_rtt_handshake"] = true
+	}
+
+	tls := p.tls(node.Server, "tls")
+	if tls["alpn"] == nil {
+		tls["alpn"] = []string{"h3"}
+	}
+	if p.bool("disable-sni") {
+		delete(tls, "server_name")
+	}
+	node.Options["tls"] = tls
+	return nil
+}
+
+func (p clashProxy) socks(node *Node) error {
+	node.Type = "socks"
+	if p.bool("tls") {
+		return fmt.Errorf("socks5 over TLS is not supported by sing-box")
+	}
+	node.Options["version"] = "5"
+	if username := p.str("username"); username != "" {
+		node.Options["username"] = username
+		node.Options["password"] = p.str("password")
+	}
+	p.udpNetwork(node)
+	return nil
+}
+
+func (p clashProxy) http(node *Node) error {
+	node.Type = "http"
+	if username := p.str("username"); username != "" {
+		node.Options["username"] = username
+		node.Options["password"] = p.str("password")
+	}
+	if p.bool("tls") {
+		node.Options["tls"] = p.tls(node.Server, "tls")
+	}
+	return nil
+}
+
+func (p clashProxy) anytls(node *Node) error {
+	node.Type = "anytls"
+	password := p.str("password")
+	if password == "" {
+		return fmt.Errorf("missing password")
+	}
+	node.Options["password"] = password
+	node.Options["tls"] = p.tls(node.Server, "tls")
+	return nil
+}
+
+// udpNetwork restricts the outbound to TCP when the proxy has udp disabled
+func (p clashProxy) udpNetwork(node *Node) {
+	if v, ok := p["udp"]; ok {
+		if enabled, _ := v.(bool); !enabled {
+			node.Options["network"] = "tcp"
+		}
+	}
+}
+
+func (p clashProxy) bandwidth(node *Node) {
+	if up := parseMbps(p.str("up")); up > 0 {
+		node.Options["up_mbps"] = up
+	}
+	if down := parseMbps(p.str("down")); down > 0 {
+		node.Options["down_mbps"] = down
+	}
+}
+
+func (p clashProxy) tls(server, security string) map[string]interface{} {
+	params := TLSParams{
+		Security:    security,
+		SNI:         firstNonEmpty(p.str("servername"), p.str("sni")),
+		ALPN:        p.strings("alpn"),
+		Fingerprint: p.str("client-fingerprint"),
+		Insecure:    p.bool("skip-cert-verify"),
+	}
+	if security == "reality" {
+		reality := p.mapValue("reality-opts")
+		params.PublicKey = reality.str("public-key")
+		params.ShortID = reality.str("short-id")
+	}
+	return buildTLS(params, server)
+}
+
+func (p clashProxy) transport(node *Node) error {
+	network := p.str("network")
+	var transport map[string]interface{}
+
+	switch network {
+	case "", "tcp":
+		return nil
+	case "ws":
+		opts := p.mapValue("ws-opts")
+		transport = buildTransport(TransportParams{
+			Network: "ws",
+			Path:    opts.str("path"),
+			Host:    opts.mapValue("headers").str("Host"),
+		})
+		if ed := opts.int("max-early-data"); ed > 0 {
+			transport["max_early_data"] = ed
+			transport["early_data_header_name"] = firstNonEmpty(opts.str("early-data-header-name"), "Sec-WebSocket-Protocol")
+		}
+		if opts.bool("v2ray-http-upgrade") {
+			transport["type"] = "httpupgrade"
+			delete(transport, "max_early_data")
+			delete(transport, "early_data_header_name")
+			if host := opts.mapValue("headers").str("Host"); host != "" {
+				delete(transport, "headers")
+				transport["host"] = host
+			}
+		}
+	case "grpc":
+		opts := p.mapValue("grpc-opts")
+		transport = buildTransport(TransportParams{
+			Network:     "grpc",
+			ServiceName: opts.str("grpc-service-name"),
+		})
+	case "h2":
+		opts := p.mapValue("h2-opts")
+		transport = map[string]interface{}{"type": "http"}
+		if hosts := opts.strings("host"); len(hosts) > 0 {
+			transport["host"] = hosts
+		}
+		if path := opts.str("path"); path != "" {
+			transport["path"] = path
+		}
+	case "http":
+		opts := p.mapValue("http-opts")
+		transport = map[string]interface{}{"type": "http"}
+		if method := opts.str("method"); method != "" {
+			transport["method"] = method
+		}
+		if paths := opts.strings("path"); len(paths) > 0 {
+			transport["path"] = paths[0]
+		}
+		if hosts := opts.mapValue("headers").strings("Host"); len(hosts) > 0 {
+			transport["host"] = hosts
+		}
+	default:
+		return fmt.Errorf("network %q is not supported", network)
+	}
+
+	node.Options["transport"] = transport
+	return nil
+}
+
+func (p clashProxy) str(key string) string {
+	switch v := p[key].(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (p clashProxy) int(key string) int {
+	switch v := p[key].(type) {
+	case uint64:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
+
+func (p clashProxy) bool(key string) bool {
+	switch v := p[key].(type) {
+	case bool:
+		return v
+	case string:
+		return isTrue(v)
+	}
+	return false
+}
+
+func (p clashProxy) strings(key string) []string {
+	switch v := p[key].(type) {
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			if s := fmt.Sprint(item); s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	case string:
+		return splitList(v)
+	}
+	return nil
+}
+
+func (p clashProxy) mapValue(key string) clashProxy {
+	if m, ok := p[key].(map[string]interface{}); ok {
+		return clashProxy(m)
+	}
+	return clashProxy{}
+}
+
+// parseMbps reads Clash bandwidth values such as "100", "100 Mbps" or "1 Gbps"
+func parseMbps(value string) int {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return 0
+	}
+	multiplier := 1
+	units := []struct {
+		suffix string
+		factor int
+	}{{"gbps", 1000}, {"mbps", 1}, {"g", 1000}, {"m", 1}}
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.factor
+			break
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return n * multiplier
+}
@@ -0,0 +1,270 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseClash(t *testing.T) {
+	tests := []struct {
+		name   string
+		proxy  string // one entry of the proxies list
+		node   string // name|type|server|port
+		config string // outbound config as ToOutbound writes it
+	}{
+		{
+			name:   "ss",
+			proxy:  `{name: ss, type: ss, server: ss.example.com, port: 8388, cipher: aes-256-gcm, password: pass}`,
+			node:   "ss|shadowsocks|ss.example.com|8388",
+			config: `{"method":"aes-256-gcm","password":"pass","server":"ss.example.com","server_port":8388}`,
+		},
+		{
+			name:   "ss udp disabled",
+			proxy:  `{name: ss-tcp, type: ss, server: ss.example.com, port: 8388, cipher: aes-256-gcm, password: pass, udp: false}`,
+			node:   "ss-tcp|shadowsocks|ss.example.com|8388",
+			config: `{"method":"aes-256-gcm","password":"pass","network":"tcp","server":"ss.example.com","server_port":8388}`,
+		},
+		{
+			name:   "ss udp enabled",
+			proxy:  `{name: ss-udp, type: ss, server: ss.example.com, port: 8388, cipher: aes-256-gcm, password: pass, udp: true}`,
+			node:   "ss-udp|shadowsocks|ss.example.com|8388",
+			config: `{"method":"aes-256-gcm","password":"pass","server":"ss.example.com","server_port":8388}`,
+		},
+		{
+			name: "ss obfs plugin",
+			proxy: `{name: ss-obfs, type: ss, server: ss.example.com, port: 8388, cipher: aes-256-gcm, password: pass,
+				plugin: obfs, plugin-opts: {mode: tls, host: bing.com}}`,
+			node: "ss-obfs|shadowsocks|ss.example.com|8388",
+			config: `{"method":"aes-256-gcm","password":"pass","plugin":"obfs-local","plugin_opts":"obfs=tls;obfs-host=bing.com",
+				"server":"ss.example.com","server_port":8388}`,
+		},
+		{
+			name: "ss v2ray-plugin",
+			proxy: `{name: ss-v2ray, type: ss, server: ss.example.com, port: 443, cipher: aes-128-gcm, password: pass,
+				plugin: v2ray-plugin, plugin-opts: {mode: websocket, tls: true, host: cdn.example.com, path: /ws, mux: true}}`,
+			node: "ss-v2ray|shadowsocks|ss.example.com|443",
+			config: `{"method":"aes-128-gcm","password":"pass","plugin":"v2ray-plugin","plugin_opts":"mode=websocket;tls;host=cdn.example.com;path=/ws;mux=1",
+				"server":"ss.example.com","server_port":443}`,
+		},
+		{
+			name: "vmess ws tls",
+			proxy: `{name: vm-ws, type: vmess, server: vm.example.com, port: 443, uuid: ` + testUUID + `, alterId: 0, cipher: auto,
+				tls: true, servername: sni.example.com, network: ws, ws-opts: {path: /ray, headers: {Host: cdn.example.com}}}`,
+			node: "vm-ws|vmess|vm.example.com|443",
+			config: `{"alter_id":0,"security":"auto","server":"vm.example.com","server_port":443,"uuid":"` + testUUID + `",
+				"tls":{"enabled":true,"server_name":"sni.example.com"},
+				"transport":{"type":"ws","path":"/ray","headers":{"Host":"cdn.example.com"}}}`,
+		},
+		{
+			name:   "vmess without cipher or network",
+			proxy:  `{name: vm-tcp, type: vmess, server: 203.0.113.9, port: 10086, uuid: ` + testUUID + `, alterId: 64}`,
+			node:   "vm-tcp|vmess|203.0.113.9|10086",
+			config: `{"alter_id":64,"security":"auto","server":"203.0.113.9","server_port":10086,"uuid":"` + testUUID + `"}`,
+		},
+		{
+			name: "vmess ws early data",
+			proxy: `{name: vm-ed, type: vmess, server: vm.example.com, port: 80, uuid: ` + testUUID + `, alterId: 0, cipher: none,
+				network: ws, ws-opts: {path: /ed, max-early-data: 2048}}`,
+			node: "vm-ed|vmess|vm.example.com|80",
+			config: `{"alter_id":0,"security":"none","server":"vm.example.com","server_port":80,"uuid":"` + testUUID + `",
+				"transport":{"type":"ws","path":"/ed","max_early_data":2048,"early_data_header_name":"Sec-WebSocket-Protocol"}}`,
+		},
+		{
+			name: "vmess http upgrade",
+			proxy: `{name: vm-hu, type: vmess, server: vm.example.com, port: 80, uuid: ` + testUUID + `, alterId: 0,
+				network: ws, ws-opts: {path: /up, headers: {Host: cdn.example.com}, v2ray-http-upgrade: true, max-early-data: 2048}}`,
+			node: "vm-hu|vmess|vm.example.com|80",
+			config: `{"alter_id":0,"security":"auto","server":"vm.example.com","server_port":80,"uuid":"` + testUUID + `",
+				"transport":{"type":"httpupgrade","path":"/up","host":"cdn.example.com"}}`,
+		},
+		{
+			name: "vmess h2",
+			proxy: `{name: vm-h2, type: vmess, server: vm.example.com, port: 443, uuid: ` + testUUID + `, alterId: 0, tls: true,
+				network: h2, h2-opts: {host: [a.example.com, b.example.com], path: /h2}}`,
+			node: "vm-h2|vmess|vm.example.com|443",
+			config: `{"alter_id":0,"security":"auto","server":"vm.example.com","server_port":443,"uuid":"` + testUUID + `",
+				"tls":{"enabled":true,"server_name":"vm.example.com"},
+				"transport":{"type":"http","host":["a.example.com","b.example.com"],"path":"/h2"}}`,
+		},
+		{
+			name: "vmess http",
+			proxy: `{name: vm-http, type: vmess, server: vm.example.com, port: 80, uuid: ` + testUUID + `, alterId: 0,
+				network: http, http-opts: {method: GET, path: [/a, /b], headers: {Host: [h.example.com]}}}`,
+			node: "vm-http|vmess|vm.example.com|80",
+			config: `{"alter_id":0,"security":"auto","server":"vm.example.com","server_port":80,"uuid":"` + testUUID + `",
+				"transport":{"type":"http","method":"GET","path":"/a","host":["h.example.com"]}}`,
+		},
+		{
+			name: "vless reality vision",
+			proxy: `{name: reality, type: vless, server: reality.example.com, port: 443, uuid: ` + testUUID + `, flow: xtls-rprx-vision,
+				tls: true, servername: www.microsoft.com, reality-opts: {public-key: Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw, short-id: 6ba85179e30d4fc2}}`,
+			node: "reality|vless|reality.example.com|443",
+			config: `{"flow":"xtls-rprx-vision","server":"reality.example.com","server_port":443,"uuid":"` + testUUID + `",
+				"tls":{"enabled":true,"server_name":"www.microsoft.com","utls":{"enabled":true,"fingerprint":"chrome"},
+				"reality":{"enabled":true,"public_key":"Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw","short_id":"6ba85179e30d4fc2"}}}`,
+		},
+		{
+			name: "vless grpc",
+			proxy: `{name: vless-grpc, type: vless, server: grpc.example.com, port: 443, uuid: ` + testUUID + `, tls: true,
+				client-fingerprint: firefox, packet-encoding: xudp, network: grpc, grpc-opts: {grpc-service-name: grpc-svc}}`,
+			node: "vless-grpc|vless|grpc.example.com|443",
+			config: `{"packet_encoding":"xudp","server":"grpc.example.com","server_port":443,"uuid":"` + testUUID + `",
+				"tls":{"enabled":true,"server_name":"grpc.example.com","utls":{"enabled":true,"fingerprint":"firefox"}},
+				"transport":{"type":"grpc","service_name":"grpc-svc"}}`,
+		},
+		{
+			name:   "trojan skip-cert-verify",
+			proxy:  `{name: trojan, type: trojan, server: trojan.example.com, port: 443, password: pass, sni: sni.example.com, skip-cert-verify: true}`,
+			node:   "trojan|trojan|trojan.example.com|443",
+			config: `{"password":"pass","server":"trojan.example.com","server_port":443,"tls":{"enabled":true,"insecure":true,"server_name":"sni.example.com"}}`,
+		},
+		{
+			name: "trojan ws udp disabled",
+			proxy: `{name: trojan-ws, type: trojan, server: trojan.example.com, port: 443, password: pass, udp: false, alpn: [h2, http/1.1],
+				network: ws, ws-opts: {path: /tj}}`,
+			node: "trojan-ws|trojan|trojan.example.com|443",
+			config: `{"password":"pass","network":"tcp","server":"trojan.example.com","server_port":443,
+				"tls":{"enabled":true,"server_name":"trojan.example.com","alpn":["h2","http/1.1"]},
+				"transport":{"type":"ws","path":"/tj"}}`,
+		},
+		{
+			name: "hysteria2",
+			proxy: `{name: hy2, type: hysteria2, server: hy2.example.com, port: 443, password: letmein, ports: 20000-30000,
+				obfs: salamander, obfs-password: cry, up: 50 Mbps, down: 1 Gbps, skip-cert-verify: true}`,
+			node: "hy2|hysteria2|hy2.example.com|443",
+			config: `{"password":"letmein","server":"hy2.example.com","server_port":443,"server_ports":["20000:30000"],
+				"obfs":{"type":"salamander","password":"cry"},"up_mbps":50,"down_mbps":1000,
+				"tls":{"alpn":["h3"],"enabled":true,"insecure":true,"server_name":"hy2.example.com"}}`,
+		},
+		{
+			name: "hysteria",
+			proxy: `{name: hy, type: hysteria, server: 198.51.100.7, port: 8443, auth-str: secret, obfs: xplus, up: "30", down: "100",
+				sni: hy.example.com, alpn: [hysteria]}`,
+			node: "hy|hysteria|198.51.100.7|8443",
+			config: `{"auth_str":"secret","obfs":"xplus","up_mbps":30,"down_mbps":100,"server":"198.51.100.7","server_port":8443,
+				"tls":{"alpn":["hysteria"],"enabled":true,"server_name":"hy.example.com"}}`,
+		},
+		{
+			name: "tuic",
+			proxy: `{name: tuic, type: tuic, server: tuic.example.com, port: 443, uuid: ` + testUUID + `, password: tuicpass,
+				congestion-controller: bbr, udp-relay-mode: native, reduce-rtt: true, disable-sni: true}`,
+			node: "tuic|tuic|tuic.example.com|443",
+			config: `{"uuid":"` + testUUID + `","password":"tuicpass","congestion_control":"bbr","udp_relay_mode":"native","zero_rtt_handshake":true,
+				"server":"tuic.example.com","server_port":443,"tls":{"alpn":["h3"],"enabled":true}}`,
+		},
+		{
+			name:   "socks5",
+			proxy:  `{name: socks, type: socks5, server: 127.0.0.1, port: 1080, username: user, password: pass, udp: false}`,
+			node:   "socks|socks|127.0.0.1|1080",
+			config: `{"version":"5","username":"user","password":"pass","network":"tcp","server":"127.0.0.1","server_port":1080}`,
+		},
+		{
+			name:   "http tls",
+			proxy:  `{name: http, type: http, server: proxy.example.com, port: 443, username: user, password: pass, tls: true, skip-cert-verify: true}`,
+			node:   "http|http|proxy.example.com|443",
+			config: `{"username":"user","password":"pass","server":"proxy.example.com","server_port":443,"tls":{"enabled":true,"insecure":true,"server_name":"proxy.example.com"}}`,
+		},
+		{
+			name:   "anytls",
+			proxy:  `{name: anytls, type: anytls, server: any.example.com, port: 443, password: pass, client-fingerprint: chrome}`,
+			node:   "anytls|anytls|any.example.com|443",
+			config: `{"password":"pass","server":"any.example.com","server_port":443,"tls":{"enabled":true,"server_name":"any.example.com","utls":{"enabled":true,"fingerprint":"chrome"}}}`,
+		},
+		{
+			name:   "unnamed proxy is named after its address",
+			proxy:  `{type: trojan, server: 192.0.2.1, port: "443", password: pass}`,
+			node:   "192.0.2.1:443|trojan|192.0.2.1|443",
+			config: `{"password":"pass","server":"192.0.2.1","server_port":443,"tls":{"enabled":true}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, skipped, err := ParseClash([]byte("proxies:\n  - " + tt.proxy + "\n"))
+			if err != nil {
+				t.Fatalf("ParseClash: %v", err)
+			}
+			if len(nodes) != 1 || len(skipped) != 0 {
+				t.Fatalf("got %d nodes and skipped %+v, want one node", len(nodes), skipped)
+			}
+			outbound, err := nodes[0].ToOutbound(nil)
+			if err != nil {
+				t.Fatalf("ToOutbound: %v", err)
+			}
+			got := strings.Join([]string{outbound.Name, outbound.Type, outbound.Server, strconv.Itoa(outbound.Port)}, "|")
+			if got != tt.node {
+				t.Errorf("node = %s, want %s", got, tt.node)
+			}
+			var gotConfig, wantConfig interface{}
+			if err := json.Unmarshal([]byte(outbound.Config), &gotConfig); err != nil {
+				t.Fatalf("config: %v", err)
+			}
+			if err := json.Unmarshal([]byte(tt.config), &wantConfig); err != nil {
+				t.Fatalf("want config: %v", err)
+			}
+			if !reflect.DeepEqual(gotConfig, wantConfig) {
+				t.Errorf("config = %s\nwant %s", outbound.Config, tt.config)
+			}
+		})
+	}
+}
+
+func TestParseClashSkipped(t *testing.T) {
+	content := `
+proxies:
+  - {name: ok, type: trojan, server: a.example.com, port: 443, password: pass}
+  - {name: ssr, type: ssr, server: a.example.com, port: 443}
+  - {name: snell, type: snell, server: a.example.com, port: 443}
+  - {name: wg, type: wireguard, server: a.example.com, port: 51820}
+  - {name: unknown, type: mieru, server: a.example.com, port: 443}
+  - {name: no-server, type: trojan, port: 443, password: pass}
+  - {name: bad-port, type: trojan, server: a.example.com, port: 70000, password: pass}
+  - {name: no-cipher, type: ss, server: a.example.com, port: 443, password: pass}
+  - {name: simple-obfs, type: ss, server: a.example.com, port: 443, cipher: aes-256-gcm, plugin: shadow-tls}
+  - {name: v2ray-quic, type: ss, server: a.example.com, port: 443, cipher: aes-256-gcm, plugin: v2ray-plugin, plugin-opts: {mode: quic}}
+  - {name: no-uuid, type: vless, server: a.example.com, port: 443}
+  - {name: tuic-v4, type: tuic, server: a.example.com, port: 443, token: secret}
+  - {name: hy-tcp, type: hysteria, server: a.example.com, port: 443, protocol: faketcp}
+  - {name: socks-tls, type: socks5, server: a.example.com, port: 443, tls: true}
+  - {name: xhttp, type: vless, server: a.example.com, port: 443, uuid: ` + testUUID + `, network: xhttp}
+`
+	nodes, skipped, err := ParseClash([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Name != "ok" {
+		t.Errorf("nodes = %v, want ok", nodes)
+	}
+	want := []SkippedProxy{
+		{"ssr", "ssr", "shadowsocksr is not supported by sing-box"},
+		{"snell", "snell", "snell is not supported by sing-box"},
+		{"wg", "wireguard", "wireguard is a sing-box endpoint, not an outbound"},
+		{"unknown", "mieru", `unsupported proxy type "mieru"`},
+		{"no-server", "trojan", "missing server"},
+		{"bad-port", "trojan", "invalid port"},
+		{"no-cipher", "ss", "missing cipher"},
+		{"simple-obfs", "ss", `shadowsocks plugin "shadow-tls" is not supported`},
+		{"v2ray-quic", "ss", `v2ray-plugin mode "quic" is not supported`},
+		{"no-uuid", "vless", "missing uuid"},
+		{"tuic-v4", "tuic", "tuic v4 token authentication is not supported"},
+		{"hy-tcp", "hysteria", `hysteria protocol "faketcp" is not supported`},
+		{"socks-tls", "socks5", "socks5 over TLS is not supported by sing-box"},
+		{"xhttp", "vless", `network "xhttp" is not supported`},
+	}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %+v\nwant %+v", skipped, want)
+	}
+}
+
+func TestParseClashInvalid(t *testing.T) {
+	if _, _, err := ParseClash([]byte("proxies: [")); err == nil {
+		t.Error("ParseClash succeeded on invalid YAML")
+	}
+	nodes, skipped, err := ParseClash([]byte("\xef\xbb\xbfproxies:\n  - {name: bom, type: trojan, server: a.example.com, port: 443, password: pass}\n"))
+	if err != nil || len(nodes) != 1 || len(skipped) != 0 {
+		t.Errorf("BOM prefixed config: nodes %v, skipped %v, err %v", nodes, skipped, err)
+	}
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	FormatAuto    = "auto"
+	FormatSingbox = "singbox"
+	FormatClash   = "clash"
+	FormatV2Ray   = "v2ray"
+	FormatBase64  = "base64"
+)
+
+// Result is the outcome of parsing a whole subscription
+type Result struct {
+	Format  string
+	Nodes   []*Node
+	Skipped []SkippedProxy
+}
+
+// DetectFormat guesses the subscription format from its content
+func DetectFormat(content []byte) string {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")))
+
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		var probe struct {
+			Outbounds []map[string]interface{} `json:"outbounds"`
+		}
+		if trimmed[0] == '[' {
+			return FormatV2Ray
+		}
+		if json.Unmarshal(trimmed, &probe) == nil {
+			for _, out := range probe.Outbounds {
+				if _, ok := out["protocol"]; ok {
+					return FormatV2Ray
+				}
+			}
+		}
+		return FormatSingbox
+	}
+
+	if IsClash(trimmed) {
+		return FormatClash
+	}
+	return FormatBase64
+}
+
+// Parse parses subscription content in the given format, detecting it when format is auto or empty
+func Parse(content []byte, format string) (*Result, error) {
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	if format == "" || format == FormatAuto {
+		format = DetectFormat(content)
+	}
+
+	result := &Result{Format: format}
+	var err error
+	switch strings.ToLower(format) {
+	case FormatSingbox:
+		result.Nodes, result.Skipped, err = ParseSingbox(content)
+	case FormatClash:
+		result.Nodes, result.Skipped, err = ParseClash(content)
+	case FormatV2Ray:
+		result.Nodes, result.Skipped, err = ParseV2Ray(content)
+	case FormatBase64:
+		result.Nodes, result.Skipped = ParseBase64(content)
+	default:
+		return nil, fmt.Errorf("unsupported subscription format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
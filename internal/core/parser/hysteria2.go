@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// parseHysteria2 handles hysteria2:// and hy2:// links.
+// The auth string may be given as user or user:password.
+func parseHysteria2(link string) (*Node, error) {
+	link, hopping := splitPortList(link)
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	// Port hopping links carry a range in mport or after the host, the host port is
+	// still the main port
+	server, port, err := parseHostPort(u)
+	if err != nil {
+		return nil, err
+	}
+
+	password := ""
+	if u.User != nil {
+		password = u.User.Username()
+		if pass, ok := u.User.Password(); ok {
+			password += ":" + pass
+		}
+	}
+	if password == "" {
+		return nil, fmt.Errorf("missing auth")
+	}
+
+	options := map[string]interface{}{
+		"password": password,
+	}
+	if ports := append(portRanges(hopping), portRanges(q.Get("mport"))...); ports != nil {
+		options["server_ports"] = ports
+	}
+	if obfs := q.Get("obfs"); obfs != "" && obfs != "none" {
+		options["obfs"] = map[string]interface{}{
+			"type":     obfs,
+			"password": q.Get("obfs-password"),
+		}
+	}
+
+	tls := buildTLS(TLSParams{
+		Security: "tls",
+		SNI:      q.Get("sni"),
+		ALPN:     splitList(q.Get("alpn")),
+		Insecure: isTrue(q.Get("insecure")),
+	}, server)
+	if tls["alpn"] == nil {
+		tls["alpn"] = []string{"h3"}
+	}
+	options["tls"] = tls
+
+	return &Node{Name: fragmentName(u), Type: "hysteria2", Server: server, Port: port, Options: options}, nil
+}
+
+// portRanges turns a port hopping list such as 443,20000-30000 into sing-box
+// server_ports. sing-box only takes ranges, a single port becomes 443:443.
+func portRanges(v string) []string {
+	var ports []string
+	for _, r := range splitList(v) {
+		if strings.Contains(r, "-") {
+			ports = append(ports, strings.Replace(r, "-", ":", 1))
+		} else if !strings.Contains(r, ":") {
+			ports = append(ports, r+":"+r)
+		} else {
+			ports = append(ports, r)
+		}
+	}
+	return ports
+}
+
+// splitPortList takes a port hopping list such as host:443,20000-30000 out of
+// the link, url.Parse only accepts a single port. The first port stays in the
+// link as the main port.
+func splitPortList(link string) (string, string) {
+	i := strings.Index(link, "://")
+	if i < 0 {
+		return link, ""
+	}
+	start := i + len("://")
+	end := len(link)
+	if j := strings.IndexAny(link[start:], "/?#"); j >= 0 {
+		end = start + j
+	}
+	authority := link[start:end]
+	colon := strings.LastIndex(authority, ":")
+	if colon < strings.LastIndex(authority, "@") || strings.Contains(authority[colon:], "]") {
+		return link, ""
+	}
+	ports := authority[colon+1:]
+	sep := strings.IndexAny(ports, ",-")
+	if sep < 0 {
+		return link, ""
+	}
+	return link[:start+colon+1] + ports[:sep] + link[end:], ports
+}
@@ -0,0 +1,33 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"singbox.arrow.web2/internal/storage"
+)
+
+// Identity returns a key that stays the same for a node as long as its
+// protocol, endpoint and credentials do, regardless of how it is named
+func (n *Node) Identity() string {
+	return identity(n.Type, n.Server, n.Port, n.Options)
+}
+
+// OutboundIdentity computes the same key as Node.Identity for a stored outbound
+func OutboundIdentity(out *storage.Outbound) string {
+	var options map[string]interface{}
+	json.Unmarshal([]byte(out.Config), &options)
+	return identity(out.Type, out.Server, out.Port, options)
+}
+
+func identity(nodeType, server string, port int, options map[string]interface{}) string {
+	credential := ""
+	for _, key := range []string{"uuid", "password", "auth_str", "username"} {
+		if v, ok := options[key].(string); ok && v != "" {
+			credential = v
+			break
+		}
+	}
+	return fmt.Sprintf("%s|%s|%d|%s", nodeType, strings.ToLower(server), port, credential)
+}
@@ -0,0 +1,166 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"singbox.arrow.web2/internal/storage"
+)
+
+// Node is a single proxy parsed from a share link or a subscription.
+// Options holds the sing-box outbound fields except type and tag.
+type Node struct {
+	Name    string
+	Type    string
+	Server  string
+	Port    int
+	Options map[string]interface{}
+}
+
+// ToOutbound converts the node into an outbound row ready to be saved
+func (n *Node) ToOutbound(subscriptionID *uint) (*storage.Outbound, error) {
+	options := make(map[string]interface{}, len(n.Options)+2)
+	for k, v := range n.Options {
+		options[k] = v
+	}
+	options["server"] = n.Server
+	options["server_port"] = n.Port
+
+	config, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage.Outbound{
+		SubscriptionID: subscriptionID,
+		Name:           n.Name,
+		Type:           n.Type,
+		Server:         n.Server,
+		Port:           n.Port,
+		Config:         string(config),
+		Enabled:        true,
+	}, nil
+}
+
+// ParseLink parses a single share link into a node
+func ParseLink(link string) (*Node, error) {
+	link = strings.TrimSpace(link)
+	scheme, _, ok := strings.Cut(link, "://")
+	if !ok {
+		return nil, fmt.Errorf("not a share link")
+	}
+
+	var (
+		node *Node
+		err  error
+	)
+	switch strings.ToLower(scheme) {
+	case "ss":
+		node, err = parseShadowsocks(link)
+	case "vmess":
+		node, err = parseVMess(link)
+	case "vless":
+		node, err = parseVLESS(link)
+	case "trojan":
+		node, err = parseTrojan(link)
+	case "hysteria2", "hy2":
+		node, err = parseHysteria2(link)
+	case "tuic":
+		node, err = parseTUIC(link)
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", scheme, err)
+	}
+
+	if node.Name == "" {
+		node.Name = net.JoinHostPort(node.Server, strconv.Itoa(node.Port))
+	}
+	return node, nil
+}
+
+// LinkError records a line that could not be parsed
+type LinkError struct {
+	Line   int    `json:"line"`
+	Link   string `json:"link"`
+	Reason string `json:"reason"`
+}
+
+// ParseLinks parses one share link per line. Blank lines and comments are ignored.
+func ParseLinks(text string) ([]*Node, []LinkError) {
+	var nodes []*Node
+	var failed []LinkError
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		node, err := ParseLink(line)
+		if err != nil {
+			failed = append(failed, LinkError{Line: lineNo, Link: line, Reason: err.Error()})
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, failed
+}
+
+// parseHostPort splits an URL host into server and port
+func parseHostPort(u *url.URL) (string, int, error) {
+	server := u.Hostname()
+	if server == "" {
+		return "", 0, fmt.Errorf("missing server")
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", u.Port())
+	}
+	return server, port, nil
+}
+
+// decodeBase64 accepts standard and URL-safe alphabets with or without padding
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, "=")
+	if data, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return data, nil
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func fragmentName(u *url.URL) string {
+	if u.Fragment == "" {
+		return ""
+	}
+	return strings.TrimSpace(u.Fragment)
+}
+
+func isTrue(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+func splitList(v string) []string {
+	var result []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// parseShadowsocks handles SIP002 links (ss://userinfo@host:port/?plugin=...#name)
+// and the legacy form ss://base64(method:password@host:port)#name
+func parseShadowsocks(link string) (*Node, error) {
+	body := strings.TrimPrefix(link, "ss://")
+	name := ""
+	if i := strings.Index(body, "#"); i >= 0 {
+		name, _ = url.PathUnescape(body[i+1:])
+		body = body[:i]
+	}
+
+	// Legacy links encode everything but the fragment
+	if !strings.Contains(body, "@") {
+		main, query, _ := strings.Cut(body, "?")
+		decoded, err := decodeBase64(main)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 body")
+		}
+		body = string(decoded)
+		if query != "" {
+			body += "?" + query
+		}
+	}
+
+	u, err := url.Parse("ss://" + body)
+	if err != nil {
+		return nil, err
+	}
+	server, port, err := parseHostPort(u)
+	if err != nil {
+		return nil, err
+	}
+
+	var method, password string
+	if u.User == nil {
+		return nil, fmt.Errorf("missing user info")
+	}
+	if pass, ok := u.User.Password(); ok {
+		method, password = u.User.Username(), pass
+	} else {
+		decoded, err := decodeBase64(u.User.Username())
+		if err != nil {
+			return nil, fmt.Errorf("invalid user info")
+		}
+		var found bool
+		method, password, found = strings.Cut(string(decoded), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid user info")
+		}
+	}
+	if method == "" {
+		return nil, fmt.Errorf("missing method")
+	}
+
+	options := map[string]interface{}{
+		"method":   method,
+		"password": password,
+	}
+
+	if plugin := u.Query().Get("plugin"); plugin != "" {
+		name, opts, _ := strings.Cut(plugin, ";")
+		options["plugin"] = normalizePlugin(name)
+		if opts != "" {
+			options["plugin_opts"] = opts
+		}
+	}
+
+	if name == "" {
+		name = fragmentName(u)
+	}
+	return &Node{Name: name, Type: "shadowsocks", Server: server, Port: port, Options: options}, nil
+}
+
+// normalizePlugin maps SIP003 plugin names onto the ones sing-box understands
+func normalizePlugin(name string) string {
+	switch name {
+	case "simple-obfs", "obfs":
+		return "obfs-local"
+	}
+	return name
+}
@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// groupOutboundTypes are sing-box outbounds that do not describe a remote node
+var groupOutboundTypes = map[string]bool{
+	"direct":   true,
+	"block":    true,
+	"dns":      true,
+	"selector": true,
+	"urltest":  true,
+}
+
+// ParseSingbox reads the outbounds of a sing-box JSON config
+func ParseSingbox(content []byte) ([]*Node, []SkippedProxy, error) {
+	var cfg struct {
+		Outbounds []map[string]interface{} `json:"outbounds"`
+	}
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid sing-box config: %w", err)
+	}
+
+	var nodes []*Node
+	var skipped []SkippedProxy
+	for _, out := range cfg.Outbounds {
+		outType, _ := out["type"].(string)
+		tag, _ := out["tag"].(string)
+		if groupOutboundTypes[outType] {
+			continue
+		}
+
+		server, _ := out["server"].(string)
+		port, _ := out["server_port"].(float64)
+		if server == "" || port <= 0 || port > 65535 {
+			skipped = append(skipped, SkippedProxy{Name: tag, Type: outType, Reason: "missing server or port"})
+			continue
+		}
+
+		options := make(map[string]interface{}, len(out))
+		for k, v := range out {
+			switch k {
+			case "type", "tag", "server", "server_port", "detour":
+				continue
+			}
+			options[k] = v
+		}
+
+		name := tag
+		if name == "" {
+			name = fmt.Sprintf("%s:%d", server, int(port))
+		}
+		nodes = append(nodes, &Node{Name: name, Type: outType, Server: server, Port: int(port), Options: options})
+	}
+	return nodes, skipped, nil
+}
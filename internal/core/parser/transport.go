@@ -0,0 +1,191 @@
+package parser
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TLSParams is the TLS related part of a share link
+type TLSParams struct {
+	Security    string // tls/reality/none
+	SNI         string
+	ALPN        []string
+	Fingerprint string
+	Insecure    bool
+	PublicKey   string // reality
+	ShortID     string // reality
+}
+
+// TransportParams is the V2Ray transport part of a share link
+type TransportParams struct {
+	Network     string // tcp/ws/grpc/http/h2/httpupgrade
+	HeaderType  string
+	Host        string
+	Path        string
+	ServiceName string
+}
+
+// buildTLS renders TLSParams into a sing-box outbound tls object, or nil when TLS is off
+func buildTLS(p TLSParams, server string) map[string]interface{} {
+	security := strings.ToLower(p.Security)
+	if security == "" || security == "none" {
+		return nil
+	}
+
+	tls := map[string]interface{}{"enabled": true}
+	sni := p.SNI
+	if sni == "" && !isIPAddress(server) {
+		sni = server
+	}
+	if sni != "" {
+		tls["server_name"] = sni
+	}
+	if p.Insecure {
+		tls["insecure"] = true
+	}
+	if len(p.ALPN) > 0 {
+		tls["alpn"] = p.ALPN
+	}
+
+	fingerprint := p.Fingerprint
+	if security == "reality" {
+		reality := map[string]interface{}{
+			"enabled":    true,
+			"public_key": p.PublicKey,
+		}
+		if p.ShortID != "" {
+			reality["short_id"] = p.ShortID
+		}
+		tls["reality"] = reality
+		// uTLS is mandatory for reality
+		if fingerprint == "" {
+			fingerprint = "chrome"
+		}
+	}
+	if fingerprint != "" && fingerprint != "none" {
+		tls["utls"] = map[string]interface{}{
+			"enabled":     true,
+			"fingerprint": fingerprint,
+		}
+	}
+	return tls
+}
+
+// buildTransport renders TransportParams into a sing-box transport object, or nil for plain TCP
+func buildTransport(p TransportParams) map[string]interface{} {
+	switch strings.ToLower(p.Network) {
+	case "ws", "websocket":
+		transport := map[string]interface{}{"type": "ws"}
+		path := p.Path
+		// Xray style early data: /path?ed=2048
+		if base, query, ok := strings.Cut(path, "?"); ok {
+			if values, err := url.ParseQuery(query); err == nil {
+				if ed, err := strconv.Atoi(values.Get("ed")); err == nil && ed > 0 {
+					transport["max_early_data"] = ed
+					transport["early_data_header_name"] = "Sec-WebSocket-Protocol"
+					path = base
+				}
+			}
+		}
+		if path != "" {
+			transport["path"] = path
+		}
+		if p.Host != "" {
+			transport["headers"] = map[string]interface{}{"Host": p.Host}
+		}
+		return transport
+	case "grpc":
+		transport := map[string]interface{}{"type": "grpc"}
+		serviceName := p.ServiceName
+		if serviceName == "" {
+			serviceName = p.Path
+		}
+		if serviceName != "" {
+			transport["service_name"] = serviceName
+		}
+		return transport
+	case "http", "h2":
+		transport := map[string]interface{}{"type": "http"}
+		if p.Host != "" {
+			transport["host"] = splitList(p.Host)
+		}
+		if p.Path != "" {
+			transport["path"] = p.Path
+		}
+		return transport
+	case "httpupgrade":
+		transport := map[string]interface{}{"type": "httpupgrade"}
+		if p.Host != "" {
+			transport["host"] = p.Host
+		}
+		if p.Path != "" {
+			transport["path"] = p.Path
+		}
+		return transport
+	case "tcp", "":
+		// tcp with HTTP header obfuscation maps to the http transport
+		if strings.ToLower(p.HeaderType) == "http" {
+			transport := map[string]interface{}{"type": "http", "method": "GET"}
+			if p.Host != "" {
+				transport["host"] = splitList(p.Host)
+			}
+			if p.Path != "" {
+				transport["path"] = p.Path
+			}
+			return transport
+		}
+	}
+	return nil
+}
+
+// tlsFromQuery reads the common TLS query parameters used by vless/trojan links
+func tlsFromQuery(q url.Values, defaultSecurity string) TLSParams {
+	security := q.Get("security")
+	if security == "" {
+		security = defaultSecurity
+	}
+	return TLSParams{
+		Security:    security,
+		SNI:         firstNonEmpty(q.Get("sni"), q.Get("peer")),
+		ALPN:        splitList(q.Get("alpn")),
+		Fingerprint: q.Get("fp"),
+		Insecure:    isTrue(q.Get("allowInsecure")) || isTrue(q.Get("insecure")),
+		PublicKey:   q.Get("pbk"),
+		ShortID:     q.Get("sid"),
+	}
+}
+
+// transportFromQuery reads the common transport query parameters used by vless/trojan links
+func transportFromQuery(q url.Values) TransportParams {
+	return TransportParams{
+		Network:     q.Get("type"),
+		HeaderType:  q.Get("headerType"),
+		Host:        q.Get("host"),
+		Path:        q.Get("path"),
+		ServiceName: q.Get("serviceName"),
+	}
+}
+
+func applyTLSAndTransport(options map[string]interface{}, tls, transport map[string]interface{}) {
+	if tls != nil {
+		options["tls"] = tls
+	}
+	if transport != nil {
+		options["transport"] = transport
+	}
+}
+
+func isIPAddress(host string) bool {
+	return net.ParseIP(strings.Trim(host, "[]")) != nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
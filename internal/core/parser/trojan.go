@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+)
+
+func parseTrojan(link string) (*Node, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	server, port, err := parseHostPort(u)
+	if err != nil {
+		return nil, err
+	}
+	// The password may itself contain a colon, which url.Parse splits off
+	password := ""
+	if u.User != nil {
+		password = u.User.Username()
+		if pass, ok := u.User.Password(); ok {
+			password += ":" + pass
+		}
+	}
+	if password == "" {
+		return nil, fmt.Errorf("missing password")
+	}
+
+	q := u.Query()
+	options := map[string]interface{}{
+		"password": password,
+	}
+
+	// Trojan always runs over TLS unless the link says otherwise
+	tls := buildTLS(tlsFromQuery(q, "tls"), server)
+	transport := buildTransport(transportFromQuery(q))
+	applyTLSAndTransport(options, tls, transport)
+
+	return &Node{Name: fragmentName(u), Type: "trojan", Server: server, Port: port, Options: options}, nil
+}
@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+)
+
+func parseTUIC(link string) (*Node, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	server, port, err := parseHostPort(u)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("missing uuid")
+	}
+	password, _ := u.User.Password()
+
+	q := u.Query()
+	options := map[string]interface{}{
+		"uuid":     u.User.Username(),
+		"password": password,
+	}
+	if cc := firstNonEmpty(q.Get("congestion_control"), q.Get("congestion-control")); cc != "" {
+		options["congestion_control"] = cc
+	}
+	if mode := firstNonEmpty(q.Get("udp_relay_mode"), q.Get("udp-relay-mode")); mode != "" {
+		options["udp_relay_mode"] = mode
+	}
+	if isTrue(q.Get("reduce_rtt")) {
+		options["zero_rtt_handshake"] = true
+	}
+
+	tls := buildTLS(TLSParams{
+		Security: "tls",
+		SNI:      q.Get("sni"),
+		ALPN:     splitList(q.Get("alpn")),
+		Insecure: isTrue(q.Get("allow_insecure")) || isTrue(q.Get("insecure")),
+	}, server)
+	if tls["alpn"] == nil {
+		tls["alpn"] = []string{"h3"}
+	}
+	if isTrue(q.Get("disable_sni")) {
+		delete(tls, "server_name")
+	}
+	options["tls"] = tls
+
+	return &Node{Name: fragmentName(u), Type: "tuic", Server: server, Port: port, Options: options}, nil
+}
@@ -0,0 +1,184 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type v2rayConfig struct {
+	Outbounds []v2rayOutbound `json:"outbounds"`
+}
+
+type v2rayOutbound struct {
+	Tag      string `json:"tag"`
+	Protocol string `json:"protocol"`
+	Settings struct {
+		Vnext []struct {
+			Address string `json:"address"`
+			Port    int    `json:"port"`
+			Users   []struct {
+				ID       string `json:"id"`
+				AlterID  int    `json:"alterId"`
+				Security string `json:"security"`
+				Flow     string `json:"flow"`
+			} `json:"users"`
+		} `json:"vnext"`
+		Servers []struct {
+			Address  string `json:"address"`
+			Port     int    `json:"port"`
+			Method   string `json:"method"`
+			Password string `json:"password"`
+		} `json:"servers"`
+	} `json:"settings"`
+	StreamSettings struct {
+		Network     string `json:"network"`
+		Security    string `json:"security"`
+		TLSSettings struct {
+			ServerName    string   `json:"serverName"`
+			AllowInsecure bool     `json:"allowInsecure"`
+			Fingerprint   string   `json:"fingerprint"`
+			ALPN          []string `json:"alpn"`
+		} `json:"tlsSettings"`
+		RealitySettings struct {
+			ServerName  string `json:"serverName"`
+			Fingerprint string `json:"fingerprint"`
+			PublicKey   string `json:"publicKey"`
+			ShortID     string `json:"shortId"`
+		} `json:"realitySettings"`
+		WSSettings struct {
+			Path    string            `json:"path"`
+			Headers map[string]string `json:"headers"`
+			Host    string            `json:"host"`
+		} `json:"wsSettings"`
+		GRPCSettings struct {
+			ServiceName string `json:"serviceName"`
+		} `json:"grpcSettings"`
+		HTTPUpgradeSettings struct {
+			Path string `json:"path"`
+			Host string `json:"host"`
+		} `json:"httpupgradeSettings"`
+		HTTPSettings struct {
+			Path string   `json:"path"`
+			Host []string `json:"host"`
+		} `json:"httpSettings"`
+	} `json:"streamSettings"`
+}
+
+// ParseV2Ray reads the outbounds of a V2Ray/Xray JSON config,
+// or of an array of such configs as some providers publish
+func ParseV2Ray(content []byte) ([]*Node, []SkippedProxy, error) {
+	var configs []v2rayConfig
+	trimmed := strings.TrimSpace(string(content))
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &configs); err != nil {
+			return nil, nil, fmt.Errorf("invalid v2ray config: %w", err)
+		}
+	} else {
+		var cfg v2rayConfig
+		if err := json.Unmarshal([]byte(trimmed), &cfg); err != nil {
+			return nil, nil, fmt.Errorf("invalid v2ray config: %w", err)
+		}
+		configs = append(configs, cfg)
+	}
+
+	var nodes []*Node
+	var skipped []SkippedProxy
+	for _, cfg := range configs {
+		for _, out := range cfg.Outbounds {
+			switch out.Protocol {
+			case "freedom", "blackhole", "dns":
+				continue
+			}
+			node, err := out.toNode()
+			if err != nil {
+				skipped = append(skipped, SkippedProxy{Name: out.Tag, Type: out.Protocol, Reason: err.Error()})
+				continue
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, skipped, nil
+}
+
+func (o *v2rayOutbound) toNode() (*Node, error) {
+	node := &Node{Name: o.Tag, Options: map[string]interface{}{}}
+
+	switch o.Protocol {
+	case "vmess", "vless":
+		if len(o.Settings.Vnext) == 0 || len(o.Settings.Vnext[0].Users) == 0 {
+			return nil, fmt.Errorf("missing vnext user")
+		}
+		server := o.Settings.Vnext[0]
+		user := server.Users[0]
+		node.Type = o.Protocol
+		node.Server, node.Port = server.Address, server.Port
+		node.Options["uuid"] = user.ID
+		if o.Protocol == "vmess" {
+			security := user.Security
+			if security == "" {
+				security = "auto"
+			}
+			node.Options["security"] = security
+			node.Options["alter_id"] = user.AlterID
+		} else if user.Flow != "" {
+			node.Options["flow"] = user.Flow
+		}
+	case "trojan", "shadowsocks":
+		if len(o.Settings.Servers) == 0 {
+			return nil, fmt.Errorf("missing server")
+		}
+		server := o.Settings.Servers[0]
+		node.Type = o.Protocol
+		node.Server, node.Port = server.Address, server.Port
+		node.Options["password"] = server.Password
+		if o.Protocol == "shadowsocks" {
+			node.Options["method"] = server.Method
+		}
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", o.Protocol)
+	}
+
+	if node.Server == "" || node.Port <= 0 || node.Port > 65535 {
+		return nil, fmt.Errorf("missing server or port")
+	}
+	if node.Name == "" {
+		node.Name = fmt.Sprintf("%s:%d", node.Server, node.Port)
+	}
+
+	stream := &o.StreamSettings
+	tlsParams := TLSParams{Security: stream.Security}
+	switch stream.Security {
+	case "tls":
+		tlsParams.SNI = stream.TLSSettings.ServerName
+		tlsParams.Insecure = stream.TLSSettings.AllowInsecure
+		tlsParams.Fingerprint = stream.TLSSettings.Fingerprint
+		tlsParams.ALPN = stream.TLSSettings.ALPN
+	case "reality":
+		tlsParams.SNI = stream.RealitySettings.ServerName
+		tlsParams.Fingerprint = stream.RealitySettings.Fingerprint
+		tlsParams.PublicKey = stream.RealitySettings.PublicKey
+		tlsParams.ShortID = stream.RealitySettings.ShortID
+	}
+
+	transportParams := TransportParams{Network: stream.Network}
+	switch stream.Network {
+	case "ws":
+		transportParams.Path = stream.WSSettings.Path
+		transportParams.Host = firstNonEmpty(stream.WSSettings.Host, stream.WSSettings.Headers["Host"])
+	case "grpc":
+		transportParams.ServiceName = stream.GRPCSettings.ServiceName
+	case "httpupgrade":
+		transportParams.Path = stream.HTTPUpgradeSettings.Path
+		transportParams.Host = stream.HTTPUpgradeSettings.Host
+	case "http", "h2":
+		transportParams.Path = stream.HTTPSettings.Path
+		transportParams.Host = strings.Join(stream.HTTPSettings.Host, ",")
+	case "", "tcp":
+	default:
+		return nil, fmt.Errorf("network %q is not supported", stream.Network)
+	}
+
+	applyTLSAndTransport(node.Options, buildTLS(tlsParams, node.Server), buildTransport(transportParams))
+	return node, nil
+}
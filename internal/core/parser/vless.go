@@ -0,0 +1,37 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+)
+
+func parseVLESS(link string) (*Node, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	server, port, err := parseHostPort(u)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("missing uuid")
+	}
+
+	q := u.Query()
+	options := map[string]interface{}{
+		"uuid": u.User.Username(),
+	}
+	if flow := q.Get("flow"); flow != "" {
+		options["flow"] = flow
+	}
+	if q.Get("packetEncoding") != "" {
+		options["packet_encoding"] = q.Get("packetEncoding")
+	}
+
+	tls := buildTLS(tlsFromQuery(q, "none"), server)
+	transport := buildTransport(transportFromQuery(q))
+	applyTLSAndTransport(options, tls, transport)
+
+	return &Node{Name: fragmentName(u), Type: "vless", Server: server, Port: port, Options: options}, nil
+}
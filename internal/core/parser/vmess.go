@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// vmessLink is the base64 JSON payload of a v2rayN style vmess:// link.
+// Numbers are sometimes sent as strings, so loose fields use json.RawMessage.
+type vmessLink struct {
+	PS   string          `json:"ps"`
+	Add  string          `json:"add"`
+	Port json.RawMessage `json:"port"`
+	ID   string          `json:"id"`
+	Aid  json.RawMessage `json:"aid"`
+	Scy  string          `json:"scy"`
+	Net  string          `json:"net"`
+	Type string          `json:"type"`
+	Host string          `json:"host"`
+	Path string          `json:"path"`
+	TLS  string          `json:"tls"`
+	SNI  string          `json:"sni"`
+	ALPN string          `json:"alpn"`
+	FP   string          `json:"fp"`
+}
+
+func parseVMess(link string) (*Node, error) {
+	payload := strings.TrimPrefix(link, "vmess://")
+	if i := strings.Index(payload, "#"); i >= 0 {
+		payload = payload[:i]
+	}
+	data, err := decodeBase64(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 payload")
+	}
+
+	var v vmessLink
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %w", err)
+	}
+
+	port, err := looseInt(v.Port)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port")
+	}
+	if v.Add == "" {
+		return nil, fmt.Errorf("missing server")
+	}
+	if v.ID == "" {
+		return nil, fmt.Errorf("missing uuid")
+	}
+
+	security := v.Scy
+	if security == "" {
+		security = "auto"
+	}
+	alterID, _ := looseInt(v.Aid)
+
+	options := map[string]interface{}{
+		"uuid":     v.ID,
+		"security": security,
+		"alter_id": alterID,
+	}
+
+	tls := buildTLS(TLSParams{
+		Security:    v.TLS,
+		SNI:         v.SNI,
+		ALPN:        splitList(v.ALPN),
+		Fingerprint: v.FP,
+	}, v.Add)
+	transport := buildTransport(TransportParams{
+		Network:    v.Net,
+		HeaderType: v.Type,
+		Host:       v.Host,
+		Path:       v.Path,
+	})
+	applyTLSAndTransport(options, tls, transport)
+
+	return &Node{Name: v.PS, Type: "vmess", Server: v.Add, Port: port, Options: options}, nil
+}
+
+func looseInt(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 {
+		return 0, fmt.Errorf("empty")
+	}
+	var n int
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, err
+	}
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
@@ -0,0 +1,325 @@
+// Package router evaluates routing rules offline to explain where a connection would go.
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"singbox.arrow.web2/internal/core/generator"
+	"singbox.arrow.web2/internal/core/ruleset"
+	"singbox.arrow.web2/internal/core/ruleset/source"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// Connection is the hypothetical connection to route
+type Connection struct {
+	Domain     string `json:"domain"`
+	IP         string `json:"ip"`
+	Port       int    `json:"port"`
+	Network    string `json:"network"`  // tcp/udp, default tcp
+	Protocol   string `json:"protocol"` // sniffed protocol such as tls, http, quic or dns
+	Inbound    string `json:"inbound"`
+	SourceIP   string `json:"source_ip"`
+	SourcePort int    `json:"source_port"`
+}
+
+// RuleTrace records how a single rule was evaluated
+type RuleTrace struct {
+	RuleID   uint   `json:"rule_id"`
+	Priority int    `json:"priority"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Action   string `json:"action"`
+	Outbound string `json:"outbound,omitempty"`
+	Matched  bool   `json:"matched"`
+	Note     string `json:"note,omitempty"`
+}
+
+type Result struct {
+	Connection Connection `json:"connection"`
+	// Matched is the rule that decided the route, nil when route.final applies
+	Matched         *RuleTrace  `json:"matched"`
+	Action          string      `json:"action"`
+	Outbound        string      `json:"outbound,omitempty"`
+	OutboundMissing bool        `json:"outbound_missing"`
+	Final           bool        `json:"final"`
+	GroupType       string      `json:"group_type,omitempty"`
+	GroupMembers    []string    `json:"group_members,omitempty"`
+	Checked         []RuleTrace `json:"checked"`
+	Warnings        []string    `json:"warnings"`
+}
+
+// InputError reports an invalid connection description
+type InputError struct {
+	Message string
+}
+
+func (e *InputError) Error() string {
+	return e.Message
+}
+
+// routeRule is a rendered route rule: the headless fields plus the route-only ones
+type routeRule struct {
+	source.HeadlessRule
+	Inbound     source.Listable[string] `json:"inbound,omitempty"`
+	Protocol    source.Listable[string] `json:"protocol,omitempty"`
+	RuleSet     source.Listable[string] `json:"rule_set,omitempty"`
+	IPIsPrivate bool                    `json:"ip_is_private,omitempty"`
+	Rules       []routeRule             `json:"rules,omitempty"`
+	Invert      bool                    `json:"invert,omitempty"`
+	Action      string                  `json:"action,omitempty"`
+	Outbound    string                  `json:"outbound,omitempty"`
+}
+
+type simulation struct {
+	meta     source.Metadata
+	conn     Connection
+	warnings []string
+	warned   map[string]bool
+}
+
+// Simulate evaluates the enabled rules in priority order against conn, the same
+// order and fallbacks the generator uses, without contacting sing-box
+func Simulate(conn Connection) (*Result, error) {
+	sim, err := newSimulation(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []storage.Rule
+	if err := storage.DB.Where("enabled = ?", true).Order("priority, id").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+	known, err := generator.OutboundTags()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Connection: sim.conn, Checked: []RuleTrace{}}
+	for _, r := range rules {
+		trace := RuleTrace{
+			RuleID:   r.ID,
+			Priority: r.Priority,
+			Type:     r.Type,
+			Value:    r.Value,
+			Action:   generator.RuleAction(&r),
+			Outbound: r.OutboundTag,
+		}
+
+		entry, err := generator.RenderRule(r)
+		if err != nil {
+			trace.Note = "skipped, not in generated config: " + err.Error()
+			result.Checked = append(result.Checked, trace)
+			continue
+		}
+		rule, err := decodeRouteRule(entry)
+		if err != nil {
+			trace.Note = "skipped: " + err.Error()
+			result.Checked = append(result.Checked, trace)
+			continue
+		}
+
+		trace.Matched = sim.match(rule)
+		if !trace.Matched {
+			result.Checked = append(result.Checked, trace)
+			continue
+		}
+
+		// sniff only inspects the connection, evaluation continues with the next rule
+		if trace.Action == generator.ActionSniff {
+			trace.Note = "sniff is not final, evaluation continues"
+			result.Checked = append(result.Checked, trace)
+			continue
+		}
+
+		result.Checked = append(result.Checked, trace)
+		matched := trace
+		result.Matched = &matched
+		result.Action = trace.Action
+		if trace.Action == generator.ActionRoute {
+			result.Outbound, result.OutboundMissing = generator.ResolveOutbound(r.OutboundTag, known)
+		}
+		break
+	}
+
+	if result.Matched == nil {
+		result.Action = generator.ActionRoute
+		result.Outbound = generator.FinalOutbound(known)
+		result.Final = true
+	}
+	if result.Outbound != "" {
+		describeGroup(result)
+	}
+
+	result.Warnings = sim.warnings
+	if result.Warnings == nil {
+		result.Warnings = []string{}
+	}
+	return result, nil
+}
+
+func newSimulation(conn Connection) (*simulation, error) {
+	sim := &simulation{warned: make(map[string]bool)}
+
+	conn.Domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(conn.Domain), "."))
+	conn.Network = strings.ToLower(conn.Network)
+	if conn.Network == "" {
+		conn.Network = "tcp"
+	}
+	if conn.Network != "tcp" && conn.Network != "udp" {
+		return nil, &InputError{Message: "network must be tcp or udp"}
+	}
+	if conn.Port < 0 || conn.Port > 65535 || conn.SourcePort < 0 || conn.SourcePort > 65535 {
+		return nil, &InputError{Message: "invalid port"}
+	}
+
+	// A literal IP given as the domain is treated as the destination IP
+	if conn.IP == "" {
+		if addr, err := netip.ParseAddr(conn.Domain); err == nil {
+			conn.IP = addr.String()
+			conn.Domain = ""
+		}
+	}
+	if conn.Domain == "" && conn.IP == "" {
+		return nil, &InputError{Message: "domain or ip is required"}
+	}
+
+	sim.meta = source.Metadata{
+		Domain:     conn.Domain,
+		Port:       uint16(conn.Port),
+		Network:    conn.Network,
+		SourcePort: uint16(conn.SourcePort),
+	}
+	if conn.IP != "" {
+		addr, err := netip.ParseAddr(conn.IP)
+		if err != nil {
+			return nil, &InputError{Message: fmt.Sprintf("invalid ip %q", conn.IP)}
+		}
+		sim.meta.IP = addr.Unmap()
+	} else {
+		sim.warn("no destination IP given, ip_cidr and geoip conditions cannot match")
+	}
+	if conn.SourceIP != "" {
+		addr, err := netip.ParseAddr(conn.SourceIP)
+		if err != nil {
+			return nil, &InputError{Message: fmt.Sprintf("invalid source_ip %q", conn.SourceIP)}
+		}
+		sim.meta.SourceIP = addr.Unmap()
+	}
+
+	sim.conn = conn
+	return sim, nil
+}
+
+func decodeRouteRule(entry map[string]interface{}) (*routeRule, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	var rule routeRule
+	if err := json.Unmarshal(data, &rule); err != nil {
+		return nil, err
+	}
+	if err := rule.compile(); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *routeRule) compile() error {
+	// Inversion is applied by the route rule so the route-only items are inverted too
+	r.HeadlessRule.Invert = false
+	r.HeadlessRule.Rules = nil
+	if r.IsLogical() {
+		for i := range r.Rules {
+			if err := r.Rules[i].compile(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return r.HeadlessRule.Compile()
+}
+
+func (s *simulation) match(r *routeRule) bool {
+	if r.IsLogical() {
+		matched := r.Mode == "and"
+		for i := range r.Rules {
+			sub := s.match(&r.Rules[i])
+			if r.Mode == "and" && !sub {
+				matched = false
+				break
+			}
+			if r.Mode == "or" && sub {
+				matched = true
+				break
+			}
+		}
+		return matched != r.Invert
+	}
+
+	matched := true
+	if len(r.Inbound) > 0 && !contains(r.Inbound, s.conn.Inbound) {
+		matched = false
+	}
+	if len(r.Protocol) > 0 && !contains(r.Protocol, s.conn.Protocol) {
+		matched = false
+	}
+	if matched {
+		var extra []bool
+		if r.IPIsPrivate {
+			extra = append(extra, isPrivate(s.meta.IP))
+		}
+		for _, tag := range r.RuleSet {
+			extra = append(extra, s.matchRuleSet(tag))
+		}
+		matched = r.HeadlessRule.MatchWith(&s.meta, extra)
+	}
+	return matched != r.Invert
+}
+
+func (s *simulation) matchRuleSet(tag string) bool {
+	rs, err := ruleset.Load(tag)
+	if err != nil {
+		s.warn(err.Error())
+		return false
+	}
+	return rs.Match(&s.meta)
+}
+
+func (s *simulation) warn(msg string) {
+	if !s.warned[msg] {
+		s.warned[msg] = true
+		s.warnings = append(s.warnings, msg)
+	}
+}
+
+// describeGroup adds the group type and members when the outbound is a group
+func describeGroup(result *Result) {
+	var group storage.OutboundGroup
+	if err := storage.DB.Where("tag = ? AND enabled = ?", result.Outbound, true).First(&group).Error; err != nil {
+		return
+	}
+	members, err := generator.GroupMembers()
+	if err != nil {
+		return
+	}
+	result.GroupType = group.Type
+	result.GroupMembers = members[group.ID]
+}
+
+func isPrivate(addr netip.Addr) bool {
+	return addr.IsValid() && (addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsUnspecified())
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
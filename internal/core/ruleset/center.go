@@ -0,0 +1,169 @@
+package ruleset
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"singbox.arrow.web2/configs"
+)
+
+// CenterFile is an optional catalog in the data directory, its categories and
+// entries are added to the built-in ones and replace entries with the same id
+const CenterFile = "ruleset-center.json"
+
+// Catalog lists the rule sets the rule center offers
+type Catalog struct {
+	Categories []Category `json:"categories"`
+}
+
+type Category struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Entries     []Entry `json:"entries"`
+}
+
+// Entry is a remote rule set that can be added in one step. ID is unique across
+// the catalog, Name becomes the rule set name.
+type Entry struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Format      string   `json:"format"`
+	URL         string   `json:"url"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// LoadCatalog returns the built-in catalog merged with the one in the data
+// directory. A broken custom catalog is reported as err alongside the built-in one.
+func LoadCatalog() (*Catalog, error) {
+	var catalog Catalog
+	if err := json.Unmarshal(configs.RulesetCenter, &catalog); err != nil {
+		return nil, fmt.Errorf("built-in catalog: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dataDir, CenterFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &catalog, nil
+	}
+	if err != nil {
+		return &catalog, fmt.Errorf("custom catalog: %w", err)
+	}
+	var custom Catalog
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return &catalog, fmt.Errorf("custom catalog %s: %w", CenterFile, err)
+	}
+	if err := custom.validate(); err != nil {
+		return &catalog, fmt.Errorf("custom catalog %s: %w", CenterFile, err)
+	}
+	catalog.merge(&custom)
+	return &catalog, nil
+}
+
+func (c *Catalog) validate() error {
+	for _, category := range c.Categories {
+		if category.ID == "" {
+			return errors.New("category without id")
+		}
+		for _, entry := range category.Entries {
+			if entry.ID == "" || entry.URL == "" {
+				return fmt.Errorf("category %s: entries need an id and url", category.ID)
+			}
+			if entry.Format != "source" && entry.Format != "binary" {
+				return fmt.Errorf("entry %s: format must be source or binary", entry.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// merge adds the categories and entries of other, an entry replaces one with the same id
+func (c *Catalog) merge(other *Catalog) {
+	for _, category := range other.Categories {
+		for _, entry := range category.Entries {
+			c.remove(entry.ID)
+		}
+		if existing := c.category(category.ID); existing != nil {
+			existing.Entries = append(existing.Entries, category.Entries...)
+			if category.Name != "" {
+				existing.Name = category.Name
+			}
+			if category.Description != "" {
+				existing.Description = category.Description
+			}
+			continue
+		}
+		c.Categories = append(c.Categories, category)
+	}
+}
+
+func (c *Catalog) category(id string) *Category {
+	for i := range c.Categories {
+		if c.Categories[i].ID == id {
+			return &c.Categories[i]
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) remove(id string) {
+	for i := range c.Categories {
+		entries := c.Categories[i].Entries[:0]
+		for _, entry := range c.Categories[i].Entries {
+			if entry.ID != id {
+				entries = append(entries, entry)
+			}
+		}
+		c.Categories[i].Entries = entries
+	}
+}
+
+// Find returns the entry with the given id
+func (c *Catalog) Find(id string) (Entry, bool) {
+	for _, category := range c.Categories {
+		for _, entry := range category.Entries {
+			if entry.ID == id {
+				return entry, true
+			}
+		}
+	}
+	return Entry{}, false
+}
+
+// Filter keeps the given category, or all when empty, and the entries whose id,
+// name, description or tags contain query. Categories left empty are dropped.
+func (c *Catalog) Filter(category, query string) *Catalog {
+	query = strings.ToLower(strings.TrimSpace(query))
+	result := &Catalog{Categories: []Category{}}
+	for _, cat := range c.Categories {
+		if category != "" && cat.ID != category {
+			continue
+		}
+		entries := make([]Entry, 0, len(cat.Entries))
+		for _, entry := range cat.Entries {
+			if query == "" || entry.matches(query) {
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) == 0 && query != "" {
+			continue
+		}
+		cat.Entries = entries
+		result.Categories = append(result.Categories, cat)
+	}
+	return result
+}
+
+func (e *Entry) matches(query string) bool {
+	fields := append([]string{e.ID, e.Name, e.Description}, e.Tags...)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// AllowSuffix is appended to the name of the rule set holding a filter list's exceptions
+const AllowSuffix = "-allow"
+
+// cosmeticMarkers separate the domains of an element hiding, CSS, scriptlet or
+// HTML filtering rule from its selector
+var cosmeticMarkers = []string{"##", "#@#", "#?#", "#@?#", "#$#", "#@$#", "#%#", "#@%#", "$$", "$@$"}
+
+// hostsNames are the local entries found at the top of most hosts files
+var hostsNames = map[string]bool{
+	"localhost": true, "localhost.localdomain": true, "local": true, "broadcasthost": true,
+	"ip6-localhost": true, "ip6-loopback": true, "ip6-localnet": true, "ip6-mcastprefix": true,
+	"ip6-allnodes": true, "ip6-allrouters": true, "ip6-allhosts": true, "0.0.0.0": true,
+}
+
+// AdGuard converts an AdGuard / Adblock filter list or a hosts file into a block
+// rule set named name. Exceptions ("@@||example.com^") go into a separate allow
+// rule set named name+AllowSuffix, which comes first in the results and is set as
+// the block set's Exceptions. Lines that cannot be expressed as domains, such as
+// cosmetic rules, regex and URL rules, are reported on the block set.
+func AdGuard(data []byte, name string) ([]*Result, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	block, allow := newResult(name), newResult(name+AllowSuffix)
+	blockRules, allowRules := newBuilder(), newBuilder()
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") ||
+			(strings.HasPrefix(line, "#") && !isCosmetic(line)) {
+			continue
+		}
+		if isCosmetic(line) {
+			block.skip(line, "cosmetic rule")
+			continue
+		}
+
+		if domains, ok, err := parseHostsLine(line); ok {
+			if err != nil {
+				block.skip(line, err.Error())
+				continue
+			}
+			for _, domain := range domains {
+				if blockRules.add(&blockRules.address.Domain, "domain", domain) {
+					block.Converted++
+				}
+			}
+			continue
+		}
+
+		result, b := block, blockRules
+		rule := line
+		if strings.HasPrefix(rule, "@@") {
+			result, b = allow, allowRules
+			rule = rule[2:]
+		}
+		suffix, domain, err := parseFilterRule(rule)
+		if err != nil {
+			block.skip(line, err.Error())
+			continue
+		}
+		var added bool
+		if suffix {
+			added = b.add(&b.address.DomainSuffix, "suffix", domain)
+		} else {
+			added = b.add(&b.address.Domain, "domain", domain)
+		}
+		if added {
+			result.Converted++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid filter list: %w", err)
+	}
+
+	block.RuleSet.Rules = blockRules.rules()
+	allow.RuleSet.Rules = allowRules.rules()
+	if len(allow.RuleSet.Rules) == 0 {
+		return []*Result{block}, nil
+	}
+	block.Exceptions = allow.Name
+	return []*Result{allow, block}, nil
+}
+
+func isCosmetic(line string) bool {
+	for _, marker := range cosmeticMarkers {
+		if strings.Contains(line, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostsLine handles "0.0.0.0 example.com www.example.com". ok is false when
+// the line does not start with an address. Only entries pointing at an unspecified
+// or loopback address block a domain, anything else is a redirect.
+func parseHostsLine(line string) (domains []string, ok bool, err error) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, false, nil
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return nil, false, nil
+	}
+	if !addr.IsUnspecified() && !addr.IsLoopback() {
+		return nil, true, fmt.Errorf("hosts entry redirects to %s", addr)
+	}
+	for _, name := range fields[1:] {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if hostsNames[name] {
+			continue
+		}
+		if !validDomain(name) {
+			return nil, true, fmt.Errorf("invalid domain %q", name)
+		}
+		domains = append(domains, name)
+	}
+	return domains, true, nil
+}
+
+// parseFilterRule translates a basic rule without the exception marker.
+// "||example.com^" and "example.com^" match the domain and its subdomains,
+// "|example.com^" and a plain "example.com" only the domain itself.
+func parseFilterRule(rule string) (suffix bool, domain string, err error) {
+	if len(rule) > 1 && strings.HasPrefix(rule, "/") && strings.Contains(rule[1:], "/") {
+		return false, "", fmt.Errorf("regex rule")
+	}
+	if pattern, modifiers, ok := strings.Cut(rule, "$"); ok {
+		for _, modifier := range strings.Split(modifiers, ",") {
+			// important only raises the priority over other blocking rules
+			if modifier = strings.TrimSpace(modifier); modifier != "important" {
+				return false, "", fmt.Errorf("unsupported modifier $%s", modifier)
+			}
+		}
+		rule = pattern
+	}
+
+	anchored := false
+	switch {
+	case strings.HasPrefix(rule, "||"):
+		rule, suffix = rule[2:], true
+	case strings.HasPrefix(rule, "|"):
+		rule, anchored = rule[1:], true
+	}
+	separated := false
+	for _, end := range []string{"^|", "^", "|"} {
+		if strings.HasSuffix(rule, end) {
+			rule, separated = strings.TrimSuffix(rule, end), true
+			break
+		}
+	}
+	if !suffix && !anchored && separated {
+		suffix = true
+	}
+
+	// "||*.example.com^" only matches subdomains
+	if suffix && strings.HasPrefix(rule, "*.") {
+		rule = rule[1:]
+	}
+	domain = strings.ToLower(rule)
+	if strings.Contains(domain, "*") {
+		return false, "", fmt.Errorf("wildcard rule")
+	}
+	if strings.ContainsAny(domain, "/:?=&") {
+		return false, "", fmt.Errorf("URL rule, not a domain")
+	}
+	if !validDomain(strings.TrimPrefix(domain, ".")) {
+		return false, "", fmt.Errorf("invalid domain %q", domain)
+	}
+	return suffix, domain, nil
+}
+
+// validDomain accepts ASCII host names, with underscores as found in filter lists
+func validDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"singbox.arrow.web2/internal/core/ruleset/source"
+)
+
+// Clash rule-provider behaviors
+const (
+	BehaviorDomain    = "domain"
+	BehaviorIPCIDR    = "ipcidr"
+	BehaviorClassical = "classical"
+)
+
+type clashProvider struct {
+	Payload []string `yaml:"payload"`
+}
+
+var clashPayloadPattern = regexp.MustCompile(`(?m)^payload\s*:`)
+
+// Clash converts a Clash rule provider, either YAML with a payload list or one
+// entry per line, with the given behavior
+func Clash(data []byte, behavior, name string) (*Result, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if bytes.HasPrefix(data, []byte("MRS")) {
+		return nil, fmt.Errorf("binary mrs rule providers are not supported")
+	}
+
+	var lines []string
+	if clashPayloadPattern.Match(data) {
+		var provider clashProvider
+		if err := yaml.Unmarshal(data, &provider); err != nil {
+			return nil, fmt.Errorf("invalid rule provider: %w", err)
+		}
+		lines = provider.Payload
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("invalid rule provider: %w", err)
+		}
+	}
+
+	result := newResult(name)
+	b := newBuilder()
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		var err error
+		var added bool
+		switch behavior {
+		case BehaviorDomain:
+			added, err = addClashDomain(b, line)
+		case BehaviorIPCIDR:
+			added, err = addCIDR(b, &b.address.IPCIDR, "ip", line)
+		case BehaviorClassical:
+			added, err = addClashRule(b, line)
+		default:
+			return nil, fmt.Errorf("unknown behavior %q", behavior)
+		}
+		if err != nil {
+			result.skip(line, err.Error())
+			continue
+		}
+		if added {
+			result.Converted++
+		}
+	}
+	result.RuleSet.Rules = b.rules()
+	return result, nil
+}
+
+// addClashDomain handles a domain behavior entry: "+.example.com" is the domain
+// and its subdomains, ".example.com" subdomains only, "*" matches one label
+func addClashDomain(b *builder, entry string) (bool, error) {
+	entry = strings.ToLower(strings.Trim(entry, `'"`))
+	switch {
+	case strings.HasPrefix(entry, "+."):
+		return b.add(&b.address.DomainSuffix, "suffix", entry[2:]), nil
+	case strings.HasPrefix(entry, "."):
+		return b.add(&b.address.DomainSuffix, "suffix", entry), nil
+	case strings.Contains(entry, "*"):
+		expr, err := wildcardToRegex(entry)
+		if err != nil {
+			return false, err
+		}
+		return b.add(&b.address.DomainRegex, "regex", expr), nil
+	default:
+		return b.add(&b.address.Domain, "domain", entry), nil
+	}
+}
+
+// addClashRule handles a classical entry such as "DOMAIN-SUFFIX,example.com"
+func addClashRule(b *builder, entry string) (bool, error) {
+	parts := strings.Split(entry, ",")
+	if len(parts) < 2 {
+		return false, fmt.Errorf("invalid rule")
+	}
+	kind := strings.ToUpper(strings.TrimSpace(parts[0]))
+	value := strings.TrimSpace(parts[1])
+	// A trailing policy or option such as no-resolve does not matter in a rule set
+
+	switch kind {
+	case "DOMAIN":
+		return b.add(&b.address.Domain, "domain", strings.ToLower(value)), nil
+	case "DOMAIN-SUFFIX":
+		return b.add(&b.address.DomainSuffix, "suffix", strings.ToLower(value)), nil
+	case "DOMAIN-KEYWORD":
+		return b.add(&b.address.DomainKeyword, "keyword", strings.ToLower(value)), nil
+	case "DOMAIN-REGEX":
+		if _, err := regexp.Compile(value); err != nil {
+			return false, fmt.Errorf("regex not supported by Go: %w", err)
+		}
+		return b.add(&b.address.DomainRegex, "regex", value), nil
+	case "DOMAIN-WILDCARD":
+		expr, err := wildcardToRegex(strings.ToLower(value))
+		if err != nil {
+			return false, err
+		}
+		return b.add(&b.address.DomainRegex, "regex", expr), nil
+	case "IP-CIDR", "IP-CIDR6":
+		return addCIDR(b, &b.address.IPCIDR, "ip", value)
+	case "SRC-IP-CIDR":
+		return addCIDR(b, &b.sourceAddr.SourceIPCIDR, "source_ip", value)
+	case "DST-PORT":
+		return addPort(b, &b.port.Port, &b.port.PortRange, "port", value)
+	case "SRC-PORT":
+		return addPort(b, &b.sourcePort.SourcePort, &b.sourcePort.SourcePortRange, "source_port", value)
+	case "PROCESS-NAME":
+		return b.add(&b.processName.ProcessName, "process_name", value), nil
+	case "PROCESS-PATH":
+		return b.add(&b.processPath.ProcessPath, "process_path", value), nil
+	case "PROCESS-PATH-REGEX":
+		if _, err := regexp.Compile(value); err != nil {
+			return false, fmt.Errorf("regex not supported by Go: %w", err)
+		}
+		return b.add(&b.processRegex.ProcessPathRegex, "process_path_regex", value), nil
+	case "NETWORK":
+		network := strings.ToLower(value)
+		if network != "tcp" && network != "udp" {
+			return false, fmt.Errorf("unknown network %q", value)
+		}
+		return b.add(&b.network.Network, "network", network), nil
+	default:
+		return false, fmt.Errorf("rule type %s has no rule-set equivalent", kind)
+	}
+}
+
+func addCIDR(b *builder, list *source.Listable[string], kind, value string) (bool, error) {
+	prefix, err := source.ParsePrefix(strings.Trim(value, `'"`))
+	if err != nil {
+		return false, err
+	}
+	return b.add(list, kind, prefix.String()), nil
+}
+
+// addPort accepts a port, a "start-end" range or a list separated by "/"
+func addPort(b *builder, ports *source.Listable[uint16], ranges *source.Listable[string], kind, value string) (bool, error) {
+	added := false
+	for _, item := range strings.Split(value, "/") {
+		item = strings.TrimSpace(item)
+		if start, end, ok := strings.Cut(item, "-"); ok {
+			s, err1 := strconv.ParseUint(start, 10, 16)
+			e, err2 := strconv.ParseUint(end, 10, 16)
+			if err1 != nil || err2 != nil || s > e {
+				return false, fmt.Errorf("invalid port range %q", item)
+			}
+			if b.add(ranges, kind+"_range", fmt.Sprintf("%d:%d", s, e)) {
+				added = true
+			}
+			continue
+		}
+		port, err := strconv.ParseUint(item, 10, 16)
+		if err != nil {
+			return false, fmt.Errorf("invalid port %q", item)
+		}
+		if !b.duplicate(kind, item) {
+			*ports = append(*ports, uint16(port))
+			added = true
+		}
+	}
+	return added, nil
+}
@@ -0,0 +1,97 @@
+// Package convert turns V2Ray geosite/geoip databases, Clash rule providers and
+// AdGuard / hosts filter lists into sing-box rule sets. Entries without a sing-box
+// equivalent are reported.
+package convert
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"singbox.arrow.web2/internal/core/ruleset/source"
+)
+
+// Skipped records an entry that could not be translated
+type Skipped struct {
+	Entry  string `json:"entry"`
+	Reason string `json:"reason"`
+}
+
+// Result is a converted rule set
+type Result struct {
+	Name      string
+	RuleSet   *source.PlainRuleSet
+	Converted int
+	Skipped   []Skipped
+	// Exceptions names the result whose matches are exempt from this one
+	Exceptions string
+}
+
+func newResult(name string) *Result {
+	return &Result{Name: name, RuleSet: &source.PlainRuleSet{Version: source.CurrentVersion}}
+}
+
+func (r *Result) skip(entry, reason string) {
+	r.Skipped = append(r.Skipped, Skipped{Entry: entry, Reason: reason})
+}
+
+// builder collects items by kind. Items of different kinds go into separate rules
+// because sing-box ANDs them within one rule, while a rule set ORs its rules.
+type builder struct {
+	address      source.HeadlessRule // domain, suffix, keyword, regex and ip_cidr are ORed
+	sourceAddr   source.HeadlessRule
+	port         source.HeadlessRule
+	sourcePort   source.HeadlessRule
+	processName  source.HeadlessRule
+	processPath  source.HeadlessRule
+	processRegex source.HeadlessRule
+	network      source.HeadlessRule
+	seen         map[string]bool
+}
+
+func newBuilder() *builder {
+	return &builder{seen: make(map[string]bool)}
+}
+
+// add appends value to list unless the same kind and value was added before
+func (b *builder) add(list *source.Listable[string], kind, value string) bool {
+	if b.duplicate(kind, value) {
+		return false
+	}
+	*list = append(*list, value)
+	return true
+}
+
+// duplicate reports whether kind and value were seen before and records them
+func (b *builder) duplicate(kind, value string) bool {
+	key := kind + "\x00" + value
+	if b.seen[key] {
+		return true
+	}
+	b.seen[key] = true
+	return false
+}
+
+func (b *builder) rules() []source.HeadlessRule {
+	var rules []source.HeadlessRule
+	for _, rule := range []source.HeadlessRule{b.address, b.sourceAddr, b.port, b.sourcePort,
+		b.processName, b.processPath, b.processRegex, b.network} {
+		if !rule.IsEmpty() {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// wildcardToRegex translates a Clash wildcard, where * matches one label, to a regex
+func wildcardToRegex(pattern string) (string, error) {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	expr := "^" + strings.Join(parts, `[^.]+`) + "$"
+	if _, err := regexp.Compile(expr); err != nil {
+		return "", fmt.Errorf("invalid wildcard: %w", err)
+	}
+	return expr, nil
+}
@@ -0,0 +1,291 @@
+package convert
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readTestdata reads a fixture. The .dat files were written with the GeoSiteList
+// and GeoIPList messages of v2ray-core v5.22.0 and hold:
+//
+//	geosite.dat GOOGLE: full www.google.com (twice), root google.com, plain goog,
+//	            regex ^ads\d+\.google\.com$, regex ^(?!www)\w+\.gstatic\.com$,
+//	            root google.cn @cn
+//	            CN: root cn, full www.baidu.com @cn
+//	geoip.dat   CN: 223.255.252.0/23, 1.0.1.0/24, 1.0.2.77/24, 2001:250::/30,
+//	            ::ffff:10.0.0.0/104 and a 5 byte address
+//	            LAN: 192.168.0.0/16 with inverse_match
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// assertResult compares the rules of r with want, a JSON list of rules, and the
+// entries r reported as skipped with skipped
+func assertResult(t *testing.T, r *Result, want string, converted int, skipped []string) {
+	t.Helper()
+	got, _ := json.Marshal(r.RuleSet.Rules)
+	var gotRules, wantRules interface{}
+	json.Unmarshal(got, &gotRules)
+	if err := json.Unmarshal([]byte(want), &wantRules); err != nil {
+		t.Fatalf("invalid expected rules: %v", err)
+	}
+	if !reflect.DeepEqual(gotRules, wantRules) {
+		t.Errorf("%s rules = %s\nwant %s", r.Name, got, want)
+	}
+	if r.Converted != converted {
+		t.Errorf("%s converted = %d, want %d", r.Name, r.Converted, converted)
+	}
+	entries := make([]string, len(r.Skipped))
+	for i, s := range r.Skipped {
+		entries[i] = s.Entry
+		if s.Reason == "" {
+			t.Errorf("%s skipped %q without a reason", r.Name, s.Entry)
+		}
+	}
+	if len(entries) != len(skipped) || (len(skipped) > 0 && !reflect.DeepEqual(entries, skipped)) {
+		t.Errorf("%s skipped = %q, want %q", r.Name, entries, skipped)
+	}
+}
+
+func TestCategories(t *testing.T) {
+	sites, err := GeositeCategories(readTestdata(t, "geosite.dat"))
+	if err != nil || !reflect.DeepEqual(sites, []string{"CN", "GOOGLE"}) {
+		t.Errorf("GeositeCategories = %q, %v", sites, err)
+	}
+	ips, err := GeoIPCategories(readTestdata(t, "geoip.dat"))
+	if err != nil || !reflect.DeepEqual(ips, []string{"CN", "LAN"}) {
+		t.Errorf("GeoIPCategories = %q, %v", ips, err)
+	}
+}
+
+func TestGeosite(t *testing.T) {
+	data := readTestdata(t, "geosite.dat")
+	results, err := Geosite(data, []string{"google", "Google@cn", "google@!cn", "cn", "cn@cn"}, "geosite-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsupported := []string{`regexp:^(?!www)\w+\.gstatic\.com$`}
+	tests := []struct {
+		name      string
+		rules     string
+		converted int
+		skipped   []string
+	}{
+		{"geosite-google", `[{"domain":["www.google.com"],"domain_suffix":["google.com","google.cn"],
+			"domain_keyword":["goog"],"domain_regex":["^ads\\d+\\.google\\.com$"]}]`, 5, unsupported},
+		{"geosite-google-cn", `[{"domain_suffix":["google.cn"]}]`, 1, nil},
+		{"geosite-google-not-cn", `[{"domain":["www.google.com"],"domain_suffix":["google.com"],
+			"domain_keyword":["goog"],"domain_regex":["^ads\\d+\\.google\\.com$"]}]`, 4, unsupported},
+		{"geosite-cn", `[{"domain":["www.baidu.com"],"domain_suffix":["cn"]}]`, 2, nil},
+		{"geosite-cn-cn", `[{"domain":["www.baidu.com"]}]`, 1, nil},
+	}
+	if len(results) != len(tests) {
+		t.Fatalf("got %d results, want %d", len(results), len(tests))
+	}
+	for i, tt := range tests {
+		if results[i].Name != tt.name {
+			t.Errorf("result %d name = %q, want %q", i, results[i].Name, tt.name)
+		}
+		assertResult(t, results[i], tt.rules, tt.converted, tt.skipped)
+	}
+}
+
+func TestGeoIP(t *testing.T) {
+	data := readTestdata(t, "geoip.dat")
+	results, err := GeoIP(data, []string{"cn", "LAN", "cn"}, "geoip-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Name != "geoip-cn" || results[1].Name != "geoip-lan" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	// Host bits are masked and IPv4-mapped prefixes unmapped
+	assertResult(t, results[0], `[{"ip_cidr":["1.0.1.0/24","1.0.2.0/24","10.0.0.0/8","2001:250::/30","223.255.252.0/23"]}]`,
+		5, []string{"0a0501020304051008"})
+	assertResult(t, results[1], `[{"ip_cidr":["192.168.0.0/16"],"invert":true}]`, 1, nil)
+}
+
+func TestGeoErrors(t *testing.T) {
+	site, ip := readTestdata(t, "geosite.dat"), readTestdata(t, "geoip.dat")
+	tests := []struct {
+		name string
+		fn   func() error
+		want string
+	}{
+		{"geosite missing category", func() error { _, err := Geosite(site, []string{"google", "nope"}, ""); return err }, `category "nope" not found`},
+		{"geosite no category", func() error { _, err := Geosite(site, []string{" "}, ""); return err }, "no categories selected"},
+		{"geosite invalid data", func() error { _, err := Geosite([]byte{0x0a, 0x05, 0x01}, []string{"cn"}, ""); return err }, "invalid protobuf data"},
+		{"geoip missing category", func() error { _, err := GeoIP(ip, []string{"us"}, ""); return err }, `category "us" not found`},
+		{"geoip no category", func() error { _, err := GeoIP(ip, nil, ""); return err }, "no categories selected"},
+	}
+	for _, tt := range tests {
+		if err := tt.fn(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestClash(t *testing.T) {
+	tests := []struct {
+		name      string
+		behavior  string
+		payload   string
+		rules     string
+		converted int
+		skipped   []string
+	}{
+		{
+			name:     "domain yaml",
+			behavior: BehaviorDomain,
+			payload: "payload:\n  - '+.google.com'\n  - '.ads.example.com'\n  - 'www.example.org'\n" +
+				"  - '*.cdn.example.net'\n  - '+.google.com'\n",
+			rules: `[{"domain":["www.example.org"],"domain_suffix":["google.com",".ads.example.com"],
+				"domain_regex":["^[^.]+\\.cdn\\.example\\.net$"]}]`,
+			converted: 4,
+		},
+		{
+			name:      "ipcidr text",
+			behavior:  BehaviorIPCIDR,
+			payload:   "\xef\xbb\xbf# comment\n10.0.0.0/8\n\n2001:db8::/32\n1.2.3.4\nnot-an-ip\n",
+			rules:     `[{"ip_cidr":["10.0.0.0/8","2001:db8::/32","1.2.3.4/32"]}]`,
+			converted: 3,
+			skipped:   []string{"not-an-ip"},
+		},
+		{
+			name:     "classical yaml",
+			behavior: BehaviorClassical,
+			payload: `payload:
+  - DOMAIN,Example.com
+  - DOMAIN-SUFFIX,google.com,PROXY
+  - DOMAIN-KEYWORD,ads
+  - DOMAIN-REGEX,^(?=x).*$
+  - DOMAIN-WILDCARD,*.example.org
+  - IP-CIDR,10.0.0.0/8,no-resolve
+  - IP-CIDR6,2001:db8::/32
+  - SRC-IP-CIDR,192.168.1.0/24
+  - DST-PORT,443/8000-9000
+  - SRC-PORT,1234
+  - PROCESS-NAME,curl
+  - PROCESS-PATH,/usr/bin/curl
+  - PROCESS-PATH-REGEX,^/opt/.*
+  - NETWORK,udp
+  - GEOIP,CN
+  - MATCH
+  - NETWORK,icmp
+`,
+			// Items of different kinds are separate rules, a rule set ORs its rules
+			rules: `[{"domain":["example.com"],"domain_suffix":["google.com"],"domain_keyword":["ads"],
+				"domain_regex":["^[^.]+\\.example\\.org$"],"ip_cidr":["10.0.0.0/8","2001:db8::/32"]},
+				{"source_ip_cidr":["192.168.1.0/24"]},{"port":[443],"port_range":["8000:9000"]},{"source_port":[1234]},
+				{"process_name":["curl"]},{"process_path":["/usr/bin/curl"]},{"process_path_regex":["^/opt/.*"]},
+				{"network":["udp"]}]`,
+			converted: 13,
+			skipped:   []string{"DOMAIN-REGEX,^(?=x).*$", "GEOIP,CN", "MATCH", "NETWORK,icmp"},
+		},
+		{
+			name:      "classical text",
+			behavior:  BehaviorClassical,
+			payload:   "DOMAIN-SUFFIX,example.com\n// comment\nDST-PORT,70000\n",
+			rules:     `[{"domain_suffix":["example.com"]}]`,
+			converted: 1,
+			skipped:   []string{"DST-PORT,70000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Clash([]byte(tt.payload), tt.behavior, "clash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertResult(t, r, tt.rules, tt.converted, tt.skipped)
+		})
+	}
+
+	if _, err := Clash([]byte("MRS\x01"), BehaviorDomain, "clash"); err == nil {
+		t.Error("mrs rule provider accepted")
+	}
+	if _, err := Clash([]byte("example.com"), "bogus", "clash"); err == nil {
+		t.Error("unknown behavior accepted")
+	}
+}
+
+func TestAdGuard(t *testing.T) {
+	list := `! Title: test list
+[Adblock Plus 2.0]
+||ads.example.com^
+||*.track.example.org^
+|exact.example.net^
+plain.example.com
+example.info^
+||important.example.com^$important
+@@||good.example.com^
+@@|allow.example.com|
+||third.example.com^$third-party
+##.ad
+example.com##.banner
+/banner\d+/
+||example.com/ads^
+||ads*.example.com^
+0.0.0.0 hosts.example.com www.hosts.example.com
+127.0.0.1 localhost
+::1 ip6-localhost
+192.168.1.1 router.example.com
+# hosts comment
+||ads.example.com^
+`
+	results, err := AdGuard([]byte(list), "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want the allow and block sets", len(results))
+	}
+	allow, block := results[0], results[1]
+	if allow.Name != "filter"+AllowSuffix || block.Name != "filter" || block.Exceptions != allow.Name {
+		t.Errorf("names = %q, %q, exceptions = %q", allow.Name, block.Name, block.Exceptions)
+	}
+	assertResult(t, allow, `[{"domain":["allow.example.com"],"domain_suffix":["good.example.com"]}]`, 2, nil)
+	assertResult(t, block, `[{"domain":["exact.example.net","plain.example.com","hosts.example.com","www.hosts.example.com"],
+		"domain_suffix":["ads.example.com",".track.example.org","example.info","important.example.com"]}]`, 8, []string{
+		"||third.example.com^$third-party",
+		"##.ad",
+		"example.com##.banner",
+		`/banner\d+/`,
+		"||example.com/ads^",
+		"||ads*.example.com^",
+		"192.168.1.1 router.example.com",
+	})
+
+	reasons := make(map[string]string, len(block.Skipped))
+	for _, s := range block.Skipped {
+		reasons[s.Entry] = s.Reason
+	}
+	for entry, want := range map[string]string{
+		"||third.example.com^$third-party": "unsupported modifier $third-party",
+		"##.ad":                            "cosmetic rule",
+		`/banner\d+/`:                      "regex rule",
+		"192.168.1.1 router.example.com":   "hosts entry redirects to 192.168.1.1",
+	} {
+		if reasons[entry] != want {
+			t.Errorf("%q skipped as %q, want %q", entry, reasons[entry], want)
+		}
+	}
+
+	// Without exceptions there is no allow set
+	results, err = AdGuard([]byte("||ads.example.com^\n"), "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Exceptions != "" {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
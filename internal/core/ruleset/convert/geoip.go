@@ -0,0 +1,112 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// GeoIPCategories lists the country codes of a geoip.dat file
+func GeoIPCategories(data []byte) ([]string, error) {
+	return GeositeCategories(data)
+}
+
+// GeoIP extracts country codes from a geoip.dat file. A reverse-match entry
+// becomes an inverted rule.
+func GeoIP(data []byte, codes []string, prefix string) ([]*Result, error) {
+	wanted := make(map[string]*Result)
+	var order []string
+	for _, code := range codes {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if code == "" || wanted[code] != nil {
+			continue
+		}
+		wanted[code] = newResult(prefix + code)
+		order = append(order, code)
+	}
+	if len(order) == 0 {
+		return nil, errors.New("no categories selected")
+	}
+
+	found := make(map[string]bool)
+	err := walkEntries(data, func(entry []byte) error {
+		code, cidrs, err := readCode(entry)
+		if err != nil {
+			return err
+		}
+		result := wanted[strings.ToLower(code)]
+		if result == nil {
+			return nil
+		}
+		found[strings.ToLower(code)] = true
+
+		var reverse bool
+		walkFields(entry, func(num protowire.Number, typ protowire.Type, _ []byte, n uint64) error {
+			if num == 3 && typ == protowire.VarintType {
+				reverse = n != 0
+			}
+			return nil
+		})
+
+		b := newBuilder()
+		for _, raw := range cidrs {
+			prefix, err := parseCIDR(raw)
+			if err != nil {
+				result.skip(fmt.Sprintf("%x", raw), err.Error())
+				continue
+			}
+			if b.add(&b.address.IPCIDR, "ip", prefix.String()) {
+				result.Converted++
+			}
+		}
+		sort.Strings(b.address.IPCIDR)
+		b.address.Invert = reverse
+		result.RuleSet.Rules = b.rules()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Result, 0, len(order))
+	for _, code := range order {
+		if !found[code] {
+			return nil, fmt.Errorf("category %q not found", code)
+		}
+		results = append(results, wanted[code])
+	}
+	return results, nil
+}
+
+// parseCIDR decodes a CIDR message: ip (1) as 4 or 16 bytes and prefix (2)
+func parseCIDR(data []byte) (netip.Prefix, error) {
+	var ip []byte
+	var bits uint64
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			ip = value
+		case num == 2 && typ == protowire.VarintType:
+			bits = n
+		}
+		return nil
+	})
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("invalid address length %d", len(ip))
+	}
+	if addr.Is4In6() && bits >= 96 {
+		addr, bits = addr.Unmap(), bits-96
+	}
+	if bits > uint64(addr.BitLen()) {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix length %d", bits)
+	}
+	return netip.PrefixFrom(addr, int(bits)).Masked(), nil
+}
@@ -0,0 +1,234 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// V2Ray geosite domain types
+const (
+	domainPlain = 0 // keyword
+	domainRegex = 1
+	domainRoot  = 2 // the domain and its subdomains
+	domainFull  = 3
+)
+
+// attributeNames turns "google@!cn" into the rule set name suffix "google-not-cn"
+var attributeNames = strings.NewReplacer("@!", "-not-", "@", "-")
+
+type geositeDomain struct {
+	kind       uint64
+	value      string
+	attributes []string
+}
+
+// GeositeCategories lists the category codes of a geosite.dat file
+func GeositeCategories(data []byte) ([]string, error) {
+	var codes []string
+	err := walkEntries(data, func(entry []byte) error {
+		code, _, err := readCode(entry)
+		if err == nil {
+			codes = append(codes, code)
+		}
+		return err
+	})
+	sort.Strings(codes)
+	return codes, err
+}
+
+// Geosite extracts categories from a geosite.dat file. A category may select
+// domains by attribute: "google@cn" keeps the domains tagged cn, "google@!cn"
+// drops them.
+func Geosite(data []byte, categories []string, prefix string) ([]*Result, error) {
+	type selection struct {
+		category, attribute string
+		exclude             bool
+		result              *Result
+	}
+	wanted := make(map[string][]*selection)
+	var selections []*selection
+	for _, raw := range categories {
+		raw = strings.ToLower(strings.TrimSpace(raw))
+		if raw == "" {
+			continue
+		}
+		category, attribute, _ := strings.Cut(raw, "@")
+		sel := &selection{category: category, result: newResult(prefix + attributeNames.Replace(raw))}
+		if strings.HasPrefix(attribute, "!") {
+			sel.exclude = true
+			attribute = attribute[1:]
+		}
+		sel.attribute = attribute
+		wanted[category] = append(wanted[category], sel)
+		selections = append(selections, sel)
+	}
+	if len(selections) == 0 {
+		return nil, errors.New("no categories selected")
+	}
+
+	builders := make(map[*selection]*builder)
+	found := make(map[string]bool)
+	err := walkEntries(data, func(entry []byte) error {
+		code, fields, err := readCode(entry)
+		if err != nil {
+			return err
+		}
+		sels := wanted[strings.ToLower(code)]
+		if len(sels) == 0 {
+			return nil
+		}
+		found[strings.ToLower(code)] = true
+		for _, raw := range fields {
+			d, err := parseGeositeDomain(raw)
+			if err != nil {
+				return fmt.Errorf("category %s: %w", code, err)
+			}
+			for _, sel := range sels {
+				if sel.attribute != "" && hasAttribute(d, sel.attribute) == sel.exclude {
+					continue
+				}
+				b := builders[sel]
+				if b == nil {
+					b = newBuilder()
+					builders[sel] = b
+				}
+				addGeositeDomain(b, sel.result, d)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Result, 0, len(selections))
+	for _, sel := range selections {
+		if !found[sel.category] {
+			return nil, fmt.Errorf("category %q not found", sel.category)
+		}
+		if b := builders[sel]; b != nil {
+			sel.result.RuleSet.Rules = b.rules()
+		}
+		results = append(results, sel.result)
+	}
+	return results, nil
+}
+
+func addGeositeDomain(b *builder, result *Result, d geositeDomain) {
+	added := false
+	switch d.kind {
+	case domainPlain:
+		added = b.add(&b.address.DomainKeyword, "keyword", d.value)
+	case domainRegex:
+		if _, err := regexp.Compile(d.value); err != nil {
+			result.skip("regexp:"+d.value, "regex not supported by Go: "+err.Error())
+			return
+		}
+		added = b.add(&b.address.DomainRegex, "regex", d.value)
+	case domainRoot:
+		added = b.add(&b.address.DomainSuffix, "suffix", d.value)
+	case domainFull:
+		added = b.add(&b.address.Domain, "domain", d.value)
+	default:
+		result.skip(d.value, fmt.Sprintf("unknown domain type %d", d.kind))
+		return
+	}
+	if added {
+		result.Converted++
+	}
+}
+
+func hasAttribute(d geositeDomain, attribute string) bool {
+	for _, a := range d.attributes {
+		if strings.EqualFold(a, attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkEntries calls fn with every entry (field 1) of a GeoSiteList or GeoIPList
+func walkEntries(data []byte, fn func([]byte) error) error {
+	return walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if num == 1 && typ == protowire.BytesType {
+			return fn(value)
+		}
+		return nil
+	})
+}
+
+// readCode returns the country_code (field 1) of a GeoSite or GeoIP message and
+// the raw bytes of its repeated field 2, the domains or CIDRs
+func readCode(entry []byte) (string, [][]byte, error) {
+	var code string
+	var items [][]byte
+	err := walkFields(entry, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			code = string(value)
+		case num == 2 && typ == protowire.BytesType:
+			items = append(items, value)
+		}
+		return nil
+	})
+	return code, items, err
+}
+
+func parseGeositeDomain(data []byte) (geositeDomain, error) {
+	var d geositeDomain
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			d.kind = n
+		case num == 2 && typ == protowire.BytesType:
+			d.value = string(value)
+		case num == 3 && typ == protowire.BytesType:
+			// Attribute: key (1), bool_value (2) or int_value (3)
+			return walkFields(value, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+				if num == 1 && typ == protowire.BytesType {
+					d.attributes = append(d.attributes, string(value))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return d, err
+}
+
+// walkFields iterates the fields of a protobuf message. value is set for
+// length-delimited fields and number for varint fields.
+func walkFields(data []byte, fn func(protowire.Number, protowire.Type, []byte, uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf data: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var value []byte
+		var number uint64
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			number, n = protowire.ConsumeVarint(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf data: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, value, number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
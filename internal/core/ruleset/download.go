@@ -0,0 +1,274 @@
+package ruleset
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"singbox.arrow.web2/internal/core/download"
+	"singbox.arrow.web2/internal/core/ruleset/source"
+	"singbox.arrow.web2/internal/core/ruleset/srs"
+	"singbox.arrow.web2/internal/storage"
+)
+
+const (
+	// Dir holds downloaded and uploaded rule sets, relative to the data directory
+	Dir = "rulesets"
+
+	// MaxFileSize limits downloaded and uploaded rule sets
+	MaxFileSize = 64 << 20
+
+	downloadTimeout = 60 * time.Second
+)
+
+// updateMu serializes downloads so a manual update and a reload never write the same file
+var updateMu sync.Mutex
+
+// UpdateResult describes a single download
+type UpdateResult struct {
+	Changed bool `json:"changed"`
+	Size    int  `json:"size"`
+}
+
+// LocalFileName returns where a remote rule set is stored, relative to the data directory
+func LocalFileName(rs *storage.Ruleset) string {
+	return filepath.Join(Dir, fmt.Sprintf("remote-%d%s", rs.ID, Ext(rs.Format)))
+}
+
+// Ext returns the file extension used for a rule set format
+func Ext(format string) string {
+	if format == "binary" {
+		return ".srs"
+	}
+	return ".json"
+}
+
+// LocalFile returns the downloaded copy of a remote rule set relative to the data
+// directory, or "" when it has not been downloaded yet
+func LocalFile(rs *storage.Ruleset) string {
+	if rs.LocalPath == "" {
+		return ""
+	}
+	if _, err := os.Stat(ResolvePath(rs.LocalPath)); err != nil {
+		return ""
+	}
+	return rs.LocalPath
+}
+
+// Update downloads a remote rule set through the download proxy. The request is
+// conditional on the stored ETag and Last-Modified, the content is validated for the
+// declared format before it replaces the local copy, and the outcome is saved on the row.
+func Update(rs *storage.Ruleset) (*UpdateResult, error) {
+	if rs.Type != "remote" {
+		return nil, fmt.Errorf("rule set %q is not remote", rs.Name)
+	}
+	if rs.URL == "" {
+		return nil, fmt.Errorf("rule set %q has no URL", rs.Name)
+	}
+
+	updateMu.Lock()
+	defer updateMu.Unlock()
+
+	result, err := update(rs)
+	if err != nil {
+		rs.LastError = err.Error()
+		storage.DB.Model(rs).Update("last_error", rs.LastError)
+		return nil, err
+	}
+	if result.Changed {
+		reindex(rs)
+	}
+	return result, nil
+}
+
+func update(rs *storage.Ruleset) (*UpdateResult, error) {
+	target := LocalFileName(rs)
+	// Without the file a 304 would leave nothing to use
+	conditional := rs.LocalPath == target && LocalFile(rs) != ""
+
+	req, err := http.NewRequest(http.MethodGet, rs.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule set url: %w", err)
+	}
+	req.Header.Set("User-Agent", "sing-box")
+	if conditional {
+		if rs.ETag != "" {
+			req.Header.Set("If-None-Match", rs.ETag)
+		}
+		if rs.LastModified != "" {
+			req.Header.Set("If-Modified-Since", rs.LastModified)
+		}
+	}
+
+	resp, err := download.NewClient(downloadTimeout).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download rule set: %w", err)
+	}
+	defer resp.Body.Close()
+
+	now := time.Now()
+	if resp.StatusCode == http.StatusNotModified && conditional {
+		rs.LastUpdate = &now
+		rs.LastError = ""
+		err := storage.DB.Model(rs).Updates(map[string]interface{}{
+			"last_update": now,
+			"last_error":  "",
+		}).Error
+		return &UpdateResult{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download rule set: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule set: %w", err)
+	}
+	if len(data) > MaxFileSize {
+		return nil, fmt.Errorf("rule set is larger than %d MB", MaxFileSize>>20)
+	}
+	if err := ValidateContent(rs.Format, data); err != nil {
+		return nil, err
+	}
+
+	changed := true
+	if existing, err := os.ReadFile(ResolvePath(target)); err == nil && bytes.Equal(existing, data) {
+		changed = false
+	} else if err := WriteFile(target, data); err != nil {
+		return nil, fmt.Errorf("failed to save rule set: %w", err)
+	}
+
+	rs.LocalPath = target
+	rs.ETag = resp.Header.Get("ETag")
+	rs.LastModified = resp.Header.Get("Last-Modified")
+	rs.LastUpdate = &now
+	rs.LastError = ""
+	err = storage.DB.Model(rs).Updates(map[string]interface{}{
+		"local_path":    rs.LocalPath,
+		"e_tag":         rs.ETag,
+		"last_modified": rs.LastModified,
+		"last_update":   now,
+		"last_error":    "",
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateResult{Changed: changed, Size: len(data)}, nil
+}
+
+// Fetch downloads a file through the download proxy, such as a database or list to convert
+func Fetch(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	resp, err := download.NewClient(downloadTimeout).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read: %w", err)
+	}
+	if len(data) > MaxFileSize {
+		return nil, fmt.Errorf("file is larger than %d MB", MaxFileSize>>20)
+	}
+	return data, nil
+}
+
+// EnsureDownloaded downloads the enabled remote rule sets that have no local copy yet,
+// so the generated config can reference local files. Failures are logged and recorded
+// on the row, the generator then falls back to a remote entry.
+func EnsureDownloaded() {
+	var rulesets []storage.Ruleset
+	if err := storage.DB.Where("type = ? AND enabled = ?", "remote", true).Find(&rulesets).Error; err != nil {
+		log.Printf("Ruleset: failed to load rulesets: %v", err)
+		return
+	}
+	for i := range rulesets {
+		rs := &rulesets[i]
+		if rs.URL == "" || (LocalFile(rs) != "" && rs.LocalPath == LocalFileName(rs)) {
+			continue
+		}
+		if _, err := Update(rs); err != nil {
+			log.Printf("Ruleset: failed to download %q: %v", rs.Name, err)
+		}
+	}
+}
+
+// ValidateContent checks that data is a rule set in the given format
+func ValidateContent(format string, data []byte) error {
+	switch format {
+	case "source":
+		if _, err := source.Parse(data); err != nil {
+			return fmt.Errorf("invalid source rule set: %w", err)
+		}
+	case "binary":
+		rs, err := srs.Decode(data)
+		if err == nil {
+			err = rs.Compile()
+		}
+		if err != nil {
+			return fmt.Errorf("invalid binary rule set: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown rule set format %q", format)
+	}
+	return nil
+}
+
+// WriteFile atomically replaces the file at path, relative to the data directory,
+// so sing-box never reads a partially written rule set
+func WriteFile(path string, data []byte) error {
+	path = ResolvePath(path)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// RemoveDownload deletes the downloaded copy of a remote rule set
+func RemoveDownload(rs *storage.Ruleset) {
+	if rs.LocalPath != "" {
+		forget(rs.LocalPath)
+		os.Remove(ResolvePath(rs.LocalPath))
+	}
+}
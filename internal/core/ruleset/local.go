@@ -0,0 +1,146 @@
+package ruleset
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+	"singbox.arrow.web2/internal/core/ruleset/source"
+	"singbox.arrow.web2/internal/core/ruleset/srs"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// ManagedFileName returns where the panel stores an uploaded or edited local rule set,
+// relative to the data directory
+func ManagedFileName(rs *storage.Ruleset) string {
+	return filepath.Join(Dir, fmt.Sprintf("local-%d%s", rs.ID, Ext(rs.Format)))
+}
+
+// IsManaged reports whether path is a file the panel created under Dir
+func IsManaged(path string) bool {
+	return path != "" && !filepath.IsAbs(path) && filepath.Dir(filepath.Clean(path)) == Dir
+}
+
+// SaveFile validates data for the rule set format and atomically stores it as the
+// file of a local rule set. A previous panel-managed file with another name is removed.
+func SaveFile(rs *storage.Ruleset, data []byte) error {
+	if rs.Type != "local" {
+		return fmt.Errorf("rule set %q is not local", rs.Name)
+	}
+	if err := ValidateContent(rs.Format, data); err != nil {
+		return err
+	}
+
+	oldPath := rs.Path
+	path := rs.Path
+	if !IsManaged(path) || filepath.Ext(path) != Ext(rs.Format) {
+		path = ManagedFileName(rs)
+	}
+	if err := WriteFile(path, data); err != nil {
+		return fmt.Errorf("failed to save rule set: %w", err)
+	}
+	if oldPath != path && IsManaged(oldPath) {
+		forget(oldPath)
+		os.Remove(ResolvePath(oldPath))
+	}
+
+	now := time.Now()
+	rs.Path = path
+	rs.LastUpdate = &now
+	if err := storage.DB.Model(rs).Updates(map[string]interface{}{
+		"path":        rs.Path,
+		"last_update": now,
+	}).Error; err != nil {
+		return err
+	}
+	reindex(rs)
+	return nil
+}
+
+// SaveRules writes rules as the file of a local rule set in its format
+func SaveRules(rs *storage.Ruleset, rules []source.HeadlessRule) error {
+	if err := ValidateRules(rules); err != nil {
+		return err
+	}
+	data, err := Encode(rs.Format, &source.PlainRuleSet{Version: source.CurrentVersion, Rules: rules})
+	if err != nil {
+		return err
+	}
+	return SaveFile(rs, data)
+}
+
+// SaveLocal stores rules as the local rule set called name, creating the row when
+// it does not exist. A remote rule set with that name is left alone.
+func SaveLocal(name, format string, rules *source.PlainRuleSet) (*storage.Ruleset, bool, error) {
+	data, err := Encode(format, rules)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var rs storage.Ruleset
+	created := false
+	err = storage.DB.Where("name = ?", name).First(&rs).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		rs = storage.Ruleset{Name: name, Type: "local", Format: format, Enabled: true}
+		if err := storage.DB.Create(&rs).Error; err != nil {
+			return nil, false, err
+		}
+		created = true
+	case err != nil:
+		return nil, false, err
+	case rs.Type != "local":
+		return nil, false, fmt.Errorf("rule set %q already exists and is not local", name)
+	case rs.Format != format:
+		rs.Format = format
+		if err := storage.DB.Model(&rs).Update("format", format).Error; err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := SaveFile(&rs, data); err != nil {
+		if created {
+			storage.DB.Delete(&rs)
+		}
+		return nil, false, err
+	}
+	return &rs, created, nil
+}
+
+// Encode renders a rule set as a file in the given format
+func Encode(format string, rules *source.PlainRuleSet) ([]byte, error) {
+	switch format {
+	case "source":
+		return json.MarshalIndent(rules, "", "  ")
+	case "binary":
+		return srs.Encode(rules)
+	default:
+		return nil, fmt.Errorf("unknown rule set format %q", format)
+	}
+}
+
+// ValidateRules checks rules written through the API, unlike rule set files an
+// empty rule is rejected because it would match every connection
+func ValidateRules(rules []source.HeadlessRule) error {
+	for i := range rules {
+		if rules[i].IsEmpty() {
+			return fmt.Errorf("rule %d: no conditions", i+1)
+		}
+	}
+	rs := source.PlainRuleSet{Version: source.CurrentVersion, Rules: rules}
+	return rs.Compile()
+}
+
+// RemoveFiles deletes the files the panel stored for a rule set: the downloaded copy
+// of a remote rule set or the uploaded file of a local one
+func RemoveFiles(rs *storage.Ruleset) {
+	RemoveDownload(rs)
+	if rs.Type == "local" && IsManaged(rs.Path) {
+		forget(rs.Path)
+		os.Remove(ResolvePath(rs.Path))
+	}
+}
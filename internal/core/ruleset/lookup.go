@@ -0,0 +1,93 @@
+package ruleset
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+
+	"singbox.arrow.web2/internal/core/ruleset/source"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// LookupMatch is a rule set claiming the looked up domain or IP
+type LookupMatch struct {
+	RulesetID uint         `json:"ruleset_id"`
+	Name      string       `json:"name"`
+	Format    string       `json:"format"`
+	Hits      []source.Hit `json:"hits"`
+}
+
+// LookupError is an enabled rule set whose file could not be searched
+type LookupError struct {
+	RulesetID uint   `json:"ruleset_id"`
+	Name      string `json:"name"`
+	Error     string `json:"error"`
+}
+
+type LookupResult struct {
+	Query       string        `json:"query"`
+	Kind        string        `json:"kind"` // domain or ip
+	Matches     []LookupMatch `json:"matches"`
+	Unavailable []LookupError `json:"unavailable"`
+}
+
+// Lookup searches the local files of all enabled rule sets for a domain or IP. The
+// parsed files are kept in memory and rebuilt when a rule set is downloaded or saved.
+func Lookup(query string) (*LookupResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("domain or ip is required")
+	}
+	result := &LookupResult{Query: query, Kind: "domain", Matches: []LookupMatch{}, Unavailable: []LookupError{}}
+	var domain string
+	ip, err := netip.ParseAddr(strings.Trim(query, "[]"))
+	if err == nil {
+		result.Kind = "ip"
+	} else {
+		domain = strings.ToLower(strings.TrimSuffix(query, "."))
+		if strings.ContainsAny(domain, "/: ") {
+			return nil, fmt.Errorf("%q is neither a domain nor an ip", query)
+		}
+	}
+
+	var rulesets []storage.Ruleset
+	if err := storage.DB.Where("enabled = ?", true).Order("id").Find(&rulesets).Error; err != nil {
+		return nil, fmt.Errorf("failed to load rulesets: %w", err)
+	}
+	for i := range rulesets {
+		rs := &rulesets[i]
+		rules, err := LoadRuleset(rs)
+		if err != nil {
+			result.Unavailable = append(result.Unavailable, LookupError{RulesetID: rs.ID, Name: rs.Name, Error: err.Error()})
+			continue
+		}
+		if hits := rules.Lookup(domain, ip); len(hits) > 0 {
+			result.Matches = append(result.Matches, LookupMatch{RulesetID: rs.ID, Name: rs.Name, Format: rs.Format, Hits: hits})
+		}
+	}
+	return result, nil
+}
+
+// reindex drops the parsed copy of a rule set file and parses it again, so a
+// lookup right after a download does not pay for it
+func reindex(rs *storage.Ruleset) {
+	file := FilePath(rs)
+	if file == "" {
+		return
+	}
+	forget(file)
+	if _, err := LoadRuleset(rs); err != nil {
+		log.Printf("Ruleset: failed to index %s: %v", rs.Name, err)
+	}
+}
+
+// forget drops the parsed copy of a rule set file
+func forget(file string) {
+	if file == "" {
+		return
+	}
+	cacheMu.Lock()
+	delete(cache, ResolvePath(file))
+	cacheMu.Unlock()
+}
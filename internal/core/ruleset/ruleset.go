@@ -0,0 +1,101 @@
+// Package ruleset manages the rule sets referenced by routing rules and loads
+// them for offline evaluation.
+package ruleset
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"singbox.arrow.web2/internal/core/ruleset/source"
+	"singbox.arrow.web2/internal/core/ruleset/srs"
+	"singbox.arrow.web2/internal/storage"
+)
+
+var (
+	dataDir string
+
+	cacheMu sync.Mutex
+	cache   = make(map[string]*cachedRuleSet)
+)
+
+type cachedRuleSet struct {
+	modTime time.Time
+	size    int64
+	rules   *source.PlainRuleSet
+}
+
+// Init sets the data directory relative rule set paths are resolved against,
+// which is also the working directory of sing-box
+func Init(dir string) {
+	dataDir = dir
+}
+
+// ResolvePath returns path as sing-box sees it
+func ResolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dataDir, path)
+}
+
+// Load returns the rules of the enabled rule set with the given tag from its local file
+func Load(tag string) (*source.PlainRuleSet, error) {
+	var rs storage.Ruleset
+	if err := storage.DB.Where("name = ? AND enabled = ?", tag, true).First(&rs).Error; err != nil {
+		return nil, fmt.Errorf("rule set %q not found", tag)
+	}
+	return LoadRuleset(&rs)
+}
+
+// FilePath returns the file a rule set is read from relative to the data directory:
+// the path of a local rule set or the downloaded copy of a remote one
+func FilePath(rs *storage.Ruleset) string {
+	if rs.Type == "remote" {
+		return LocalFile(rs)
+	}
+	return rs.Path
+}
+
+// LoadRuleset reads a rule set file, reusing the parsed result while the file is unchanged
+func LoadRuleset(rs *storage.Ruleset) (*source.PlainRuleSet, error) {
+	file := FilePath(rs)
+	if file == "" {
+		return nil, fmt.Errorf("rule set %q is not available locally", rs.Name)
+	}
+
+	path := ResolvePath(file)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("rule set %q: %w", rs.Name, err)
+	}
+
+	cacheMu.Lock()
+	cached, ok := cache[path]
+	cacheMu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.rules, nil
+	}
+
+	var rules *source.PlainRuleSet
+	switch rs.Format {
+	case "source":
+		rules, err = source.ReadFile(path)
+	case "binary":
+		if rules, err = srs.ReadFile(path); err == nil {
+			err = rules.Compile()
+		}
+	default:
+		return nil, fmt.Errorf("rule set %q uses unsupported format %q", rs.Name, rs.Format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rule set %q: %w", rs.Name, err)
+	}
+
+	cacheMu.Lock()
+	cache[path] = &cachedRuleSet{modTime: info.ModTime(), size: info.Size(), rules: rules}
+	cacheMu.Unlock()
+	return rules, nil
+}
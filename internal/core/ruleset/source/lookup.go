@@ -0,0 +1,93 @@
+package source
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Hit is a destination address item that claims a looked up domain or IP
+type Hit struct {
+	// Rule is the index of the rule, sub rules of a logical rule are joined with "/"
+	Rule  string `json:"rule"`
+	Item  string `json:"item"` // domain, domain_suffix, domain_keyword, domain_regex or ip_cidr
+	Value string `json:"value"`
+	// Conditional is set when the item alone does not decide a match: the rule has
+	// other conditions, is inverted or belongs to a logical rule
+	Conditional bool `json:"conditional"`
+}
+
+// Lookup lists every domain or ip_cidr item that matches domain or ip. Unlike
+// Match it ignores the other conditions of a rule and reports all items, not the
+// first one.
+func (rs *PlainRuleSet) Lookup(domain string, ip netip.Addr) []Hit {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	var hits []Hit
+	for i := range rs.Rules {
+		hits = rs.Rules[i].lookup(strconv.Itoa(i), domain, ip, false, hits)
+	}
+	return hits
+}
+
+func (r *HeadlessRule) lookup(path, domain string, ip netip.Addr, nested bool, hits []Hit) []Hit {
+	if r.IsLogical() {
+		for i := range r.Rules {
+			hits = r.Rules[i].lookup(path+"/"+strconv.Itoa(i), domain, ip, true, hits)
+		}
+		return hits
+	}
+	if r.matcher == nil {
+		if err := r.Compile(); err != nil {
+			return hits
+		}
+	}
+	c := r.matcher
+	conditional := nested || r.Invert || c.offlineOnly || len(c.networks) > 0 || len(c.processNames) > 0 ||
+		len(c.sourcePrefixes) > 0 || len(c.sourcePorts) > 0 || len(c.sourceRanges) > 0 ||
+		len(c.ports) > 0 || len(c.portRanges) > 0
+	add := func(item, value string) {
+		hits = append(hits, Hit{Rule: path, Item: item, Value: value, Conditional: conditional})
+	}
+
+	if ip.IsValid() {
+		for _, prefix := range c.ipPrefixes {
+			if prefix.Contains(ip.Unmap()) {
+				add("ip_cidr", prefix.String())
+			}
+		}
+	}
+	if domain == "" {
+		return hits
+	}
+	if c.domains[domain] {
+		add("domain", domain)
+	}
+	if c.suffixes[domain] {
+		add("domain_suffix", domain)
+	}
+	for i := strings.IndexByte(domain, '.'); i >= 0; {
+		parent := domain[i+1:]
+		if c.suffixes[parent] {
+			add("domain_suffix", parent)
+		}
+		if c.subdomainsOnly[parent] {
+			add("domain_suffix", "."+parent)
+		}
+		next := strings.IndexByte(parent, '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	for _, k := range c.keywords {
+		if strings.Contains(domain, k) {
+			add("domain_keyword", k)
+		}
+	}
+	for _, re := range c.regexes {
+		if re.MatchString(domain) {
+			add("domain_regex", re.String())
+		}
+	}
+	return hits
+}
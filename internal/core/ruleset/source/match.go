@@ -0,0 +1,323 @@
+package source
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Metadata describes the connection rules are matched against.
+// Zero values mean the field is unknown and never matches.
+type Metadata struct {
+	Domain      string
+	IP          netip.Addr // destination
+	Port        uint16
+	Network     string // tcp/udp
+	SourceIP    netip.Addr
+	SourcePort  uint16
+	ProcessName string
+}
+
+type portRange struct {
+	start, end uint16
+}
+
+// matcher is the compiled form of a default rule
+type matcher struct {
+	domains        map[string]bool
+	suffixes       map[string]bool // matches the domain itself and its subdomains
+	subdomainsOnly map[string]bool // written with a leading dot, subdomains only
+	keywords       []string
+	regexes        []*regexp.Regexp
+	ipPrefixes     []netip.Prefix
+	sourcePrefixes []netip.Prefix
+	ports          map[uint16]bool
+	portRanges     []portRange
+	sourcePorts    map[uint16]bool
+	sourceRanges   []portRange
+	networks       map[string]bool
+	processNames   map[string]bool
+	// Items that depend on the device or the DNS query, a connection never matches them
+	offlineOnly bool
+}
+
+// Compile validates the rule and prepares it for Match. Match compiles lazily,
+// calling Compile up front reports errors early.
+func (r *HeadlessRule) Compile() error {
+	if r.IsLogical() {
+		if r.Mode != "and" && r.Mode != "or" {
+			return fmt.Errorf("invalid logical mode %q", r.Mode)
+		}
+		if len(r.Rules) == 0 {
+			return fmt.Errorf("logical rule without sub rules")
+		}
+		for i := range r.Rules {
+			if err := r.Rules[i].Compile(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if r.Type != "" && r.Type != "default" {
+		return fmt.Errorf("unknown rule type %q", r.Type)
+	}
+
+	m := &matcher{
+		domains:        make(map[string]bool, len(r.Domain)),
+		suffixes:       make(map[string]bool, len(r.DomainSuffix)),
+		subdomainsOnly: make(map[string]bool),
+		ports:          make(map[uint16]bool, len(r.Port)),
+		sourcePorts:    make(map[uint16]bool, len(r.SourcePort)),
+		networks:       make(map[string]bool, len(r.Network)),
+		processNames:   make(map[string]bool, len(r.ProcessName)),
+	}
+	for _, d := range r.Domain {
+		m.domains[strings.ToLower(d)] = true
+	}
+	for _, s := range r.DomainSuffix {
+		s = strings.ToLower(s)
+		if strings.HasPrefix(s, ".") {
+			m.subdomainsOnly[s[1:]] = true
+		} else {
+			m.suffixes[s] = true
+		}
+	}
+	for _, k := range r.DomainKeyword {
+		m.keywords = append(m.keywords, strings.ToLower(k))
+	}
+	for _, expr := range r.DomainRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid domain_regex %q: %w", expr, err)
+		}
+		m.regexes = append(m.regexes, re)
+	}
+	var err error
+	if m.ipPrefixes, err = parsePrefixes(r.IPCIDR); err != nil {
+		return err
+	}
+	if m.sourcePrefixes, err = parsePrefixes(r.SourceIPCIDR); err != nil {
+		return err
+	}
+	for _, p := range r.Port {
+		m.ports[p] = true
+	}
+	if m.portRanges, err = parsePortRanges(r.PortRange); err != nil {
+		return err
+	}
+	for _, p := range r.SourcePort {
+		m.sourcePorts[p] = true
+	}
+	if m.sourceRanges, err = parsePortRanges(r.SourcePortRange); err != nil {
+		return err
+	}
+	for _, n := range r.Network {
+		m.networks[n] = true
+	}
+	for _, p := range r.ProcessName {
+		m.processNames[p] = true
+	}
+	m.offlineOnly = len(r.QueryType) > 0 || len(r.ProcessPath) > 0 || len(r.ProcessPathRegex) > 0 ||
+		len(r.PackageName) > 0 || len(r.NetworkType) > 0 || r.NetworkIsExpensive || r.NetworkIsConstrained ||
+		len(r.WIFISSID) > 0 || len(r.WIFIBSSID) > 0
+
+	r.matcher = m
+	return nil
+}
+
+// Match reports whether the rule matches m
+func (r *HeadlessRule) Match(m *Metadata) bool {
+	return r.MatchWith(m, nil)
+}
+
+// MatchWith is Match with extra results ORed into the destination address group,
+// route rules use it for rule_set and ip_is_private. Extra is ignored for logical rules.
+//
+// Like sing-box, items of the same group (destination address, destination port,
+// source address, source port) are ORed and the groups and remaining items are ANDed.
+func (r *HeadlessRule) MatchWith(m *Metadata, extra []bool) bool {
+	if r.IsLogical() {
+		matched := r.Mode == "and"
+		for i := range r.Rules {
+			sub := r.Rules[i].Match(m)
+			if r.Mode == "and" && !sub {
+				matched = false
+				break
+			}
+			if r.Mode == "or" && sub {
+				matched = true
+				break
+			}
+		}
+		return matched != r.Invert
+	}
+
+	if r.matcher == nil {
+		if err := r.Compile(); err != nil {
+			return false
+		}
+	}
+	return r.matcher.match(m, extra) != r.Invert
+}
+
+func (c *matcher) match(m *Metadata, extra []bool) bool {
+	if c.offlineOnly {
+		return false
+	}
+	if len(c.networks) > 0 && !c.networks[m.Network] {
+		return false
+	}
+	if len(c.processNames) > 0 && !c.processNames[m.ProcessName] {
+		return false
+	}
+
+	if len(c.sourcePrefixes) > 0 && !prefixesContain(c.sourcePrefixes, m.SourceIP) {
+		return false
+	}
+	if len(c.sourcePorts) > 0 || len(c.sourceRanges) > 0 {
+		if !portMatches(c.sourcePorts, c.sourceRanges, m.SourcePort) {
+			return false
+		}
+	}
+	if len(c.ports) > 0 || len(c.portRanges) > 0 {
+		if !portMatches(c.ports, c.portRanges, m.Port) {
+			return false
+		}
+	}
+
+	hasDestination := len(c.domains) > 0 || len(c.suffixes) > 0 || len(c.subdomainsOnly) > 0 ||
+		len(c.keywords) > 0 || len(c.regexes) > 0 || len(c.ipPrefixes) > 0 || len(extra) > 0
+	if !hasDestination {
+		return true
+	}
+	for _, matched := range extra {
+		if matched {
+			return true
+		}
+	}
+	if len(c.ipPrefixes) > 0 && prefixesContain(c.ipPrefixes, m.IP) {
+		return true
+	}
+	return c.matchDomain(strings.ToLower(strings.TrimSuffix(m.Domain, ".")))
+}
+
+func (c *matcher) matchDomain(domain string) bool {
+	if domain == "" {
+		return false
+	}
+	if c.domains[domain] || c.suffixes[domain] {
+		return true
+	}
+	// Walk parent domains: a.b.example.com -> b.example.com -> example.com -> com
+	for i := strings.IndexByte(domain, '.'); i >= 0; {
+		parent := domain[i+1:]
+		if c.suffixes[parent] || c.subdomainsOnly[parent] {
+			return true
+		}
+		next := strings.IndexByte(parent, '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	for _, k := range c.keywords {
+		if strings.Contains(domain, k) {
+			return true
+		}
+	}
+	for _, re := range c.regexes {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func portMatches(ports map[uint16]bool, ranges []portRange, port uint16) bool {
+	if port == 0 {
+		return false
+	}
+	if ports[port] {
+		return true
+	}
+	for _, r := range ranges {
+		if port >= r.start && port <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefix accepts a CIDR or a single address
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", s)
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		p, err := ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// parsePortRanges parses "start:end", ":end" and "start:" ranges
+func parsePortRanges(values []string) ([]portRange, error) {
+	ranges := make([]portRange, 0, len(values))
+	for _, v := range values {
+		startStr, endStr, ok := strings.Cut(v, ":")
+		if !ok || (startStr == "" && endStr == "") {
+			return nil, fmt.Errorf("invalid port range %q", v)
+		}
+		r := portRange{start: 1, end: 65535}
+		if startStr != "" {
+			n, err := strconv.ParseUint(startStr, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port range %q", v)
+			}
+			r.start = uint16(n)
+		}
+		if endStr != "" {
+			n, err := strconv.ParseUint(endStr, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port range %q", v)
+			}
+			r.end = uint16(n)
+		}
+		if r.start > r.end {
+			return nil, fmt.Errorf("invalid port range %q", v)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
@@ -0,0 +1,176 @@
+// Package source implements the sing-box rule-set source format (JSON) and
+// matches its headless rules against a connection offline.
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	Version1 = 1
+	Version2 = 2
+	Version3 = 3
+	// CurrentVersion is written by the panel, version 3 is understood by sing-box 1.11+
+	CurrentVersion = Version3
+)
+
+// Listable accepts either a single value or a list in JSON, like sing-box does
+type Listable[T any] []T
+
+func (l *Listable[T]) UnmarshalJSON(data []byte) error {
+	var list []T
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var single T
+	if err := json.Unmarshal(data, &single); err != nil {
+		return err
+	}
+	*l = []T{single}
+	return nil
+}
+
+// queryTypes are the DNS query type names accepted in query_type
+var queryTypes = map[string]uint16{
+	"A": 1, "NS": 2, "CNAME": 5, "SOA": 6, "PTR": 12, "MX": 15, "TXT": 16, "AAAA": 28,
+	"SRV": 33, "NAPTR": 35, "OPT": 41, "DS": 43, "RRSIG": 46, "NSEC": 47, "DNSKEY": 48,
+	"SVCB": 64, "HTTPS": 65, "ANY": 255, "CAA": 257,
+}
+
+// QueryType is a DNS query type, written as a name such as "AAAA" or as a number
+type QueryType uint16
+
+func (t QueryType) MarshalJSON() ([]byte, error) {
+	for name, value := range queryTypes {
+		if value == uint16(t) {
+			return json.Marshal(name)
+		}
+	}
+	return json.Marshal(uint16(t))
+}
+
+func (t *QueryType) UnmarshalJSON(data []byte) error {
+	var number uint16
+	if err := json.Unmarshal(data, &number); err == nil {
+		*t = QueryType(number)
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	value, ok := queryTypes[strings.ToUpper(name)]
+	if !ok {
+		return fmt.Errorf("unknown query type %q", name)
+	}
+	*t = QueryType(value)
+	return nil
+}
+
+// PlainRuleSet is the top level object of a source rule set file
+type PlainRuleSet struct {
+	Version int            `json:"version"`
+	Rules   []HeadlessRule `json:"rules"`
+}
+
+// HeadlessRule is a rule-set rule. Type is empty or "default" for a default rule,
+// "logical" for a rule combining Rules with Mode.
+type HeadlessRule struct {
+	Type string `json:"type,omitempty"`
+
+	QueryType            Listable[QueryType] `json:"query_type,omitempty"`
+	Network              Listable[string]    `json:"network,omitempty"`
+	Domain               Listable[string]    `json:"domain,omitempty"`
+	DomainSuffix         Listable[string]    `json:"domain_suffix,omitempty"`
+	DomainKeyword        Listable[string]    `json:"domain_keyword,omitempty"`
+	DomainRegex          Listable[string]    `json:"domain_regex,omitempty"`
+	SourceIPCIDR         Listable[string]    `json:"source_ip_cidr,omitempty"`
+	IPCIDR               Listable[string]    `json:"ip_cidr,omitempty"`
+	SourcePort           Listable[uint16]    `json:"source_port,omitempty"`
+	SourcePortRange      Listable[string]    `json:"source_port_range,omitempty"`
+	Port                 Listable[uint16]    `json:"port,omitempty"`
+	PortRange            Listable[string]    `json:"port_range,omitempty"`
+	ProcessName          Listable[string]    `json:"process_name,omitempty"`
+	ProcessPath          Listable[string]    `json:"process_path,omitempty"`
+	ProcessPathRegex     Listable[string]    `json:"process_path_regex,omitempty"`
+	PackageName          Listable[string]    `json:"package_name,omitempty"`
+	NetworkType          Listable[string]    `json:"network_type,omitempty"`
+	NetworkIsExpensive   bool                `json:"network_is_expensive,omitempty"`
+	NetworkIsConstrained bool                `json:"network_is_constrained,omitempty"`
+	WIFISSID             Listable[string]    `json:"wifi_ssid,omitempty"`
+	WIFIBSSID            Listable[string]    `json:"wifi_bssid,omitempty"`
+
+	Mode  string         `json:"mode,omitempty"`
+	Rules []HeadlessRule `json:"rules,omitempty"`
+
+	Invert bool `json:"invert,omitempty"`
+
+	matcher *matcher
+}
+
+// IsLogical reports whether the rule combines sub rules
+func (r *HeadlessRule) IsLogical() bool {
+	return r.Type == "logical"
+}
+
+// IsEmpty reports whether a default rule has no items at all
+func (r *HeadlessRule) IsEmpty() bool {
+	if r.IsLogical() {
+		return len(r.Rules) == 0
+	}
+	return len(r.QueryType) == 0 && len(r.Network) == 0 && len(r.Domain) == 0 &&
+		len(r.DomainSuffix) == 0 && len(r.DomainKeyword) == 0 && len(r.DomainRegex) == 0 &&
+		len(r.SourceIPCIDR) == 0 && len(r.IPCIDR) == 0 && len(r.SourcePort) == 0 &&
+		len(r.SourcePortRange) == 0 && len(r.Port) == 0 && len(r.PortRange) == 0 &&
+		len(r.ProcessName) == 0 && len(r.ProcessPath) == 0 && len(r.ProcessPathRegex) == 0 &&
+		len(r.PackageName) == 0 && len(r.NetworkType) == 0 && !r.NetworkIsExpensive &&
+		!r.NetworkIsConstrained && len(r.WIFISSID) == 0 && len(r.WIFIBSSID) == 0
+}
+
+// Parse decodes and compiles a source rule set
+func Parse(data []byte) (*PlainRuleSet, error) {
+	var rs PlainRuleSet
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("invalid rule set JSON: %w", err)
+	}
+	if rs.Version < Version1 || rs.Version > Version3 {
+		return nil, fmt.Errorf("unsupported rule set version %d", rs.Version)
+	}
+	if err := rs.Compile(); err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// ReadFile parses the source rule set at path
+func ReadFile(path string) (*PlainRuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Compile prepares every rule for matching and reports the first invalid one
+func (rs *PlainRuleSet) Compile() error {
+	for i := range rs.Rules {
+		if err := rs.Rules[i].Compile(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether any rule of the set matches m
+func (rs *PlainRuleSet) Match(m *Metadata) bool {
+	for i := range rs.Rules {
+		if rs.Rules[i].Match(m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package srs
+
+import (
+	"bufio"
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Labels sing-box puts in front of a domain before reversing it into the trie
+const (
+	// prefixLabel marks a suffix that only matches subdomains, ".example.com"
+	prefixLabel = '\r'
+	// rootLabel marks a suffix that also matches the domain itself, version 2 and later
+	rootLabel = '\n'
+)
+
+// readDomainMatcher decodes the domain item into domain and domain_suffix lists
+func readDomainMatcher(r *bufio.Reader) ([]string, []string, error) {
+	// The matcher starts with a reserved byte, 1 from sing-box 1.9 and earlier and
+	// 0 since. sing-box never checks it.
+	if _, err := r.ReadByte(); err != nil {
+		return nil, nil, err
+	}
+	set, err := readSuccinctSet(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err := set.keys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	domains := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	var suffixes []string
+	for _, key := range keys {
+		key = reverseDomain(key)
+		if key == "" {
+			continue
+		}
+		switch key[0] {
+		case prefixLabel:
+			prefixes[key[1:]] = true
+		case rootLabel:
+			suffixes = append(suffixes, key[1:])
+		default:
+			domains[key] = true
+		}
+	}
+	// Version 1 writes a suffix as the domain plus a subdomain-only prefix, join them again
+	for prefix := range prefixes {
+		if root := strings.TrimPrefix(prefix, "."); root != prefix && domains[root] {
+			delete(domains, root)
+			suffixes = append(suffixes, root)
+			continue
+		}
+		suffixes = append(suffixes, prefix)
+	}
+	domainList := make([]string, 0, len(domains))
+	for d := range domains {
+		domainList = append(domainList, d)
+	}
+	sort.Strings(domainList)
+	sort.Strings(suffixes)
+	return domainList, suffixes, nil
+}
+
+// writeDomainMatcher encodes domain and domain_suffix the way sing-box builds its
+// matcher. Legacy (version 1) files cannot express a suffix that includes the
+// domain itself, so it is written as the domain and a subdomain-only suffix.
+func writeDomainMatcher(w *bufio.Writer, domains, suffixes []string, legacy bool) {
+	keys := make([]string, 0, len(domains)+2*len(suffixes))
+	seen := make(map[string]bool, cap(keys))
+	for _, suffix := range suffixes {
+		if suffix == "" || seen[suffix] {
+			continue
+		}
+		seen[suffix] = true
+		switch {
+		case suffix[0] == '.':
+			keys = append(keys, reverseDomain(string(prefixLabel)+suffix))
+		case legacy:
+			keys = append(keys, reverseDomain(suffix))
+			if sub := "." + suffix; !seen[sub] {
+				seen[sub] = true
+				keys = append(keys, reverseDomain(string(prefixLabel)+sub))
+			}
+		default:
+			keys = append(keys, reverseDomain(string(rootLabel)+suffix))
+		}
+	}
+	for _, domain := range domains {
+		if domain == "" || seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		keys = append(keys, reverseDomain(domain))
+	}
+	sort.Strings(keys)
+
+	w.WriteByte(0)
+	newSuccinctSet(keys).write(w)
+}
+
+func reverseDomain(domain string) string {
+	l := len(domain)
+	b := make([]byte, l)
+	for i := 0; i < l; {
+		r, n := utf8.DecodeRuneInString(domain[i:])
+		i += n
+		utf8.EncodeRune(b[l-i:], r)
+	}
+	return string(b)
+}
+
+// succinctSet is the LOUDS-encoded trie sing-box stores domains in. Nodes are
+// numbered breadth first. labelBitmap holds a 0 per child label followed by a 1
+// for every node, labels the child labels in the same order and leaves a bit per
+// node that ends a key.
+type succinctSet struct {
+	leaves, labelBitmap []uint64
+	labels              []byte
+}
+
+// newSuccinctSet builds the trie from sorted keys
+func newSuccinctSet(keys []string) *succinctSet {
+	ss := &succinctSet{}
+	type span struct{ start, end, col int }
+	queue := []span{{0, len(keys), 0}}
+	bit := 0
+	for i := 0; i < len(queue); i++ {
+		node := queue[i]
+		if node.start < node.end && node.col == len(keys[node.start]) {
+			node.start++
+			setBit(&ss.leaves, i)
+		}
+		for j := node.start; j < node.end; {
+			from := j
+			for ; j < node.end && keys[j][node.col] == keys[from][node.col]; j++ {
+			}
+			queue = append(queue, span{from, j, node.col + 1})
+			ss.labels = append(ss.labels, keys[from][node.col])
+			growBits(&ss.labelBitmap, bit)
+			bit++
+		}
+		setBit(&ss.labelBitmap, bit)
+		bit++
+	}
+	return ss
+}
+
+// keys walks the trie breadth first and returns every stored key
+func (ss *succinctSet) keys() ([]string, error) {
+	var keys []string
+	prefixes := []string{""}
+	label := 0
+	bit := 0
+	for node := 0; node < len(prefixes); node++ {
+		if getBit(ss.leaves, node) {
+			keys = append(keys, prefixes[node])
+		}
+		for {
+			if bit >= len(ss.labelBitmap)*64 {
+				return nil, errors.New("truncated domain trie")
+			}
+			if getBit(ss.labelBitmap, bit) {
+				bit++
+				break
+			}
+			if label >= len(ss.labels) {
+				return nil, errors.New("truncated domain trie labels")
+			}
+			prefixes = append(prefixes, prefixes[node]+string(ss.labels[label:label+1]))
+			label++
+			bit++
+		}
+		// Parents are not visited again, drop their prefix early
+		prefixes[node] = ""
+	}
+	return keys, nil
+}
+
+func readSuccinctSet(r *bufio.Reader) (*succinctSet, error) {
+	var ss succinctSet
+	var err error
+	if ss.leaves, err = readUint64s(r); err != nil {
+		return nil, err
+	}
+	if ss.labelBitmap, err = readUint64s(r); err != nil {
+		return nil, err
+	}
+	if ss.labels, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	return &ss, nil
+}
+
+func (ss *succinctSet) write(w *bufio.Writer) {
+	writeUint64s(w, ss.leaves)
+	writeUint64s(w, ss.labelBitmap)
+	writeBytes(w, ss.labels)
+}
+
+func growBits(bm *[]uint64, i int) {
+	for i>>6 >= len(*bm) {
+		*bm = append(*bm, 0)
+	}
+}
+
+func setBit(bm *[]uint64, i int) {
+	growBits(bm, i)
+	(*bm)[i>>6] |= 1 << uint(i&63)
+}
+
+func getBit(bm []uint64, i int) bool {
+	if i>>6 >= len(bm) {
+		return false
+	}
+	return bm[i>>6]&(1<<uint(i&63)) != 0
+}
@@ -0,0 +1,165 @@
+package srs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"sort"
+
+	"singbox.arrow.web2/internal/core/ruleset/source"
+)
+
+const ipSetVersion = 1
+
+// ipRange is an inclusive address range, an IP set is stored as sorted, merged ranges
+type ipRange struct {
+	from, to netip.Addr
+}
+
+// readIPSet decodes an IP set into the smallest list of CIDRs covering it
+func readIPSet(r *bufio.Reader) ([]string, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != ipSetVersion {
+		return nil, errors.New("unknown IP set version")
+	}
+	var count uint64
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	if count > maxCount {
+		return nil, fmt.Errorf("IP set length %d too large", count)
+	}
+
+	var cidrs []string
+	for i := uint64(0); i < count; i++ {
+		from, err := readAddr(r)
+		if err != nil {
+			return nil, err
+		}
+		to, err := readAddr(r)
+		if err != nil {
+			return nil, err
+		}
+		if from.Is4() != to.Is4() || to.Less(from) {
+			return nil, errors.New("invalid IP range")
+		}
+		for _, p := range rangePrefixes(from, to) {
+			cidrs = append(cidrs, p.String())
+		}
+	}
+	return cidrs, nil
+}
+
+func readAddr(r *bufio.Reader) (netip.Addr, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if n != 4 && n != 16 {
+		return netip.Addr{}, fmt.Errorf("invalid address length %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return netip.Addr{}, err
+	}
+	addr, _ := netip.AddrFromSlice(buf)
+	return addr, nil
+}
+
+// writeIPSet encodes CIDRs and addresses as the sorted, merged ranges sing-box expects
+func writeIPSet(w *bufio.Writer, itemType uint8, values []string) error {
+	ranges := make([]ipRange, 0, len(values))
+	for _, v := range values {
+		p, err := source.ParsePrefix(v)
+		if err != nil {
+			return err
+		}
+		ranges = append(ranges, ipRange{from: p.Addr(), to: lastAddr(p)})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].from.Less(ranges[j].from)
+	})
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.from.Is4() == r.from.Is4() && (!last.to.Less(r.from) || last.to.Next() == r.from) {
+				if last.to.Less(r.to) {
+					last.to = r.to
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	w.WriteByte(itemType)
+	w.WriteByte(ipSetVersion)
+	binary.Write(w, binary.BigEndian, uint64(len(merged)))
+	for _, r := range merged {
+		writeBytes(w, r.from.AsSlice())
+		writeBytes(w, r.to.AsSlice())
+	}
+	return nil
+}
+
+// lastAddr returns the highest address in p
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().As16()
+	bitLen := p.Addr().BitLen()
+	offset := 128 - bitLen
+	for i := p.Bits() + offset; i < 128; i++ {
+		b[i/8] |= 1 << (7 - uint(i%8))
+	}
+	addr := netip.AddrFrom16(b)
+	if p.Addr().Is4() {
+		return addr.Unmap()
+	}
+	return addr
+}
+
+// rangePrefixes splits an inclusive range into the CIDRs covering exactly it
+func rangePrefixes(from, to netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	bitLen := from.BitLen()
+	for {
+		// The largest block starting at from that does not pass to
+		bitsFree := trailingZeros(from, bitLen)
+		for bitsFree > 0 {
+			p := netip.PrefixFrom(from, bitLen-bitsFree)
+			if !to.Less(lastAddr(p)) {
+				break
+			}
+			bitsFree--
+		}
+		p := netip.PrefixFrom(from, bitLen-bitsFree)
+		prefixes = append(prefixes, p)
+		last := lastAddr(p)
+		if last == to {
+			return prefixes
+		}
+		from = last.Next()
+	}
+}
+
+// trailingZeros counts the zero bits at the end of addr, bitLen for the zero address
+func trailingZeros(addr netip.Addr, bitLen int) int {
+	b := addr.As16()
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	n := bits.TrailingZeros64(lo)
+	if lo == 0 {
+		n = 64 + bits.TrailingZeros64(hi)
+	}
+	if n > bitLen {
+		n = bitLen
+	}
+	return n
+}
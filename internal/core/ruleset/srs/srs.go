@@ -0,0 +1,489 @@
+// Package srs reads and writes the sing-box binary rule-set format (.srs) and
+// converts it to and from the source form.
+//
+// A file is the magic "SRS", a version byte and a zlib stream holding the rule
+// count followed by the rules. Each default rule is a list of typed items ended
+// by a final item carrying the invert flag.
+package srs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"singbox.arrow.web2/internal/core/ruleset/source"
+)
+
+// Magic starts every binary rule set
+var Magic = [3]byte{'S', 'R', 'S'}
+
+const (
+	ruleTypeDefault uint8 = 0
+	ruleTypeLogical uint8 = 1
+
+	logicalAnd uint8 = 0
+	logicalOr  uint8 = 1
+)
+
+// Rule item types, in the order sing-box assigns them
+const (
+	itemQueryType uint8 = iota
+	itemNetwork
+	itemDomain
+	itemDomainKeyword
+	itemDomainRegex
+	itemSourceIPCIDR
+	itemIPCIDR
+	itemSourcePort
+	itemSourcePortRange
+	itemPort
+	itemPortRange
+	itemProcessName
+	itemProcessPath
+	itemPackageName
+	itemWIFISSID
+	itemWIFIBSSID
+	itemAdGuardDomain
+	itemProcessPathRegex
+	itemNetworkType
+	itemNetworkIsExpensive
+	itemNetworkIsConstrained
+	itemFinal uint8 = 0xFF
+)
+
+// networkTypes maps network_type names to their binary values
+var networkTypes = []string{"wifi", "cellular", "ethernet", "other"}
+
+// maxCount bounds lengths read from a file so a corrupt one cannot exhaust memory
+const maxCount = 1 << 24
+
+// Read decodes a binary rule set into its source form
+func Read(r io.Reader) (*source.PlainRuleSet, error) {
+	var magic [3]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, fmt.Errorf("missing SRS header: %w", err)
+	}
+	if magic != Magic {
+		return nil, errors.New("missing SRS header")
+	}
+	var version uint8
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, fmt.Errorf("missing version: %w", err)
+	}
+	if version < source.Version1 || version > source.Version3 {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed data: %w", err)
+	}
+	defer zr.Close()
+	br := bufio.NewReader(zr)
+
+	count, err := readCount(br)
+	if err != nil {
+		return nil, err
+	}
+	rs := &source.PlainRuleSet{Version: int(version), Rules: make([]source.HeadlessRule, 0, count)}
+	for i := 0; i < count; i++ {
+		rule, err := readRule(br)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		rs.Rules = append(rs.Rules, rule)
+	}
+	return rs, nil
+}
+
+// Decode is Read for a file already in memory
+func Decode(data []byte) (*source.PlainRuleSet, error) {
+	return Read(bytes.NewReader(data))
+}
+
+// ReadFile decodes the binary rule set at path
+func ReadFile(path string) (*source.PlainRuleSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(bufio.NewReader(f))
+}
+
+// Write encodes rs in the binary format. The file version is rs.Version, raised
+// to the lowest version that can hold every item used.
+func Write(w io.Writer, rs *source.PlainRuleSet) error {
+	version := uint8(rs.Version)
+	if version < source.Version1 {
+		version = source.CurrentVersion
+	}
+	for i := range rs.Rules {
+		if needed := requiredVersion(&rs.Rules[i]); needed > version {
+			version = needed
+		}
+	}
+
+	if _, err := w.Write(Magic[:]); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, version); err != nil {
+		return err
+	}
+	zw, err := zlib.NewWriterLevel(w, zlib.BestCompression)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(zw)
+	writeUvarint(bw, uint64(len(rs.Rules)))
+	for i := range rs.Rules {
+		if err := writeRule(bw, &rs.Rules[i], version); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Encode is Write into memory
+func Encode(rs *source.PlainRuleSet) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Write(&buf, rs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func requiredVersion(rule *source.HeadlessRule) uint8 {
+	if rule.IsLogical() {
+		version := uint8(source.Version1)
+		for i := range rule.Rules {
+			if v := requiredVersion(&rule.Rules[i]); v > version {
+				version = v
+			}
+		}
+		return version
+	}
+	if len(rule.NetworkType) > 0 || rule.NetworkIsExpensive || rule.NetworkIsConstrained {
+		return source.Version3
+	}
+	return source.Version1
+}
+
+func readRule(r *bufio.Reader) (source.HeadlessRule, error) {
+	ruleType, err := r.ReadByte()
+	if err != nil {
+		return source.HeadlessRule{}, err
+	}
+	switch ruleType {
+	case ruleTypeDefault:
+		return readDefaultRule(r)
+	case ruleTypeLogical:
+		return readLogicalRule(r)
+	default:
+		return source.HeadlessRule{}, fmt.Errorf("unknown rule type %d", ruleType)
+	}
+}
+
+func readDefaultRule(r *bufio.Reader) (source.HeadlessRule, error) {
+	var rule source.HeadlessRule
+	for {
+		itemType, err := r.ReadByte()
+		if err != nil {
+			return rule, err
+		}
+		switch itemType {
+		case itemQueryType:
+			values, err := readUint16s(r)
+			if err != nil {
+				return rule, err
+			}
+			for _, v := range values {
+				rule.QueryType = append(rule.QueryType, source.QueryType(v))
+			}
+		case itemNetwork:
+			rule.Network, err = readStrings(r)
+		case itemDomain:
+			rule.Domain, rule.DomainSuffix, err = readDomainMatcher(r)
+		case itemDomainKeyword:
+			rule.DomainKeyword, err = readStrings(r)
+		case itemDomainRegex:
+			rule.DomainRegex, err = readStrings(r)
+		case itemSourceIPCIDR:
+			rule.SourceIPCIDR, err = readIPSet(r)
+		case itemIPCIDR:
+			rule.IPCIDR, err = readIPSet(r)
+		case itemSourcePort:
+			rule.SourcePort, err = readUint16s(r)
+		case itemSourcePortRange:
+			rule.SourcePortRange, err = readStrings(r)
+		case itemPort:
+			rule.Port, err = readUint16s(r)
+		case itemPortRange:
+			rule.PortRange, err = readStrings(r)
+		case itemProcessName:
+			rule.ProcessName, err = readStrings(r)
+		case itemProcessPath:
+			rule.ProcessPath, err = readStrings(r)
+		case itemProcessPathRegex:
+			rule.ProcessPathRegex, err = readStrings(r)
+		case itemPackageName:
+			rule.PackageName, err = readStrings(r)
+		case itemWIFISSID:
+			rule.WIFISSID, err = readStrings(r)
+		case itemWIFIBSSID:
+			rule.WIFIBSSID, err = readStrings(r)
+		case itemNetworkType:
+			var values []byte
+			if values, err = readBytes(r); err == nil {
+				for _, v := range values {
+					if int(v) >= len(networkTypes) {
+						return rule, fmt.Errorf("unknown network type %d", v)
+					}
+					rule.NetworkType = append(rule.NetworkType, networkTypes[v])
+				}
+			}
+		case itemNetworkIsExpensive:
+			rule.NetworkIsExpensive = true
+		case itemNetworkIsConstrained:
+			rule.NetworkIsConstrained = true
+		case itemAdGuardDomain:
+			return rule, errors.New("adguard_domain items are not supported")
+		case itemFinal:
+			invert, err := r.ReadByte()
+			rule.Invert = invert != 0
+			return rule, err
+		default:
+			return rule, fmt.Errorf("unknown rule item type %d", itemType)
+		}
+		if err != nil {
+			return rule, err
+		}
+	}
+}
+
+func readLogicalRule(r *bufio.Reader) (source.HeadlessRule, error) {
+	rule := source.HeadlessRule{Type: "logical"}
+	mode, err := r.ReadByte()
+	if err != nil {
+		return rule, err
+	}
+	switch mode {
+	case logicalAnd:
+		rule.Mode = "and"
+	case logicalOr:
+		rule.Mode = "or"
+	default:
+		return rule, fmt.Errorf("unknown logical mode %d", mode)
+	}
+	count, err := readCount(r)
+	if err != nil {
+		return rule, err
+	}
+	for i := 0; i < count; i++ {
+		sub, err := readRule(r)
+		if err != nil {
+			return rule, err
+		}
+		rule.Rules = append(rule.Rules, sub)
+	}
+	invert, err := r.ReadByte()
+	rule.Invert = invert != 0
+	return rule, err
+}
+
+// writeRule writes the items in the order sing-box does, so compiling the same
+// source gives the same file
+func writeRule(w *bufio.Writer, rule *source.HeadlessRule, version uint8) error {
+	if rule.IsLogical() {
+		w.WriteByte(ruleTypeLogical)
+		switch rule.Mode {
+		case "and":
+			w.WriteByte(logicalAnd)
+		case "or":
+			w.WriteByte(logicalOr)
+		default:
+			return fmt.Errorf("invalid logical mode %q", rule.Mode)
+		}
+		writeUvarint(w, uint64(len(rule.Rules)))
+		for i := range rule.Rules {
+			if err := writeRule(w, &rule.Rules[i], version); err != nil {
+				return err
+			}
+		}
+		writeBool(w, rule.Invert)
+		return nil
+	}
+
+	w.WriteByte(ruleTypeDefault)
+	if len(rule.QueryType) > 0 {
+		values := make([]uint16, 0, len(rule.QueryType))
+		for _, t := range rule.QueryType {
+			values = append(values, uint16(t))
+		}
+		writeUint16s(w, itemQueryType, values)
+	}
+	writeStrings(w, itemNetwork, rule.Network)
+	if len(rule.Domain) > 0 || len(rule.DomainSuffix) > 0 {
+		w.WriteByte(itemDomain)
+		writeDomainMatcher(w, rule.Domain, rule.DomainSuffix, version == source.Version1)
+	}
+	writeStrings(w, itemDomainKeyword, rule.DomainKeyword)
+	writeStrings(w, itemDomainRegex, rule.DomainRegex)
+	if len(rule.SourceIPCIDR) > 0 {
+		if err := writeIPSet(w, itemSourceIPCIDR, rule.SourceIPCIDR); err != nil {
+			return err
+		}
+	}
+	if len(rule.IPCIDR) > 0 {
+		if err := writeIPSet(w, itemIPCIDR, rule.IPCIDR); err != nil {
+			return err
+		}
+	}
+	writeUint16s(w, itemSourcePort, rule.SourcePort)
+	writeStrings(w, itemSourcePortRange, rule.SourcePortRange)
+	writeUint16s(w, itemPort, rule.Port)
+	writeStrings(w, itemPortRange, rule.PortRange)
+	writeStrings(w, itemProcessName, rule.ProcessName)
+	writeStrings(w, itemProcessPath, rule.ProcessPath)
+	writeStrings(w, itemProcessPathRegex, rule.ProcessPathRegex)
+	writeStrings(w, itemPackageName, rule.PackageName)
+	if len(rule.NetworkType) > 0 {
+		values := make([]byte, 0, len(rule.NetworkType))
+		for _, name := range rule.NetworkType {
+			value := -1
+			for i, known := range networkTypes {
+				if known == name {
+					value = i
+				}
+			}
+			if value < 0 {
+				return fmt.Errorf("unknown network type %q", name)
+			}
+			values = append(values, byte(value))
+		}
+		w.WriteByte(itemNetworkType)
+		writeUvarint(w, uint64(len(values)))
+		w.Write(values)
+	}
+	if rule.NetworkIsExpensive {
+		w.WriteByte(itemNetworkIsExpensive)
+	}
+	if rule.NetworkIsConstrained {
+		w.WriteByte(itemNetworkIsConstrained)
+	}
+	writeStrings(w, itemWIFISSID, rule.WIFISSID)
+	writeStrings(w, itemWIFIBSSID, rule.WIFIBSSID)
+	w.WriteByte(itemFinal)
+	writeBool(w, rule.Invert)
+	return nil
+}
+
+func readCount(r *bufio.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > maxCount {
+		return 0, fmt.Errorf("length %d too large", n)
+	}
+	return int(n), nil
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+func readStrings(r *bufio.Reader) ([]string, error) {
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		data, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, string(data))
+	}
+	return values, nil
+}
+
+func readUint16s(r *bufio.Reader) ([]uint16, error) {
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint16, n)
+	err = binary.Read(r, binary.BigEndian, values)
+	return values, err
+}
+
+func readUint64s(r *bufio.Reader) ([]uint64, error) {
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint64, n)
+	err = binary.Read(r, binary.BigEndian, values)
+	return values, err
+}
+
+// Writes go to a bufio.Writer, which keeps the first error and reports it on Flush
+
+func writeUvarint(w *bufio.Writer, n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], n)])
+}
+
+func writeBool(w *bufio.Writer, v bool) {
+	if v {
+		w.WriteByte(1)
+	} else {
+		w.WriteByte(0)
+	}
+}
+
+func writeBytes(w *bufio.Writer, data []byte) {
+	writeUvarint(w, uint64(len(data)))
+	w.Write(data)
+}
+
+func writeStrings(w *bufio.Writer, itemType uint8, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	w.WriteByte(itemType)
+	writeUvarint(w, uint64(len(values)))
+	for _, v := range values {
+		writeBytes(w, []byte(v))
+	}
+}
+
+func writeUint16s(w *bufio.Writer, itemType uint8, values []uint16) {
+	if len(values) == 0 {
+		return
+	}
+	w.WriteByte(itemType)
+	writeUvarint(w, uint64(len(values)))
+	binary.Write(w, binary.BigEndian, values)
+}
+
+func writeUint64s(w *bufio.Writer, values []uint64) {
+	writeUvarint(w, uint64(len(values)))
+	binary.Write(w, binary.BigEndian, values)
+}
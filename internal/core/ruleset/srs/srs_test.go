@@ -0,0 +1,234 @@
+package srs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"singbox.arrow.web2/internal/core/ruleset/source"
+)
+
+// The .srs fixtures in testdata were compiled from the .json sources next to them
+// with sing-box rule-set compile, each by the first release writing that version.
+var fixtures = []struct {
+	name    string
+	version uint8
+}{
+	{"v1", source.Version1}, // sing-box 1.8.0, domain suffixes as one subdomain prefix
+	{"v2", source.Version2}, // sing-box 1.10.0, domain suffixes behind the root label
+	{"v3", source.Version3}, // sing-box 1.11.0, network_type and network_is_expensive
+}
+
+func readFixture(t *testing.T, name string) ([]byte, *source.PlainRuleSet) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name+".srs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := source.ReadFile(filepath.Join("testdata", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, src
+}
+
+func assertSameRules(t *testing.T, got, want *source.PlainRuleSet) {
+	t.Helper()
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("rules differ\n got %s\nwant %s", gotJSON, wantJSON)
+	}
+}
+
+func TestDecodeFixtures(t *testing.T) {
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			data, src := readFixture(t, f.name)
+			if data[3] != f.version {
+				t.Fatalf("fixture version = %d, want %d", data[3], f.version)
+			}
+			rs, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			assertSameRules(t, rs, src)
+		})
+	}
+}
+
+func TestRoundTripFixtures(t *testing.T) {
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			data, _ := readFixture(t, f.name)
+			first, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			encoded, err := Encode(first)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if encoded[3] != f.version {
+				t.Errorf("encoded version = %d, want %d", encoded[3], f.version)
+			}
+			second, err := Decode(encoded)
+			if err != nil {
+				t.Fatalf("Decode encoded: %v", err)
+			}
+			assertSameRules(t, second, first)
+		})
+	}
+}
+
+func TestEncodeSource(t *testing.T) {
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			_, src := readFixture(t, f.name)
+			encoded, err := Encode(src)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			rs, err := Decode(encoded)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			assertSameRules(t, rs, src)
+		})
+	}
+}
+
+// inflate returns the rule stream of a file without its header
+func inflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := zlib.NewReader(bytes.NewReader(data[4:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	stream, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+// Since sing-box 1.10 the encoder writes what sing-box does. Version 1 files are
+// skipped, sing-box 1.8 wrote domain suffixes before the legacy split existed.
+func TestEncodeMatchesSingbox(t *testing.T) {
+	for _, f := range fixtures {
+		if f.version == source.Version1 {
+			continue
+		}
+		t.Run(f.name, func(t *testing.T) {
+			data, src := readFixture(t, f.name)
+			encoded, err := Encode(src)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if !bytes.Equal(inflate(t, encoded), inflate(t, data)) {
+				t.Errorf("encoded stream differs from sing-box")
+			}
+		})
+	}
+}
+
+func TestDecodedRulesMatch(t *testing.T) {
+	data, _ := readFixture(t, "v1")
+	rs, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rule     int
+		metadata source.Metadata
+		want     bool
+	}{
+		{0, source.Metadata{Domain: "example.com"}, true},
+		{0, source.Metadata{Domain: "www.example.com"}, true},
+		{0, source.Metadata{Domain: "badexample.com"}, false},
+		{0, source.Metadata{Domain: "cdn.example.net"}, false},
+		{0, source.Metadata{Domain: "a.cdn.example.net"}, true},
+		{0, source.Metadata{Domain: "example.org"}, true},
+		{0, source.Metadata{Domain: "mail.example.org"}, false},
+		{0, source.Metadata{Domain: "myads.test"}, true},
+		{1, source.Metadata{IP: netip.MustParseAddr("10.1.2.3"), Port: 443, Network: "tcp"}, true},
+		{1, source.Metadata{IP: netip.MustParseAddr("10.1.2.3"), Port: 443, Network: "udp"}, false},
+		{1, source.Metadata{IP: netip.MustParseAddr("11.1.2.3"), Port: 443, Network: "tcp"}, false},
+		{2, source.Metadata{Domain: "stun.example.com", Port: 1500}, true},
+		{2, source.Metadata{Domain: "www.example.com", Port: 1500}, false},
+		{2, source.Metadata{Domain: "www.example.com", Port: 443}, true},
+	}
+	for _, tt := range tests {
+		if got := rs.Rules[tt.rule].Match(&tt.metadata); got != tt.want {
+			t.Errorf("rule %d Match(%+v) = %v, want %v", tt.rule, tt.metadata, got, tt.want)
+		}
+	}
+}
+
+// matcherKeys returns the trie keys the domain item is written with, unreversed
+func matcherKeys(t *testing.T, domains, suffixes []string, legacy bool) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeDomainMatcher(w, domains, suffixes, legacy)
+	w.Flush()
+
+	r := bufio.NewReader(&buf)
+	if reserved, _ := r.ReadByte(); reserved != 0 {
+		t.Fatalf("matcher reserved byte = %d", reserved)
+	}
+	set, err := readSuccinctSet(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := set.keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		keys[i] = reverseDomain(key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestDomainMatcherLabels(t *testing.T) {
+	legacy := matcherKeys(t, []string{"a.test"}, []string{"example.com", ".sub.test"}, true)
+	if want := []string{"\r.example.com", "\r.sub.test", "a.test", "example.com"}; !reflect.DeepEqual(legacy, want) {
+		t.Errorf("legacy keys = %q, want %q", legacy, want)
+	}
+	current := matcherKeys(t, []string{"a.test"}, []string{"example.com", ".sub.test"}, false)
+	if want := []string{"\nexample.com", "\r.sub.test", "a.test"}; !reflect.DeepEqual(current, want) {
+		t.Errorf("keys = %q, want %q", current, want)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	data, _ := readFixture(t, "v2")
+	tests := map[string][]byte{
+		"empty":       nil,
+		"bad magic":   append([]byte("SRX"), data[3:]...),
+		"version 0":   append([]byte("SRS\x00"), data[4:]...),
+		"version 9":   append([]byte("SRS\x09"), data[4:]...),
+		"truncated":   data[:len(data)/2],
+		"not zlib":    []byte("SRS\x02plain"),
+		"header only": data[:4],
+	}
+	for name, input := range tests {
+		if _, err := Decode(input); err == nil {
+			t.Errorf("%s: Decode succeeded", name)
+		}
+	}
+}
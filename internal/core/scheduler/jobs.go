@@ -0,0 +1,233 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"singbox.arrow.web2/internal/core/ruleset"
+	"singbox.arrow.web2/internal/core/singbox"
+	"singbox.arrow.web2/internal/core/subscription"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// BackupDir holds database and config backups, relative to the data directory
+const BackupDir = "backups"
+
+// Outcome is what a job run did. Changed asks for a config reload.
+type Outcome struct {
+	Changed bool
+	Detail  string
+}
+
+// due reports whether an item refreshed every interval hours is due. Items without
+// an interval are only refreshed by hand.
+func due(interval int, last *time.Time, now time.Time) bool {
+	if interval <= 0 {
+		return false
+	}
+	return last == nil || now.Sub(*last) >= time.Duration(interval)*time.Hour
+}
+
+// refreshSubscriptions refreshes the enabled subscriptions whose update interval
+// has passed, or all enabled ones when force is set
+func refreshSubscriptions(force bool) (*Outcome, error) {
+	var subs []storage.Subscription
+	if err := storage.DB.Where("enabled = ?", true).Order("id").Find(&subs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load subscriptions: %w", err)
+	}
+
+	now := time.Now()
+	outcome := &Outcome{}
+	var refreshed, changed int
+	var failures []string
+	for i := range subs {
+		sub := &subs[i]
+		if !force && !due(sub.UpdateInterval, sub.LastUpdate, now) {
+			continue
+		}
+		refreshed++
+		summary, err := subscription.Refresh(sub)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", sub.Name, err))
+			continue
+		}
+		if summary.Changed() {
+			changed++
+			outcome.Changed = true
+		}
+		storage.DB.Create(&storage.OperationLog{
+			Action:     "subscription_refresh",
+			Detail:     summary.Describe(sub.Name),
+			Actor:      ActorScheduler,
+			TargetType: "subscription",
+			TargetID:   sub.ID,
+			TargetName: sub.Name,
+			CreatedAt:  time.Now(),
+		})
+	}
+	outcome.Detail = fmt.Sprintf("%d refreshed, %d changed, %d failed", refreshed, changed, len(failures))
+	return outcome, joinFailures(failures)
+}
+
+// refreshRulesets downloads the enabled remote rule sets whose update interval has
+// passed, or all enabled remote ones when force is set
+func refreshRulesets(force bool) (*Outcome, error) {
+	var rulesets []storage.Ruleset
+	if err := storage.DB.Where("type = ? AND enabled = ?", "remote", true).Order("id").Find(&rulesets).Error; err != nil {
+		return nil, fmt.Errorf("failed to load rulesets: %w", err)
+	}
+
+	now := time.Now()
+	outcome := &Outcome{}
+	var refreshed, changed int
+	var failures []string
+	for i := range rulesets {
+		rs := &rulesets[i]
+		// A rule set that was never downloaded is due regardless of its interval
+		if !force && ruleset.LocalFile(rs) != "" && !due(rs.UpdateInterval, rs.LastUpdate, now) {
+			continue
+		}
+		refreshed++
+		result, err := ruleset.Update(rs)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", rs.Name, err))
+			continue
+		}
+		if result.Changed {
+			changed++
+			outcome.Changed = true
+		}
+	}
+	outcome.Detail = fmt.Sprintf("%d refreshed, %d changed, %d failed", refreshed, changed, len(failures))
+	return outcome, joinFailures(failures)
+}
+
+// checkBinary looks up the latest sing-box release. It only reports an update,
+// installing it stays a manual action.
+func checkBinary(bool) (*Outcome, error) {
+	latest, err := singbox.GetLatestVersion()
+	if err != nil {
+		return nil, err
+	}
+	storage.SetRuntimeState(singbox.LatestVersionKey, latest)
+
+	installed, _ := storage.GetRuntimeState(singbox.InstalledVersionKey)
+	switch {
+	case installed == "":
+		return &Outcome{Detail: fmt.Sprintf("latest release %s, installed version unknown", latest)}, nil
+	case installed != latest:
+		return &Outcome{Detail: fmt.Sprintf("update available: %s (installed %s)", latest, installed)}, nil
+	default:
+		return &Outcome{Detail: fmt.Sprintf("up to date (%s)", installed)}, nil
+	}
+}
+
+// pruneLogs deletes operation logs and job runs older than log_retention_days, 0
+// keeps everything, and rotated core log files beyond their own limits
+func pruneLogs(bool) (*Outcome, error) {
+	files := singbox.GetManager(dataDir).LogFiles()
+	files.SetLimits(singbox.LogFileLimits())
+	removedFiles := files.Prune()
+
+	days := storage.GetSettingInt("log_retention_days", 30)
+	if days <= 0 {
+		return &Outcome{Detail: fmt.Sprintf("retention disabled, removed %d core log files", removedFiles)}, nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	logs := storage.DB.Where("created_at < ?", cutoff).Delete(&storage.OperationLog{})
+	if logs.Error != nil {
+		return nil, fmt.Errorf("failed to prune operation logs: %w", logs.Error)
+	}
+	runs := storage.DB.Where("started_at < ? AND status <> ?", cutoff, StatusRunning).Delete(&storage.JobRun{})
+	if runs.Error != nil {
+		return nil, fmt.Errorf("failed to prune job runs: %w", runs.Error)
+	}
+	return &Outcome{Detail: fmt.Sprintf("removed %d operation logs and %d job runs older than %d days, %d core log files",
+		logs.RowsAffected, runs.RowsAffected, days, removedFiles)}, nil
+}
+
+// backup writes a consistent copy of the database and the current config to
+// backups/, keeping the newest backup_keep of each
+func backup(bool) (*Outcome, error) {
+	dir := filepath.Join(dataDir, BackupDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	stamp := time.Now().Format("20060102_150405")
+
+	dbFile := filepath.Join(dir, "singbox-web_"+stamp+".db")
+	if err := storage.DB.Exec("VACUUM INTO ?", dbFile).Error; err != nil {
+		return nil, fmt.Errorf("failed to back up database: %w", err)
+	}
+	files := []string{filepath.Base(dbFile)}
+
+	configFile := filepath.Join(dir, "config_"+stamp+".json")
+	if err := copyFile(filepath.Join(dataDir, "config.json"), configFile); err == nil {
+		files = append(files, filepath.Base(configFile))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to back up config: %w", err)
+	}
+
+	keep := storage.GetSettingInt("backup_keep", 7)
+	removed := 0
+	if keep > 0 {
+		removed += pruneBackups(dir, "singbox-web_", keep)
+		removed += pruneBackups(dir, "config_", keep)
+	}
+	return &Outcome{Detail: fmt.Sprintf("wrote %s, removed %d old backups", strings.Join(files, ", "), removed)}, nil
+}
+
+// pruneBackups removes all but the newest keep files starting with prefix. The
+// timestamp in the name sorts chronologically.
+func pruneBackups(dir, prefix string, keep int) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	removed := 0
+	for i := keep; i < len(names); i++ {
+		if os.Remove(filepath.Join(dir, names[i])) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func joinFailures(failures []string) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
@@ -0,0 +1,298 @@
+// Package scheduler runs the periodic jobs: subscription and rule set refreshes,
+// the sing-box release check, log pruning and backups. Schedules live in the
+// scheduled_tasks table and every run is recorded in job_runs.
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"singbox.arrow.web2/internal/core/singbox"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// Job types
+const (
+	TaskSubscriptions = "subscription_refresh"
+	TaskRulesets      = "ruleset_refresh"
+	TaskBinaryCheck   = "binary_check"
+	TaskLogPrune      = "log_prune"
+	TaskBackup        = "backup"
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerRetry    = "retry"
+)
+
+// ActorScheduler is the actor of operation logs written by scheduled jobs
+const ActorScheduler = "scheduler"
+
+// Run statuses
+const (
+	StatusRunning = "running"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+const (
+	// Failed runs are retried after retryBase, doubling up to retryMax
+	retryBase = time.Minute
+	retryMax  = time.Hour
+
+	// pollInterval bounds how long the loop sleeps, so edits made directly in the
+	// database are picked up as well
+	pollInterval = time.Minute
+)
+
+var (
+	ErrUnknownTask = errors.New("unknown task")
+	ErrRunning     = errors.New("task is already running")
+)
+
+type job struct {
+	name string
+	// run does the work, force refreshes every item instead of the due ones
+	run func(force bool) (*Outcome, error)
+	// reload applies the config when a run changed something
+	reload   bool
+	defaults storage.ScheduledTask
+}
+
+var jobs = map[string]*job{
+	TaskSubscriptions: {
+		name: "Subscription refresh", run: refreshSubscriptions, reload: true,
+		defaults: storage.ScheduledTask{Enabled: true, Interval: 10, Jitter: 30, MaxRetries: 3},
+	},
+	TaskRulesets: {
+		name: "Rule set refresh", run: refreshRulesets, reload: true,
+		defaults: storage.ScheduledTask{Enabled: true, Interval: 10, Jitter: 30, MaxRetries: 3},
+	},
+	TaskBinaryCheck: {
+		name: "sing-box update check", run: checkBinary,
+		defaults: storage.ScheduledTask{Enabled: true, Cron: "0 4 * * *", Jitter: 1800, MaxRetries: 2},
+	},
+	TaskLogPrune: {
+		name: "Log pruning", run: pruneLogs,
+		defaults: storage.ScheduledTask{Enabled: true, Cron: "30 3 * * *", MaxRetries: 1},
+	},
+	TaskBackup: {
+		name: "Backup", run: backup,
+		defaults: storage.ScheduledTask{Enabled: true, Cron: "0 3 * * *", MaxRetries: 2},
+	},
+}
+
+// taskOrder is the order tasks are listed in
+var taskOrder = []string{TaskSubscriptions, TaskRulesets, TaskBinaryCheck, TaskLogPrune, TaskBackup}
+
+var (
+	dataDir string
+
+	mu       sync.Mutex
+	running  = make(map[string]bool)
+	attempts = make(map[string]int) // failed attempts of the current scheduled run
+	wake     = make(chan struct{}, 1)
+)
+
+// Init creates the missing task rows and marks runs left over from a previous
+// process as failed
+func Init(dir string) error {
+	dataDir = dir
+	for _, taskType := range taskOrder {
+		task := jobs[taskType].defaults
+		task.Type = taskType
+		if err := storage.DB.Where(storage.ScheduledTask{Type: taskType}).FirstOrCreate(&task).Error; err != nil {
+			return fmt.Errorf("failed to create task %s: %w", taskType, err)
+		}
+	}
+	now := time.Now()
+	return storage.DB.Model(&storage.JobRun{}).Where("status = ?", StatusRunning).Updates(map[string]interface{}{
+		"status":      StatusFailed,
+		"error":       "interrupted by a restart",
+		"finished_at": now,
+	}).Error
+}
+
+// Start runs the scheduler loop in the background
+func Start() {
+	go func() {
+		for {
+			wait := tick(time.Now())
+			select {
+			case <-time.After(wait):
+			case <-wake:
+			}
+		}
+	}()
+}
+
+func notify() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// tick starts the due tasks and returns how long to sleep until the next one
+func tick(now time.Time) time.Duration {
+	var tasks []storage.ScheduledTask
+	if err := storage.DB.Where("enabled = ?", true).Find(&tasks).Error; err != nil {
+		log.Printf("Scheduler: failed to load tasks: %v", err)
+		return pollInterval
+	}
+
+	wait := pollInterval
+	for i := range tasks {
+		task := tasks[i]
+		if jobs[task.Type] == nil {
+			continue
+		}
+		if task.NextRun == nil {
+			next := nextRun(&task, now)
+			task.NextRun = &next
+			storage.DB.Model(&task).Update("next_run", next)
+		}
+		if task.NextRun.After(now) {
+			if d := task.NextRun.Sub(now); d < wait {
+				wait = d
+			}
+			continue
+		}
+
+		mu.Lock()
+		busy := running[task.Type]
+		trigger := TriggerSchedule
+		if attempts[task.Type] > 0 {
+			trigger = TriggerRetry
+		}
+		if !busy {
+			running[task.Type] = true
+		}
+		mu.Unlock()
+		if busy {
+			continue
+		}
+		go execute(task, trigger)
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// execute runs a task that was marked running and records the run
+func execute(task storage.ScheduledTask, trigger string) *storage.JobRun {
+	defer func() {
+		mu.Lock()
+		delete(running, task.Type)
+		mu.Unlock()
+	}()
+
+	j := jobs[task.Type]
+	mu.Lock()
+	attempt := attempts[task.Type] + 1
+	mu.Unlock()
+	if trigger == TriggerManual {
+		attempt = 1
+	}
+
+	run := &storage.JobRun{
+		TaskType:  task.Type,
+		Trigger:   trigger,
+		Attempt:   attempt,
+		Status:    StatusRunning,
+		StartedAt: time.Now(),
+	}
+	storage.DB.Create(run)
+
+	outcome, err := safeRun(j, trigger == TriggerManual)
+	if outcome != nil {
+		run.Changed = outcome.Changed
+		run.Detail = outcome.Detail
+		// Partial failures still apply what did change
+		if j.reload && outcome.Changed {
+			_, reloaded, reloadErr := singbox.GetManager(dataDir).ApplyChanges()
+			run.Reloaded = reloaded
+			if reloadErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to apply config: %w", reloadErr))
+			}
+		}
+	}
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Status = StatusSuccess
+	if err != nil {
+		run.Status = StatusFailed
+		run.Error = err.Error()
+		log.Printf("Scheduler: %s failed (attempt %d): %v", task.Type, attempt, err)
+	}
+	storage.DB.Save(run)
+
+	updates := map[string]interface{}{
+		"last_run":    run.StartedAt,
+		"last_status": run.Status,
+	}
+	// A manual run leaves the schedule and any pending retry alone
+	if trigger != TriggerManual {
+		var next time.Time
+		mu.Lock()
+		if err != nil && attempts[task.Type] < task.MaxRetries {
+			attempts[task.Type]++
+			next = finished.Add(backoff(attempts[task.Type]))
+		} else {
+			delete(attempts, task.Type)
+			next = nextRun(&task, finished)
+		}
+		mu.Unlock()
+		updates["next_run"] = next
+	}
+	storage.DB.Model(&storage.ScheduledTask{Type: task.Type}).Updates(updates)
+	notify()
+	return run
+}
+
+// safeRun keeps a panicking job from taking the scheduler down
+func safeRun(j *job, force bool) (outcome *Outcome, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return j.run(force)
+}
+
+func backoff(attempt int) time.Duration {
+	d := retryBase << (attempt - 1)
+	if d > retryMax || d <= 0 {
+		d = retryMax
+	}
+	return d
+}
+
+// nextRun returns the next scheduled run after from, with a random jitter
+func nextRun(task *storage.ScheduledTask, from time.Time) time.Time {
+	var next time.Time
+	if task.Cron != "" {
+		if schedule, err := parseCron(task.Cron); err == nil {
+			next = schedule.Next(from)
+		} else {
+			log.Printf("Scheduler: task %s has an invalid cron expression: %v", task.Type, err)
+		}
+	} else if task.Interval > 0 {
+		next = from.Add(time.Duration(task.Interval) * time.Minute)
+	}
+	if next.IsZero() {
+		next = from.Add(24 * time.Hour)
+	}
+	if task.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(task.Jitter)+1)) * time.Second)
+	}
+	return next
+}
@@ -0,0 +1,182 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"singbox.arrow.web2/internal/storage"
+)
+
+// TaskInfo is a task with its schedule and state
+type TaskInfo struct {
+	Type       string     `json:"type"`
+	Name       string     `json:"name"`
+	Enabled    bool       `json:"enabled"`
+	Interval   int        `json:"interval"` // minutes
+	Cron       string     `json:"cron"`
+	Jitter     int        `json:"jitter"` // seconds
+	MaxRetries int        `json:"max_retries"`
+	LastRun    *time.Time `json:"last_run"`
+	NextRun    *time.Time `json:"next_run"`
+	LastStatus string     `json:"last_status"`
+	Running    bool       `json:"running"`
+	// Reloads is set for tasks that apply the config after a change
+	Reloads bool `json:"reloads"`
+}
+
+// TaskUpdate changes the fields that are set. A cron expression takes precedence
+// over the interval, an empty one switches back to it.
+type TaskUpdate struct {
+	Enabled    *bool   `json:"enabled"`
+	Interval   *int    `json:"interval"`
+	Cron       *string `json:"cron"`
+	Jitter     *int    `json:"jitter"`
+	MaxRetries *int    `json:"max_retries"`
+}
+
+// Tasks lists all tasks
+func Tasks() ([]TaskInfo, error) {
+	var rows []storage.ScheduledTask
+	if err := storage.DB.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load tasks: %w", err)
+	}
+	byType := make(map[string]storage.ScheduledTask, len(rows))
+	for _, row := range rows {
+		byType[row.Type] = row
+	}
+
+	tasks := make([]TaskInfo, 0, len(taskOrder))
+	for _, taskType := range taskOrder {
+		row, ok := byType[taskType]
+		if !ok {
+			continue
+		}
+		tasks = append(tasks, newTaskInfo(row))
+	}
+	return tasks, nil
+}
+
+// GetTask returns one task
+func GetTask(taskType string) (*TaskInfo, error) {
+	if jobs[taskType] == nil {
+		return nil, ErrUnknownTask
+	}
+	var row storage.ScheduledTask
+	if err := storage.DB.First(&row, "type = ?", taskType).Error; err != nil {
+		return nil, ErrUnknownTask
+	}
+	info := newTaskInfo(row)
+	return &info, nil
+}
+
+// UpdateTask changes the schedule of a task and plans its next run from now
+func UpdateTask(taskType string, update TaskUpdate) (*TaskInfo, error) {
+	if jobs[taskType] == nil {
+		return nil, ErrUnknownTask
+	}
+	var row storage.ScheduledTask
+	if err := storage.DB.First(&row, "type = ?", taskType).Error; err != nil {
+		return nil, ErrUnknownTask
+	}
+
+	if update.Enabled != nil {
+		row.Enabled = *update.Enabled
+	}
+	if update.Interval != nil {
+		row.Interval = *update.Interval
+	}
+	if update.Cron != nil {
+		row.Cron = *update.Cron
+	}
+	if update.Jitter != nil {
+		row.Jitter = *update.Jitter
+	}
+	if update.MaxRetries != nil {
+		row.MaxRetries = *update.MaxRetries
+	}
+	if err := validateTask(&row); err != nil {
+		return nil, err
+	}
+
+	next := nextRun(&row, time.Now())
+	row.NextRun = &next
+	if err := storage.DB.Save(&row).Error; err != nil {
+		return nil, fmt.Errorf("failed to save task: %w", err)
+	}
+	mu.Lock()
+	delete(attempts, taskType)
+	mu.Unlock()
+	notify()
+
+	info := newTaskInfo(row)
+	return &info, nil
+}
+
+// ValidationError is a task setting that was rejected
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func validateTask(row *storage.ScheduledTask) error {
+	if row.Cron != "" {
+		if _, err := parseCron(row.Cron); err != nil {
+			return &ValidationError{Message: "invalid cron expression: " + err.Error()}
+		}
+	} else if row.Interval < 1 {
+		return &ValidationError{Message: "interval must be at least 1 minute when no cron expression is set"}
+	}
+	if row.Interval < 0 || row.Jitter < 0 || row.MaxRetries < 0 {
+		return &ValidationError{Message: "interval, jitter and max_retries must not be negative"}
+	}
+	if row.MaxRetries > 10 {
+		return &ValidationError{Message: "max_retries must be at most 10"}
+	}
+	return nil
+}
+
+// RunNow runs a task immediately and waits for it. Subscriptions and rule sets are
+// all refreshed, not only the due ones. The schedule is not changed.
+func RunNow(taskType string) (*storage.JobRun, error) {
+	if jobs[taskType] == nil {
+		return nil, ErrUnknownTask
+	}
+	var row storage.ScheduledTask
+	if err := storage.DB.First(&row, "type = ?", taskType).Error; err != nil {
+		return nil, ErrUnknownTask
+	}
+
+	mu.Lock()
+	if running[taskType] {
+		mu.Unlock()
+		return nil, ErrRunning
+	}
+	running[taskType] = true
+	mu.Unlock()
+
+	return execute(row, TriggerManual), nil
+}
+
+func newTaskInfo(row storage.ScheduledTask) TaskInfo {
+	j := jobs[row.Type]
+	mu.Lock()
+	busy := running[row.Type]
+	mu.Unlock()
+	return TaskInfo{
+		Type:       row.Type,
+		Name:       j.name,
+		Enabled:    row.Enabled,
+		Interval:   row.Interval,
+		Cron:       row.Cron,
+		Jitter:     row.Jitter,
+		MaxRetries: row.MaxRetries,
+		LastRun:    row.LastRun,
+		NextRun:    row.NextRun,
+		LastStatus: row.LastStatus,
+		Running:    busy,
+		Reloads:    j.reload,
+	}
+}
@@ -7,17 +7,21 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 
+	"singbox.arrow.web2/internal/core/download"
 	"singbox.arrow.web2/internal/storage"
 )
 
 const (
 	GitHubAPIURL = "https://api.github.com/repos/SagerNet/sing-box/releases/latest"
+
+	// Runtime state keys for the release tag downloaded last and the latest one seen
+	InstalledVersionKey = "singbox_installed_version"
+	LatestVersionKey    = "singbox_latest_version"
 )
 
 type GitHubRelease struct {
@@ -29,21 +33,7 @@ type GitHubRelease struct {
 }
 
 func getHTTPClient() *http.Client {
-	client := &http.Client{}
-
-	proxyEnabled, _ := storage.GetSetting("download_proxy_enabled")
-	proxyURL, _ := storage.GetSetting("download_proxy_url")
-
-	if proxyEnabled == "true" && proxyURL != "" {
-		proxy, err := url.Parse(proxyURL)
-		if err == nil {
-			client.Transport = &http.Transport{
-				Proxy: http.ProxyURL(proxy),
-			}
-		}
-	}
-
-	return client
+	return download.NewClient(0)
 }
 
 func GetLatestVersion() (string, error) {
@@ -152,6 +142,7 @@ func DownloadLatest(dataDir string) (string, error) {
 
 	// Save path to settings
 	storage.SetSetting("singbox_path", binPath)
+	storage.SetRuntimeState(InstalledVersionKey, release.TagName)
 
 	return release.TagName, nil
 }
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -11,11 +12,18 @@ import (
 	"syscall"
 	"time"
 
+	"singbox.arrow.web2/internal/core/corelog"
+	"singbox.arrow.web2/internal/core/generator"
+	"singbox.arrow.web2/internal/core/ruleset"
 	"singbox.arrow.web2/internal/storage"
+	"singbox.arrow.web2/internal/websocket"
 )
 
 type Status string
 
+// ActorSystem is the actor of operation logs written without a user request
+const ActorSystem = "system"
+
 const (
 	StatusStopped Status = "stopped"
 	StatusRunning Status = "running"
@@ -28,7 +36,9 @@ type Manager struct {
 	status     Status
 	dataDir    string
 	configPath string
-	logChan    chan string
+	logs       *websocket.Hub
+	parser     *corelog.Parser
+	files      *corelog.FileWriter
 	stopChan   chan struct{}
 }
 
@@ -41,7 +51,9 @@ func GetManager(dataDir string) *Manager {
 			status:     StatusStopped,
 			dataDir:    dataDir,
 			configPath: filepath.Join(dataDir, "config.json"),
-			logChan:    make(chan string, 1000),
+			logs:       websocket.NewHub(websocket.DefaultBacklog),
+			parser:     corelog.NewParser(),
+			files:      corelog.NewFileWriter(filepath.Join(dataDir, corelog.LogDir), LogFileLimits()),
 		}
 	})
 	return instance
@@ -53,11 +65,32 @@ func (m *Manager) GetStatus() Status {
 	return m.status
 }
 
-func (m *Manager) GetLogChannel() <-chan string {
-	return m.logChan
+// LogHub returns the hub parsed sing-box output is published to
+func (m *Manager) LogHub() *websocket.Hub {
+	return m.logs
+}
+
+// LogFiles returns the writer that keeps sing-box output in rotated files
+func (m *Manager) LogFiles() *corelog.FileWriter {
+	return m.files
+}
+
+// LogFileLimits reads the rotation and retention settings of the core log files
+func LogFileLimits() corelog.Limits {
+	return corelog.Limits{
+		MaxSize:  int64(storage.GetSettingInt("core_log_max_size_mb", 10)) << 20,
+		MaxFiles: storage.GetSettingInt("core_log_max_files", 30),
+		MaxAge:   time.Duration(storage.GetSettingInt("core_log_max_age_days", 14)) * 24 * time.Hour,
+	}
 }
 
 func (m *Manager) Start() error {
+	// Rule sets are downloaded before taking the lock, status and stop calls
+	// must not wait for the network
+	if _, err := os.Stat(m.configPath); os.IsNotExist(err) {
+		ruleset.EnsureDownloaded()
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -66,21 +99,22 @@ func (m *Manager) Start() error {
 	}
 
 	// Get sing-box path
-	singboxPath, err := storage.GetSetting("singbox_path")
-	if err != nil || singboxPath == "" {
-		singboxPath = filepath.Join(m.dataDir, "sing-box")
-	}
+	singboxPath := m.GetBinaryPath()
 
 	// Check if sing-box binary exists
 	if _, err := os.Stat(singboxPath); os.IsNotExist(err) {
 		return fmt.Errorf("sing-box binary not found at %s, please download first", singboxPath)
 	}
 
-	// Check if config exists
+	// Generate config from the database if it does not exist yet
 	if _, err := os.Stat(m.configPath); os.IsNotExist(err) {
-		return fmt.Errorf("config file not found: %s", m.configPath)
+		if _, err := generator.Apply(m.configPath); err != nil {
+			return fmt.Errorf("failed to generate config: %w", err)
+		}
 	}
 
+	m.files.SetLimits(LogFileLimits())
+
 	// Start sing-box
 	m.cmd = exec.Command(singboxPath, "run", "-c", m.configPath)
 	m.cmd.Dir = m.dataDir
@@ -107,13 +141,6 @@ func (m *Manager) Start() error {
 	storage.SetSetting("singbox_pid", fmt.Sprintf("%d", m.cmd.Process.Pid))
 	storage.SetSetting("last_start_time", time.Now().Format(time.RFC3339))
 
-	// Log operation
-	storage.DB.Create(&storage.OperationLog{
-		Action:    "singbox_start",
-		Detail:    fmt.Sprintf("sing-box started with PID %d", m.cmd.Process.Pid),
-		CreatedAt: time.Now(),
-	})
-
 	// Read logs in goroutines
 	go m.readLogs(stdout)
 	go m.readLogs(stderr)
@@ -125,10 +152,7 @@ func (m *Manager) Start() error {
 		if m.status == StatusRunning {
 			if err != nil {
 				m.status = StatusError
-				select {
-				case m.logChan <- fmt.Sprintf("sing-box exited with error: %v", err):
-				default:
-				}
+				m.publish(fmt.Sprintf("ERROR sing-box exited with error: %v", err))
 			} else {
 				m.status = StatusStopped
 			}
@@ -160,13 +184,6 @@ func (m *Manager) Stop() error {
 	m.status = StatusStopped
 	storage.SetSetting("singbox_status", "stopped")
 
-	// Log operation
-	storage.DB.Create(&storage.OperationLog{
-		Action:    "singbox_stop",
-		Detail:    "sing-box stopped",
-		CreatedAt: time.Now(),
-	})
-
 	return nil
 }
 
@@ -181,15 +198,53 @@ func (m *Manager) Restart() error {
 	return m.Start()
 }
 
+// Reload regenerates the config from the database and restarts sing-box.
+// If sing-box is not running it is started with the new config.
+func (m *Manager) Reload() (*generator.Result, error) {
+	ruleset.EnsureDownloaded()
+	result, err := generator.Apply(m.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.Restart(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// ApplyChanges regenerates the config and restarts sing-box only when it is running
+// and the config differs from the last one written. A stopped sing-box picks the new
+// config up on its next start.
+func (m *Manager) ApplyChanges() (*generator.Result, bool, error) {
+	ruleset.EnsureDownloaded()
+	result, err := generator.Apply(m.configPath)
+	if err != nil {
+		return nil, false, err
+	}
+	if !result.Changed || m.GetStatus() != StatusRunning {
+		return result, false, nil
+	}
+	if err := m.Restart(); err != nil {
+		return result, false, err
+	}
+	return result, true, nil
+}
+
 func (m *Manager) readLogs(reader io.Reader) {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
-		line := scanner.Text()
-		select {
-		case m.logChan <- line:
-		default:
-			// Drop log if channel full
-		}
+		m.publish(scanner.Text())
+	}
+}
+
+// publish parses a line, streams it and appends it to the log files
+func (m *Manager) publish(line string) {
+	event := m.parser.Parse(line)
+	m.logs.Publish(event)
+	if err := m.files.Write(event); err != nil {
+		log.Printf("Failed to write sing-box log file: %v", err)
 	}
 }
 
@@ -209,3 +264,12 @@ func (m *Manager) GetConfigPath() string {
 func (m *Manager) GetDataDir() string {
 	return m.dataDir
 }
+
+// GetBinaryPath returns the configured sing-box binary, defaulting to the data directory
+func (m *Manager) GetBinaryPath() string {
+	singboxPath, err := storage.GetSetting("singbox_path")
+	if err != nil || singboxPath == "" {
+		singboxPath = filepath.Join(m.dataDir, "sing-box")
+	}
+	return singboxPath
+}
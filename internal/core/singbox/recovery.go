@@ -57,6 +57,7 @@ func (m *Manager) RecoverFromCrash() error {
 	storage.DB.Create(&storage.OperationLog{
 		Action:    "singbox_recover",
 		Detail:    "Reattached to existing sing-box process",
+		Actor:     ActorSystem,
 		CreatedAt: time.Now(),
 	})
 
@@ -88,6 +89,7 @@ func (m *Manager) restartSingbox() error {
 	storage.DB.Create(&storage.OperationLog{
 		Action:    "singbox_recover",
 		Detail:    "Attempting to restart sing-box after crash",
+		Actor:     ActorSystem,
 		CreatedAt: time.Now(),
 	})
 
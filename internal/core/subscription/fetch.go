@@ -0,0 +1,54 @@
+package subscription
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"singbox.arrow.web2/internal/core/download"
+	"singbox.arrow.web2/internal/core/parser"
+)
+
+const (
+	fetchTimeout = 30 * time.Second
+	maxBodySize  = 32 << 20
+)
+
+// userAgents makes providers return the format we ask for
+var userAgents = map[string]string{
+	parser.FormatAuto:    "clash.meta",
+	parser.FormatClash:   "clash.meta",
+	parser.FormatSingbox: "sing-box",
+	parser.FormatV2Ray:   "v2rayN/6.0",
+	parser.FormatBase64:  "v2rayN/6.0",
+}
+
+// Fetch downloads a subscription through the download proxy
+func Fetch(url, format string) ([]byte, http.Header, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid subscription url: %w", err)
+	}
+	userAgent, ok := userAgents[format]
+	if !ok {
+		userAgent = userAgents[parser.FormatAuto]
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := download.NewClient(fetchTimeout).Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch subscription: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to fetch subscription: status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read subscription: %w", err)
+	}
+	return body, resp.Header, nil
+}
@@ -0,0 +1,177 @@
+package subscription
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"singbox.arrow.web2/internal/core/parser"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// RenameRule rewrites node names, replace may reference capture groups as $1 or ${name}
+type RenameRule struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+// Rules is the processing pipeline configured on a subscription
+type Rules struct {
+	FilterInfo     bool         `json:"filter_info"`
+	IncludePattern string       `json:"include_pattern"`
+	ExcludePattern string       `json:"exclude_pattern"`
+	RenameRules    []RenameRule `json:"rename_rules"`
+	RegionEmoji    bool         `json:"region_emoji"`
+	SortBy         string       `json:"sort_by"`
+	Dedup          bool         `json:"dedup"`
+}
+
+// DroppedNode is a node removed by the pipeline
+type DroppedNode struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// infoPattern matches the pseudo nodes providers use to show quota, expiry and
+// website information. Every alternative needs a value next to its key, so real
+// nodes that merely mention traffic or a reset in their name are kept.
+var infoPattern = regexp.MustCompile(`(?i)` + strings.Join([]string{
+	// 剩余流量：123.45 GB, Traffic: 10GB / 100GB
+	`(流量|traffic|data|bandwidth)\s*(left|remaining)?\s*[:：]\s*\d+(\.\d+)?\s*[KMGTP]i?B?`,
+	// 到期时间：2025-01-01, 套餐到期 2025/01/01, Expire: 2025-01-01
+	`(到期|过期|有效期|expire|expiry)\D{0,12}?\d{2,4}[-/.年]\d{1,2}`,
+	// 距离下次重置剩余：15 天, Reset: 15 days, 下次重置日期：2025-02-01
+	`(重置|reset)\D{0,12}?(\d+\s*(天|日|days?)|\d{2,4}[-/.年]\d{1,2})`,
+	// 剩余：30 天, 30 days left
+	`剩余\s*[:：]?\s*\d+\s*(天|日)`,
+	`\d+\s*days?\s*(left|remaining)`,
+	// 官网：example.com, Website: https://example.com
+	`(官网|网址|官方网站|website)\s*[:：]?\s*\S+\.[a-z]{2,}`,
+}, "|"))
+
+// RulesFromSubscription reads the pipeline settings stored on a subscription
+func RulesFromSubscription(sub *storage.Subscription) Rules {
+	rules := Rules{
+		FilterInfo:     sub.FilterInfo,
+		IncludePattern: sub.IncludePattern,
+		ExcludePattern: sub.ExcludePattern,
+		RegionEmoji:    sub.RegionEmoji,
+		SortBy:         sub.SortBy,
+		Dedup:          sub.Dedup,
+	}
+	if sub.RenameRules != "" {
+		json.Unmarshal([]byte(sub.RenameRules), &rules.RenameRules)
+	}
+	return rules
+}
+
+// Validate checks that all patterns compile and the sort key is known
+func (r *Rules) Validate() error {
+	if _, err := compileOptional(r.IncludePattern); err != nil {
+		return fmt.Errorf("invalid include pattern: %w", err)
+	}
+	if _, err := compileOptional(r.ExcludePattern); err != nil {
+		return fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+	for i, rule := range r.RenameRules {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid rename rule %d: %w", i+1, err)
+		}
+	}
+	switch r.SortBy {
+	case "", "name", "region":
+	default:
+		return fmt.Errorf("unsupported sort key %q", r.SortBy)
+	}
+	return nil
+}
+
+// Process runs the pipeline over nodes. subscriptionID is used to exclude the
+// subscription's own outbounds when deduplicating against the database.
+func Process(nodes []*parser.Node, rules Rules, subscriptionID uint) ([]*parser.Node, []DroppedNode, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, nil, err
+	}
+	include, _ := compileOptional(rules.IncludePattern)
+	exclude, _ := compileOptional(rules.ExcludePattern)
+	renames := make([]*regexp.Regexp, len(rules.RenameRules))
+	for i, rule := range rules.RenameRules {
+		renames[i] = regexp.MustCompile(rule.Pattern)
+	}
+
+	seen := make(map[string]string)
+	if rules.Dedup {
+		var others []storage.Outbound
+		storage.DB.Where("subscription_id IS NULL OR subscription_id <> ?", subscriptionID).Find(&others)
+		for i := range others {
+			seen[parser.OutboundIdentity(&others[i])] = others[i].Name
+		}
+	}
+
+	kept := make([]*parser.Node, 0, len(nodes))
+	dropped := []DroppedNode{}
+	for _, original := range nodes {
+		name := original.Name
+
+		if rules.FilterInfo && infoPattern.MatchString(name) {
+			dropped = append(dropped, DroppedNode{Name: name, Reason: "info entry"})
+			continue
+		}
+		if include != nil && !include.MatchString(name) {
+			dropped = append(dropped, DroppedNode{Name: name, Reason: "not matched by include pattern"})
+			continue
+		}
+		if exclude != nil && exclude.MatchString(name) {
+			dropped = append(dropped, DroppedNode{Name: name, Reason: "matched by exclude pattern"})
+			continue
+		}
+
+		for i, re := range renames {
+			name = re.ReplaceAllString(name, rules.RenameRules[i].Replace)
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = original.Name
+		}
+		if rules.RegionEmoji {
+			name = addRegionEmoji(name)
+		}
+
+		if rules.Dedup {
+			key := original.Identity()
+			if other, ok := seen[key]; ok {
+				dropped = append(dropped, DroppedNode{Name: original.Name, Reason: "duplicate of " + other})
+				continue
+			}
+			seen[key] = name
+		}
+
+		node := *original
+		node.Name = name
+		kept = append(kept, &node)
+	}
+
+	switch rules.SortBy {
+	case "name":
+		sort.SliceStable(kept, func(i, j int) bool { return kept[i].Name < kept[j].Name })
+	case "region":
+		sort.SliceStable(kept, func(i, j int) bool {
+			ri, rj := regionIndex(kept[i].Name), regionIndex(kept[j].Name)
+			if ri != rj {
+				return ri < rj
+			}
+			return kept[i].Name < kept[j].Name
+		})
+	}
+
+	return kept, dropped, nil
+}
+
+func compileOptional(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
@@ -0,0 +1,76 @@
+package subscription
+
+import (
+	"testing"
+
+	"singbox.arrow.web2/internal/core/parser"
+)
+
+func TestFilterInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		info bool
+	}{
+		{"剩余流量：123.45 GB", true},
+		{"剩余流量:1.2TB", true},
+		{"Traffic: 10GB / 100GB", true},
+		{"Remaining Data: 512 MiB", true},
+		{"到期时间：2025-01-01", true},
+		{"套餐到期 2025/01/01", true},
+		{"过期时间: 2025.03.01", true},
+		{"有效期至2025年6月1日", true},
+		{"Expire: 2025-01-01", true},
+		{"Expire Date: 2025/12/31", true},
+		{"距离下次重置剩余：15 天", true},
+		{"下次重置日期：2025-02-01", true},
+		{"Reset: 15 days", true},
+		{"剩余：30 天", true},
+		{"30 days left", true},
+		{"官网：example.com", true},
+		{"Website: https://example.com", true},
+
+		{"🇭🇰 香港 01", false},
+		{"香港 IPLC 流量无限", false},
+		{"日本 03 | 0.5x 流量", false},
+		{"流量倍率：2x 美国", false},
+		{"US Traffic Relay 01", false},
+		{"JP Reset 02", false},
+		{"套餐A 新加坡 01", false},
+		{"Expired Route SG", false},
+		{"官网推荐 台湾", false},
+		{"剩余节点 3", false},
+		{"续费专线 韩国", false},
+	}
+
+	nodes := make([]*parser.Node, len(tests))
+	for i, tt := range tests {
+		nodes[i] = &parser.Node{Name: tt.name}
+	}
+	kept, dropped, err := Process(nodes, Rules{FilterInfo: true}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isDropped := make(map[string]bool, len(dropped))
+	for _, d := range dropped {
+		if d.Reason != "info entry" {
+			t.Errorf("%q dropped as %q", d.Name, d.Reason)
+		}
+		isDropped[d.Name] = true
+	}
+	for _, tt := range tests {
+		if isDropped[tt.name] != tt.info {
+			t.Errorf("%q dropped = %v, want %v", tt.name, isDropped[tt.name], tt.info)
+		}
+	}
+	if len(kept)+len(dropped) != len(tests) {
+		t.Errorf("kept %d and dropped %d of %d nodes", len(kept), len(dropped), len(tests))
+	}
+
+	kept, dropped, err = Process(nodes, Rules{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != len(tests) || len(dropped) != 0 {
+		t.Errorf("without filter_info kept %d and dropped %d of %d nodes", len(kept), len(dropped), len(tests))
+	}
+}
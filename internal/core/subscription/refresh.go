@@ -0,0 +1,230 @@
+package subscription
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"singbox.arrow.web2/internal/core/parser"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// Summary describes what a refresh changed
+type Summary struct {
+	Format    string                `json:"format"`
+	Total     int                   `json:"total"`
+	Added     []string              `json:"added"`
+	Updated   []string              `json:"updated"`
+	Removed   []string              `json:"removed"`
+	Unchanged int                   `json:"unchanged"`
+	Skipped   []parser.SkippedProxy `json:"skipped"`
+	Dropped   []DroppedNode         `json:"dropped"`
+}
+
+// PreviewResult shows the node list before and after the processing pipeline
+type PreviewResult struct {
+	Format  string                `json:"format"`
+	Before  []string              `json:"before"`
+	After   []string              `json:"after"`
+	Dropped []DroppedNode         `json:"dropped"`
+	Skipped []parser.SkippedProxy `json:"skipped"`
+}
+
+// Preview fetches the subscription and runs rules over it without saving anything
+func Preview(sub *storage.Subscription, rules Rules) (*PreviewResult, error) {
+	content, _, err := Fetch(sub.URL, sub.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := parser.Parse(content, sub.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, dropped, err := Process(result.Nodes, rules, sub.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &PreviewResult{
+		Format:  result.Format,
+		Before:  make([]string, 0, len(result.Nodes)),
+		After:   make([]string, 0, len(nodes)),
+		Dropped: dropped,
+		Skipped: result.Skipped,
+	}
+	for _, node := range result.Nodes {
+		preview.Before = append(preview.Before, node.Name)
+	}
+	for _, node := range nodes {
+		preview.After = append(preview.After, node.Name)
+	}
+	if preview.Skipped == nil {
+		preview.Skipped = []parser.SkippedProxy{}
+	}
+	return preview, nil
+}
+
+// Changed reports whether the refresh touched any outbound
+func (s *Summary) Changed() bool {
+	return len(s.Added) > 0 || len(s.Updated) > 0 || len(s.Removed) > 0
+}
+
+// Describe summarizes a refresh of the named subscription for the operation log
+func (s *Summary) Describe(name string) string {
+	return fmt.Sprintf("Subscription %s refreshed: %d added, %d updated, %d removed",
+		name, len(s.Added), len(s.Updated), len(s.Removed))
+}
+
+// Refresh downloads the subscription, parses it and syncs its outbounds
+func Refresh(sub *storage.Subscription) (*Summary, error) {
+	content, header, err := Fetch(sub.URL, sub.Type)
+	if err != nil {
+		return nil, err
+	}
+	recordUsage(sub, header)
+
+	result, err := parser.Parse(content, sub.Type)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Nodes) == 0 {
+		// An empty list is almost always a provider error, keep the old nodes
+		return nil, fmt.Errorf("subscription returned no usable nodes (format %s)", result.Format)
+	}
+
+	nodes, dropped, err := Process(result.Nodes, RulesFromSubscription(sub), sub.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		// Syncing nothing would delete every node and its latency history
+		return nil, fmt.Errorf("filters dropped all %d nodes, keeping the previous nodes", len(result.Nodes))
+	}
+
+	summary, err := Sync(sub.ID, nodes)
+	if err != nil {
+		return nil, err
+	}
+	summary.Format = result.Format
+	summary.Dropped = dropped
+	summary.Skipped = append(result.Skipped, summary.Skipped...)
+	if summary.Skipped == nil {
+		summary.Skipped = []parser.SkippedProxy{}
+	}
+
+	now := time.Now()
+	sub.LastUpdate = &now
+	storage.DB.Model(sub).Update("last_update", now)
+
+	return summary, nil
+}
+
+// Sync diffs nodes against the outbounds stored for subscriptionID.
+// Matched rows keep their ID, enabled flag and latency; only changed rows are written.
+func Sync(subscriptionID uint, nodes []*parser.Node) (*Summary, error) {
+	summary := &Summary{
+		Total:   len(nodes),
+		Added:   []string{},
+		Updated: []string{},
+		Removed: []string{},
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		var existing []storage.Outbound
+		if err := tx.Where("subscription_id = ?", subscriptionID).Order("id").Find(&existing).Error; err != nil {
+			return err
+		}
+
+		// Index existing rows by identity first, then by name for nodes whose credentials rotated
+		byIdentity := make(map[string][]*storage.Outbound)
+		byName := make(map[string][]*storage.Outbound)
+		for i := range existing {
+			out := &existing[i]
+			key := parser.OutboundIdentity(out)
+			byIdentity[key] = append(byIdentity[key], out)
+			byName[out.Name] = append(byName[out.Name], out)
+		}
+		matched := make(map[uint]bool)
+
+		take := func(candidates []*storage.Outbound) *storage.Outbound {
+			for _, out := range candidates {
+				if !matched[out.ID] {
+					matched[out.ID] = true
+					return out
+				}
+			}
+			return nil
+		}
+
+		pending := make([]*parser.Node, 0, len(nodes))
+		pairs := make(map[*parser.Node]*storage.Outbound)
+		for _, node := range nodes {
+			if out := take(byIdentity[node.Identity()]); out != nil {
+				pairs[node] = out
+			} else {
+				pending = append(pending, node)
+			}
+		}
+		for _, node := range pending {
+			if out := take(byName[node.Name]); out != nil {
+				pairs[node] = out
+			}
+		}
+
+		subID := subscriptionID
+		for _, node := range nodes {
+			fresh, err := node.ToOutbound(&subID)
+			if err != nil {
+				summary.Skipped = append(summary.Skipped, parser.SkippedProxy{Name: node.Name, Type: node.Type, Reason: err.Error()})
+				continue
+			}
+
+			out, ok := pairs[node]
+			if !ok {
+				if err := tx.Create(fresh).Error; err != nil {
+					return err
+				}
+				summary.Added = append(summary.Added, fresh.Name)
+				continue
+			}
+
+			if out.Name == fresh.Name && out.Type == fresh.Type && out.Server == fresh.Server &&
+				out.Port == fresh.Port && out.Config == fresh.Config {
+				summary.Unchanged++
+				continue
+			}
+
+			if err := tx.Model(out).Updates(map[string]interface{}{
+				"name":   fresh.Name,
+				"type":   fresh.Type,
+				"server": fresh.Server,
+				"port":   fresh.Port,
+				"config": fresh.Config,
+			}).Error; err != nil {
+				return err
+			}
+			summary.Updated = append(summary.Updated, fresh.Name)
+		}
+
+		for i := range existing {
+			out := &existing[i]
+			if matched[out.ID] {
+				continue
+			}
+			if err := tx.Where("outbound_id = ?", out.ID).Delete(&storage.LatencyHistory{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(out).Error; err != nil {
+				return err
+			}
+			summary.Removed = append(summary.Removed, out.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync outbounds: %w", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,79 @@
+package subscription
+
+import (
+	"regexp"
+	"strings"
+)
+
+type region struct {
+	emoji   string
+	pattern *regexp.Regexp
+}
+
+// newRegion matches keywords case-insensitively and country codes only in upper case
+// and not inside longer words, so "HK01" and "US-2" match while "plus" does not
+func newRegion(emoji, keywords string, codes ...string) region {
+	pattern := "(?i:" + keywords + ")"
+	if len(codes) > 0 {
+		pattern += "|(^|[^A-Za-z])(" + strings.Join(codes, "|") + ")([^A-Za-z]|$)"
+	}
+	return region{emoji: emoji, pattern: regexp.MustCompile(pattern)}
+}
+
+// regions is ordered by how commonly they appear in provider lists, which is also the sort order
+var regions = []region{
+	newRegion("🇭🇰", "香港|港|Hong ?Kong", "HK"),
+	newRegion("🇹🇼", "台湾|台灣|台北|Taiwan", "TW"),
+	newRegion("🇯🇵", "日本|东京|大阪|Japan|Tokyo|Osaka", "JP"),
+	newRegion("🇸🇬", "新加坡|狮城|Singapore", "SG"),
+	newRegion("🇰🇷", "韩国|首尔|Korea|Seoul", "KR"),
+	newRegion("🇺🇸", "美国|洛杉矶|圣何塞|西雅图|纽约|United States|Los Angeles|San Jose|Seattle", "US", "USA"),
+	newRegion("🇬🇧", "英国|伦敦|United Kingdom|London", "UK", "GB"),
+	newRegion("🇩🇪", "德国|法兰克福|Germany|Frankfurt", "DE"),
+	newRegion("🇫🇷", "法国|巴黎|France|Paris", "FR"),
+	newRegion("🇳🇱", "荷兰|阿姆斯特丹|Netherlands|Amsterdam", "NL"),
+	newRegion("🇨🇦", "加拿大|Canada", "CA"),
+	newRegion("🇦🇺", "澳大利亚|澳洲|悉尼|Australia|Sydney", "AU"),
+	newRegion("🇮🇳", "印度|India", "IN"),
+	newRegion("🇷🇺", "俄罗斯|莫斯科|Russia|Moscow", "RU"),
+	newRegion("🇹🇷", "土耳其|Turkey", "TR"),
+	newRegion("🇦🇷", "阿根廷|Argentina", "AR"),
+	newRegion("🇲🇾", "马来西亚|Malaysia", "MY"),
+	newRegion("🇹🇭", "泰国|Thailand", "TH"),
+	newRegion("🇻🇳", "越南|Vietnam", "VN"),
+	newRegion("🇵🇭", "菲律宾|Philippines", "PH"),
+	newRegion("🇨🇳", "中国|回国|China", "CN"),
+}
+
+// addRegionEmoji prefixes the flag of the detected region unless the name already starts with a flag
+func addRegionEmoji(name string) string {
+	if startsWithFlag(name) {
+		return name
+	}
+	for _, r := range regions {
+		if r.pattern.MatchString(name) {
+			return r.emoji + " " + name
+		}
+	}
+	return name
+}
+
+// regionIndex returns the position of the node's region in regions, unknown regions sort last
+func regionIndex(name string) int {
+	for i, r := range regions {
+		if strings.HasPrefix(name, r.emoji) || r.pattern.MatchString(name) {
+			return i
+		}
+	}
+	return len(regions)
+}
+
+// startsWithFlag detects a leading regional indicator pair such as 🇭🇰
+func startsWithFlag(name string) bool {
+	runes := []rune(name)
+	return len(runes) >= 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1])
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
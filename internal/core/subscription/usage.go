@@ -0,0 +1,127 @@
+package subscription
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"singbox.arrow.web2/internal/storage"
+)
+
+// Usage is the traffic quota reported in the subscription-userinfo header
+type Usage struct {
+	Upload   int64
+	Download int64
+	Total    int64
+	Expire   *time.Time
+}
+
+// ParseUserinfo parses "upload=..; download=..; total=..; expire=.."
+func ParseUserinfo(value string) (*Usage, bool) {
+	usage := &Usage{}
+	found := false
+	for _, part := range strings.Split(value, ";") {
+		key, raw, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		// Some providers send floats like 1.2E10
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			continue
+		}
+		n := int64(f)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "upload":
+			usage.Upload = n
+		case "download":
+			usage.Download = n
+		case "total":
+			usage.Total = n
+		case "expire":
+			if n > 0 {
+				expire := time.Unix(n, 0)
+				usage.Expire = &expire
+			}
+		default:
+			continue
+		}
+		found = true
+	}
+	return usage, found
+}
+
+// recordUsage stores the quota from the response headers on the subscription and in its history
+func recordUsage(sub *storage.Subscription, header http.Header) {
+	usage, ok := ParseUserinfo(header.Get("Subscription-Userinfo"))
+	if !ok {
+		return
+	}
+
+	sub.Upload = usage.Upload
+	sub.Download = usage.Download
+	sub.Total = usage.Total
+	sub.Expire = usage.Expire
+	storage.DB.Model(sub).Updates(map[string]interface{}{
+		"upload":   usage.Upload,
+		"download": usage.Download,
+		"total":    usage.Total,
+		"expire":   usage.Expire,
+	})
+
+	storage.DB.Create(&storage.SubscriptionUsage{
+		SubscriptionID: sub.ID,
+		Upload:         usage.Upload,
+		Download:       usage.Download,
+		Total:          usage.Total,
+		Expire:         usage.Expire,
+		CreatedAt:      time.Now(),
+	})
+}
+
+// UsageStatus is the remaining quota of a subscription and any alerts it triggers
+type UsageStatus struct {
+	Remaining    *int64   `json:"remaining"`
+	UsedPercent  *float64 `json:"used_percent"`
+	DaysToExpiry *int     `json:"days_to_expiry"`
+	Alerts       []string `json:"alerts"`
+}
+
+// Status computes the remaining quota and checks it against the alert settings
+func Status(sub *storage.Subscription) UsageStatus {
+	status := UsageStatus{Alerts: []string{}}
+
+	if sub.Total > 0 {
+		remaining := sub.Total - sub.Upload - sub.Download
+		if remaining < 0 {
+			remaining = 0
+		}
+		used := math.Round(float64(sub.Total-remaining)/float64(sub.Total)*1000) / 10
+		status.Remaining = &remaining
+		status.UsedPercent = &used
+
+		quotaPercent := storage.GetSettingInt("subscription_alert_quota_percent", 10)
+		if remaining == 0 {
+			status.Alerts = append(status.Alerts, "quota exhausted")
+		} else if float64(remaining)*100 <= float64(sub.Total*int64(quotaPercent)) {
+			status.Alerts = append(status.Alerts, fmt.Sprintf("less than %d%% quota remaining", quotaPercent))
+		}
+	}
+
+	if sub.Expire != nil {
+		days := int(math.Floor(time.Until(*sub.Expire).Hours() / 24))
+		status.DaysToExpiry = &days
+
+		expireDays := storage.GetSettingInt("subscription_alert_expire_days", 7)
+		if days < 0 {
+			status.Alerts = append(status.Alerts, "subscription expired")
+		} else if days <= expireDays {
+			status.Alerts = append(status.Alerts, fmt.Sprintf("expires in %d days", days))
+		}
+	}
+
+	return status
+}
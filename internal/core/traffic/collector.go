@@ -0,0 +1,186 @@
+// Package traffic records how many bytes each outbound, inbound and rule carried
+// per day, from the connection counters of the running core's Clash API.
+//
+// The counters are sampled, not streamed: a connection that opens and closes
+// between two polls, as most short HTTP requests do, is never seen, and neither
+// are the last bytes of one that closes after a poll. The core's running totals
+// still include those bytes, they are recorded under Unknown for every target
+// type, so each type adds up to what the core actually moved.
+package traffic
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"singbox.arrow.web2/internal/core/clashapi"
+	"singbox.arrow.web2/internal/core/singbox"
+	"singbox.arrow.web2/internal/storage"
+)
+
+// Target types of traffic stats
+const (
+	TargetOutbound = "outbound"
+	TargetInbound  = "inbound"
+	TargetRule     = "rule"
+)
+
+// Unknown is the target name of bytes no open connection accounted for
+const Unknown = "unknown"
+
+// pollInterval is how often the connection counters are read
+const pollInterval = 5 * time.Second
+
+// counters are the byte counts of a connection at the last poll
+type counters struct {
+	upload, download int64
+}
+
+type key struct {
+	targetType, name string
+}
+
+// Collector turns the counters of open connections into per-day totals
+type Collector struct {
+	mu    sync.Mutex
+	conns map[string]counters
+	// totals are the core's counters over all connections since it started
+	totals counters
+	// primed is set after the first successful poll. Connections open at that
+	// point may have been counted by an earlier run, so it only sets a baseline.
+	primed bool
+}
+
+var (
+	collector     *Collector
+	collectorOnce sync.Once
+)
+
+// Start polls the running core in the background
+func Start(manager *singbox.Manager) {
+	collectorOnce.Do(func() {
+		collector = &Collector{conns: make(map[string]counters)}
+		go func() {
+			for range time.Tick(pollInterval) {
+				if err := collector.Poll(manager); err != nil {
+					log.Printf("Traffic: %v", err)
+				}
+			}
+		}()
+	})
+}
+
+// Poll reads the connection counters once and adds the bytes moved since the
+// previous poll to today's rows. A stopped core or one without the Clash API is
+// not an error.
+func (c *Collector) Poll(manager *singbox.Manager) error {
+	api := clashapi.Running(manager)
+	if api == nil {
+		return nil
+	}
+	snapshot, err := api.Connections()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	deltas := make(map[key]counters)
+	var attributed counters
+	open := make(map[string]counters, len(snapshot.Connections))
+	for i := range snapshot.Connections {
+		conn := &snapshot.Connections[i]
+		cur := counters{upload: conn.Upload, download: conn.Download}
+		open[conn.ID] = cur
+		if !c.primed {
+			continue
+		}
+
+		// A connection not seen before, or whose counters went back because the
+		// core restarted and reused the ID, is counted from zero
+		delta := cur
+		if last, ok := c.conns[conn.ID]; ok && cur.upload >= last.upload && cur.download >= last.download {
+			delta = counters{upload: cur.upload - last.upload, download: cur.download - last.download}
+		}
+		if delta.upload == 0 && delta.download == 0 {
+			continue
+		}
+		attributed.upload += delta.upload
+		attributed.download += delta.download
+		for _, k := range []key{
+			{TargetOutbound, conn.Outbound()},
+			{TargetInbound, conn.Inbound()},
+			{TargetRule, conn.MatchedRule()},
+		} {
+			if k.name == "" {
+				continue
+			}
+			total := deltas[k]
+			total.upload += delta.upload
+			total.download += delta.download
+			deltas[k] = total
+		}
+	}
+
+	// Whatever the totals grew by beyond the open connections was moved by
+	// connections that closed in between
+	totals := counters{upload: snapshot.UploadTotal, download: snapshot.DownloadTotal}
+	if c.primed {
+		moved := totals
+		if totals.upload >= c.totals.upload && totals.download >= c.totals.download {
+			moved = counters{upload: totals.upload - c.totals.upload, download: totals.download - c.totals.download}
+		}
+		rest := counters{upload: max(moved.upload-attributed.upload, 0), download: max(moved.download-attributed.download, 0)}
+		if rest.upload > 0 || rest.download > 0 {
+			for _, targetType := range []string{TargetOutbound, TargetInbound, TargetRule} {
+				k := key{targetType, Unknown}
+				total := deltas[k]
+				total.upload += rest.upload
+				total.download += rest.download
+				deltas[k] = total
+			}
+		}
+	}
+
+	// Closed connections are dropped
+	c.conns = open
+	c.totals = totals
+	c.primed = true
+	c.mu.Unlock()
+
+	if len(deltas) == 0 {
+		return nil
+	}
+	return record(time.Now().Format("2006-01-02"), deltas)
+}
+
+// record adds the deltas to the rows of the given day
+func record(date string, deltas map[key]counters) error {
+	return storage.DB.Transaction(func(tx *gorm.DB) error {
+		for k, d := range deltas {
+			result := tx.Model(&storage.TrafficStat{}).
+				Where("target_type = ? AND target_name = ? AND date = ?", k.targetType, k.name, date).
+				Updates(map[string]interface{}{
+					"upload":   gorm.Expr("upload + ?", d.upload),
+					"download": gorm.Expr("download + ?", d.download),
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				continue
+			}
+			stat := storage.TrafficStat{
+				TargetType: k.targetType,
+				TargetName: k.name,
+				Upload:     d.upload,
+				Download:   d.download,
+				Date:       date,
+			}
+			if err := tx.Create(&stat).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
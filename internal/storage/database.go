@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -37,12 +38,17 @@ func InitDatabase(dataDir string) error {
 		&Setting{},
 		&Inbound{},
 		&Subscription{},
+		&SubscriptionUsage{},
 		&Outbound{},
+		&OutboundGroup{},
+		&LatencyHistory{},
 		&Ruleset{},
 		&Rule{},
 		&OperationLog{},
 		&TrafficStat{},
 		&RuntimeState{},
+		&ScheduledTask{},
+		&JobRun{},
 	)
 	if err != nil {
 		return err
@@ -51,6 +57,11 @@ func InitDatabase(dataDir string) error {
 	// Initialize default settings
 	initDefaultSettings()
 
+	// Assign tags to outbounds created before tags existed
+	if err := backfillOutboundTags(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -62,6 +73,22 @@ func initDefaultSettings() {
 		"singbox_path":           "",
 		"download_proxy_enabled": "false",
 		"download_proxy_url":     "",
+		"log_level":              "info",
+		"dns_server":             "local",
+		"route_final":            "direct",
+
+		"subscription_alert_quota_percent": "10",
+		"subscription_alert_expire_days":   "7",
+
+		"log_retention_days": "30",
+		"backup_keep":        "7",
+
+		"clash_api_port":   "9090",
+		"clash_api_secret": generateRandomString(32),
+
+		"core_log_max_size_mb":  "10",
+		"core_log_max_files":    "30",
+		"core_log_max_age_days": "14",
 	}
 
 	// Set default password hash (password: 123)
@@ -96,6 +123,20 @@ func GetSetting(key string) (string, error) {
 	return setting.Value, nil
 }
 
+// GetSettingInt returns a numeric setting, or fallback when it is missing or
+// not a number
+func GetSettingInt(key string, fallback int) int {
+	value, err := GetSetting(key)
+	if err != nil {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
 func SetSetting(key, value string) error {
 	return DB.Save(&Setting{
 		Key:       key,
@@ -103,3 +144,19 @@ func SetSetting(key, value string) error {
 		UpdatedAt: time.Now(),
 	}).Error
 }
+
+func GetRuntimeState(key string) (string, error) {
+	var state RuntimeState
+	if err := DB.Where("key = ?", key).First(&state).Error; err != nil {
+		return "", err
+	}
+	return state.Value, nil
+}
+
+func SetRuntimeState(key, value string) error {
+	return DB.Save(&RuntimeState{
+		Key:       key,
+		Value:     value,
+		UpdatedAt: time.Now(),
+	}).Error
+}
@@ -27,20 +27,43 @@ type Subscription struct {
 	Type           string // auto/singbox/clash/v2ray/base64
 	UpdateInterval int    // hours
 	LastUpdate     *time.Time
-	Enabled        bool `gorm:"default:true"`
+	Enabled        bool  `gorm:"default:true"`
+	Upload         int64 // bytes, from subscription-userinfo
+	Download       int64
+	Total          int64
+	Expire         *time.Time
+	// Node processing, applied on every refresh
+	FilterInfo     bool   `gorm:"default:true"` // drop "Expire: ..." style info entries
+	IncludePattern string // regex, keep only matching names
+	ExcludePattern string // regex, drop matching names
+	RenameRules    string // JSON [{"pattern": "...", "replace": "..."}]
+	RegionEmoji    bool
+	SortBy         string // ""/name/region
+	Dedup          bool   `gorm:"default:true"` // by server:port:credentials across all subscriptions
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
 }
 
+type SubscriptionUsage struct {
+	ID             uint `gorm:"primaryKey"`
+	SubscriptionID uint `gorm:"not null;index"`
+	Upload         int64
+	Download       int64
+	Total          int64
+	Expire         *time.Time
+	CreatedAt      time.Time
+}
+
 type Outbound struct {
 	ID             uint   `gorm:"primaryKey"`
 	SubscriptionID *uint  // NULL for manual
 	Name           string `gorm:"not null"`
+	Tag            string `gorm:"index"` // stable sing-box tag, kept across subscription refreshes
 	Type           string `gorm:"not null"`
 	Server         string
 	Port           int
 	Config         string `gorm:"not null"` // JSON
-	Latency        *int   // ms
+	Latency        *int   // ms, -1 when the last test failed
 	Enabled        bool   `gorm:"default:true"`
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
@@ -48,6 +71,33 @@ type Outbound struct {
 	Subscription *Subscription `gorm:"foreignKey:SubscriptionID"`
 }
 
+type OutboundGroup struct {
+	ID              uint   `gorm:"primaryKey"`
+	Name            string `gorm:"not null"`
+	Tag             string `gorm:"index"`    // shares the namespace with Outbound.Tag
+	Type            string `gorm:"not null"` // selector/urltest/fallback
+	OutboundIDs     string // JSON [1, 2], explicit members in order
+	GroupIDs        string // JSON [3], nested groups
+	SubscriptionIDs string // JSON [1], all nodes of these subscriptions
+	NamePattern     string // regex over outbound names
+	Default         string // selector only, tag of the initially selected member
+	URL             string // urltest/fallback
+	Interval        string // urltest/fallback, e.g. 3m
+	Tolerance       int    // urltest/fallback, ms
+	Enabled         bool   `gorm:"default:true"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+type LatencyHistory struct {
+	ID         uint   `gorm:"primaryKey"`
+	OutboundID uint   `gorm:"not null;index"`
+	Mode       string `gorm:"not null"` // tcp/url
+	Latency    *int   // ms, NULL when the test failed
+	Error      string
+	CreatedAt  time.Time
+}
+
 type Ruleset struct {
 	ID             uint   `gorm:"primaryKey"`
 	Name           string `gorm:"not null"`
@@ -57,36 +107,88 @@ type Ruleset struct {
 	Path           string // local file path
 	UpdateInterval int    // hours
 	LastUpdate     *time.Time
-	Enabled        bool `gorm:"default:true"`
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
+	// Downloaded copy of a remote rule set, relative to the data directory
+	LocalPath    string
+	ETag         string
+	LastModified string
+	LastError    string
+	// Name of a rule set whose domains are exempt from this one, e.g. the
+	// exceptions of an imported filter list
+	Exceptions string
+	Enabled    bool `gorm:"default:true"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 type Rule struct {
 	ID          uint   `gorm:"primaryKey"`
 	Priority    int    `gorm:"not null"`
-	Type        string `gorm:"not null"` // domain/ip/geoip/geosite/ruleset...
+	Type        string `gorm:"not null"` // domain/ip/geoip/geosite/ruleset.../logical
 	Value       string `gorm:"not null"`
-	OutboundTag string `gorm:"not null"`
+	Conditions  string // JSON [{"type": "network", "value": "udp"}], ANDed with Type/Value
+	Mode        string // logical rules: and/or
+	SubRules    string // logical rules: JSON [{"conditions": [...], "invert": false}]
+	Invert      bool
+	Action      string `gorm:"default:route"` // route/reject/hijack-dns/sniff
+	OutboundTag string `gorm:"not null"`      // route only
 	Enabled     bool   `gorm:"default:true"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
 type OperationLog struct {
-	ID        uint   `gorm:"primaryKey"`
-	Action    string `gorm:"not null"`
-	Detail    string
-	CreatedAt time.Time
+	ID     uint   `gorm:"primaryKey"`
+	Action string `gorm:"not null;index"`
+	Detail string
+	// Actor is the user, or scheduler/system for work nobody requested
+	Actor     string `gorm:"index"`
+	ClientIP  string
+	UserAgent string
+	// Target is the entity the operation changed, if any
+	TargetType string `gorm:"index"` // inbound, outbound, group, subscription, ruleset, rule, task, setting
+	TargetID   uint
+	TargetName string
+	// Diff is a JSON object of changed fields, each with its before and after value
+	Diff      string
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// ScheduledTask is the schedule of one scheduler job type
+type ScheduledTask struct {
+	Type       string `gorm:"primaryKey"`
+	Enabled    bool
+	Interval   int    // minutes, used when Cron is empty
+	Cron       string // five field cron expression
+	Jitter     int    // seconds, random delay added to scheduled runs
+	MaxRetries int
+	LastRun    *time.Time
+	NextRun    *time.Time
+	LastStatus string // success/failed
+	UpdatedAt  time.Time
+}
+
+// JobRun records one execution of a scheduler job
+type JobRun struct {
+	ID         uint   `gorm:"primaryKey"`
+	TaskType   string `gorm:"index;not null"`
+	Trigger    string // schedule/manual/retry
+	Attempt    int
+	Status     string // running/success/failed
+	Changed    bool
+	Reloaded   bool
+	Detail     string
+	Error      string
+	StartedAt  time.Time `gorm:"index"`
+	FinishedAt *time.Time
 }
 
 type TrafficStat struct {
 	ID         uint   `gorm:"primaryKey"`
-	TargetType string `gorm:"not null"` // outbound/inbound/rule
-	TargetName string `gorm:"not null"`
+	TargetType string `gorm:"not null;uniqueIndex:idx_traffic_target_date"` // outbound/inbound/rule
+	TargetName string `gorm:"not null;uniqueIndex:idx_traffic_target_date"`
 	Upload     int64  `gorm:"default:0"`
 	Download   int64  `gorm:"default:0"`
-	Date       string `gorm:"not null"` // YYYY-MM-DD
+	Date       string `gorm:"not null;uniqueIndex:idx_traffic_target_date;index"` // YYYY-MM-DD
 }
 
 type RuntimeState struct {
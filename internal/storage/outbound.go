@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// reservedTags are used by the generator itself or carry special meaning in rules
+var reservedTags = map[string]bool{
+	"direct":      true,
+	"block":       true,
+	"reject":      true,
+	"dns-default": true,
+}
+
+// IsReservedTag reports whether tag cannot be used by an outbound
+func IsReservedTag(tag string) bool {
+	return reservedTags[tag]
+}
+
+// BeforeCreate assigns a tag derived from the name when none is set
+func (o *Outbound) BeforeCreate(tx *gorm.DB) error {
+	if o.Tag != "" {
+		return nil
+	}
+	tag, err := UniqueOutboundTag(tx.Session(&gorm.Session{NewDB: true}), o.Name, 0)
+	if err != nil {
+		return err
+	}
+	o.Tag = tag
+	return nil
+}
+
+// BeforeCreate assigns a tag derived from the name when none is set
+func (g *OutboundGroup) BeforeCreate(tx *gorm.DB) error {
+	if g.Tag != "" {
+		return nil
+	}
+	tag, err := UniqueGroupTag(tx.Session(&gorm.Session{NewDB: true}), g.Name, 0)
+	if err != nil {
+		return err
+	}
+	g.Tag = tag
+	return nil
+}
+
+// UniqueOutboundTag returns name, or name with a numeric suffix, that is not
+// reserved and not used by any outbound other than excludeID or any group
+func UniqueOutboundTag(tx *gorm.DB, name string, excludeID uint) (string, error) {
+	return uniqueTag(name, func(tag string) (bool, error) {
+		return OutboundTagTaken(tx, tag, excludeID)
+	})
+}
+
+// UniqueGroupTag is UniqueOutboundTag for groups, excludeID refers to a group
+func UniqueGroupTag(tx *gorm.DB, name string, excludeID uint) (string, error) {
+	return uniqueTag(name, func(tag string) (bool, error) {
+		return GroupTagTaken(tx, tag, excludeID)
+	})
+}
+
+func uniqueTag(name string, taken func(string) (bool, error)) (string, error) {
+	base := strings.TrimSpace(name)
+	if base == "" {
+		base = "outbound"
+	}
+
+	tag := base
+	for i := 2; ; i++ {
+		used, err := taken(tag)
+		if err != nil {
+			return "", err
+		}
+		if !used {
+			return tag, nil
+		}
+		tag = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// OutboundTagTaken reports whether tag is reserved, used by a group, or used by
+// an outbound other than excludeID
+func OutboundTagTaken(tx *gorm.DB, tag string, excludeID uint) (bool, error) {
+	return tagTaken(tx, tag, excludeID, 0)
+}
+
+// GroupTagTaken reports whether tag is reserved, used by an outbound, or used by
+// a group other than excludeID
+func GroupTagTaken(tx *gorm.DB, tag string, excludeID uint) (bool, error) {
+	return tagTaken(tx, tag, 0, excludeID)
+}
+
+// TagExists reports whether tag names the built-in direct outbound, an outbound or a group
+func TagExists(tx *gorm.DB, tag string) (bool, error) {
+	if tag == "direct" {
+		return true, nil
+	}
+	var count int64
+	if err := tx.Model(&Outbound{}).Where("tag = ?", tag).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	if err := tx.Model(&OutboundGroup{}).Where("tag = ?", tag).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func tagTaken(tx *gorm.DB, tag string, outboundID, groupID uint) (bool, error) {
+	if IsReservedTag(tag) {
+		return true, nil
+	}
+	var count int64
+	if err := tx.Model(&Outbound{}).Where("tag = ? AND id <> ?", tag, outboundID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	if err := tx.Model(&OutboundGroup{}).Where("tag = ? AND id <> ?", tag, groupID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func backfillOutboundTags() error {
+	var outbounds []Outbound
+	if err := DB.Where("tag = '' OR tag IS NULL").Order("id").Find(&outbounds).Error; err != nil {
+		return err
+	}
+	for _, out := range outbounds {
+		tag, err := UniqueOutboundTag(DB, out.Name, out.ID)
+		if err != nil {
+			return err
+		}
+		if err := DB.Model(&out).Update("tag", tag).Error; err != nil {
+			return err
+		}
+	}
+	if len(outbounds) > 0 {
+		log.Printf("Assigned tags to %d outbounds", len(outbounds))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"singbox.arrow.web2/internal/core/corelog"
+)
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
+// Upgrader upgrades log stream requests. The API is token protected, so any origin is accepted.
+var Upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+// message is what the client receives: a log entry, or a notice about events
+// dropped because the client could not keep up
+type message struct {
+	Type string `json:"type"` // log/dropped/filter
+	*Entry
+	Dropped uint64  `json:"dropped,omitempty"`
+	Filter  *Filter `json:"filter,omitempty"`
+}
+
+// Serve streams the hub to conn until either side closes. The replayed backlog
+// and every new entry pass through filter; the client may send a Filter as JSON
+// to change it.
+func Serve(conn *websocket.Conn, hub *Hub, filter Filter, replay int) {
+	defer conn.Close()
+
+	backlog, sub := hub.Subscribe(replay)
+	defer sub.Close()
+
+	filters := make(chan Filter, 1)
+	done := make(chan struct{})
+	go readFilters(conn, filters, done)
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for i := range backlog {
+		if filter.Match(&backlog[i]) {
+			if !write(conn, message{Type: "log", Entry: &backlog[i]}) {
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case entry, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if n := sub.Dropped(); n > 0 {
+				if !write(conn, message{Type: "dropped", Dropped: n}) {
+					return
+				}
+			}
+			if filter.Match(&entry) && !write(conn, message{Type: "log", Entry: &entry}) {
+				return
+			}
+		case f := <-filters:
+			filter = f
+			if !write(conn, message{Type: "filter", Filter: &filter}) {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func write(conn *websocket.Conn, msg message) bool {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteJSON(msg) == nil
+}
+
+// readFilters handles pongs and filter updates, invalid ones are ignored
+func readFilters(conn *websocket.Conn, filters chan Filter, done chan<- struct{}) {
+	defer close(done)
+	conn.SetReadLimit(4096)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		var f Filter
+		if json.Unmarshal(data, &f) != nil || (f.Level != "" && !corelog.ValidLevel(f.Level)) {
+			continue
+		}
+		// Only the latest update matters
+		select {
+		case <-filters:
+		default:
+		}
+		filters <- f
+	}
+}
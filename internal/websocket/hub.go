@@ -0,0 +1,171 @@
+// Package websocket fans sing-box log events out to any number of subscribers and
+// streams them to browsers over WebSocket.
+package websocket
+
+import (
+	"strings"
+	"sync"
+
+	"singbox.arrow.web2/internal/core/corelog"
+)
+
+const (
+	// DefaultBacklog is how many events the hub keeps for new subscribers
+	DefaultBacklog = 1000
+
+	// subscriberBuffer is the number of events a subscriber may fall behind before
+	// events are dropped for it, a slow client never blocks the others
+	subscriberBuffer = 256
+)
+
+// Entry is a published event with its sequence number
+type Entry struct {
+	Seq uint64 `json:"seq"`
+	corelog.Event
+}
+
+// Hub broadcasts entries and keeps the last ones in a ring buffer
+type Hub struct {
+	mu          sync.Mutex
+	ring        []Entry
+	next        int // ring index the next entry is written to
+	full        bool
+	seq         uint64
+	subscribers map[*Subscriber]struct{}
+}
+
+// Subscriber receives entries published after it subscribed
+type Subscriber struct {
+	C chan Entry
+
+	hub     *Hub
+	mu      sync.Mutex
+	dropped uint64
+}
+
+func NewHub(backlog int) *Hub {
+	if backlog <= 0 {
+		backlog = DefaultBacklog
+	}
+	return &Hub{
+		ring:        make([]Entry, backlog),
+		subscribers: make(map[*Subscriber]struct{}),
+	}
+}
+
+// Publish records an event and sends it to every subscriber
+func (h *Hub) Publish(event corelog.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	entry := Entry{Seq: h.seq, Event: event}
+	h.ring[h.next] = entry
+	h.next = (h.next + 1) % len(h.ring)
+	if h.next == 0 {
+		h.full = true
+	}
+
+	for s := range h.subscribers {
+		select {
+		case s.C <- entry:
+		default:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Subscribe returns the last replay entries, oldest first, and a subscriber for
+// the ones that follow. Nothing is lost or repeated between the two.
+func (h *Hub) Subscribe(replay int) ([]Entry, *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := &Subscriber{C: make(chan Entry, subscriberBuffer), hub: h}
+	h.subscribers[s] = struct{}{}
+	return h.backlog(replay), s
+}
+
+// Recent returns the last n entries, oldest first
+func (h *Hub) Recent(n int) []Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.backlog(n)
+}
+
+func (h *Hub) backlog(n int) []Entry {
+	size := h.next
+	if h.full {
+		size = len(h.ring)
+	}
+	if n > size {
+		n = size
+	}
+	if n <= 0 {
+		return []Entry{}
+	}
+	entries := make([]Entry, 0, n)
+	start := (h.next - n + len(h.ring)) % len(h.ring)
+	for i := 0; i < n; i++ {
+		entries = append(entries, h.ring[(start+i)%len(h.ring)])
+	}
+	return entries
+}
+
+// Subscribers returns the number of active subscribers
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+// Close unsubscribes, C is closed afterwards
+func (s *Subscriber) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subscribers[s]; ok {
+		delete(s.hub.subscribers, s)
+		close(s.C)
+	}
+}
+
+// Dropped returns and resets the number of entries lost because the subscriber fell behind
+func (s *Subscriber) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+// Filter selects entries by minimum level, a case-insensitive keyword in the raw
+// line and the exact component, inbound or outbound. Empty fields match everything.
+type Filter struct {
+	Level     string `json:"level"`
+	Keyword   string `json:"keyword"`
+	Component string `json:"component"`
+	Inbound   string `json:"inbound"`
+	Outbound  string `json:"outbound"`
+}
+
+// Match reports whether entry passes the filter
+func (f *Filter) Match(entry *Entry) bool {
+	if f.Level != "" && corelog.Severity(entry.Level) < corelog.Severity(f.Level) {
+		return false
+	}
+	if f.Keyword != "" && !strings.Contains(strings.ToLower(entry.Raw), strings.ToLower(f.Keyword)) {
+		return false
+	}
+	if f.Component != "" && entry.Component != f.Component {
+		return false
+	}
+	if f.Inbound != "" && entry.Inbound != f.Inbound {
+		return false
+	}
+	if f.Outbound != "" && entry.Outbound != f.Outbound {
+		return false
+	}
+	return true
+}